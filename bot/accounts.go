@@ -0,0 +1,461 @@
+package bot
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// How long an account rests after being rate limited, if archive.org
+	// doesn't say
+	accountCooldown time.Duration = 5 * time.Minute
+	// How long to wait between trying to log in again with an account
+	loginRetryInterval time.Duration = 30 * time.Minute
+	// How long API keys rest after archive.org rejects them. They can't be
+	// renewed by logging in, so they're tried again after this in case it
+	// was a problem on archive.org's end
+	rejectedKeyCooldown time.Duration = 30 * time.Minute
+	// Separates accounts in COOKIES, ARCHIVE_ORG_KEYS and ARCHIVE_ORG_LOGINS
+	accountSeparator string = "|"
+	// API keys are sent in the Authorization header after this
+	apiKeyPrefix       string = "LOW "
+	archiveOrgLoginUrl string = "https://archive.org/account/login"
+)
+
+// archiveAccount is an archive.org login used to take snapshots
+type archiveAccount struct {
+	// Name is used in logs so the cookie isn't written to them
+	Name string
+	// mu guards Cookie, which changes when the account logs in again
+	mu     sync.Mutex
+	Cookie string
+	// AccessKey and SecretKey are archive.org API keys, used instead of
+	// Cookie if they're set
+	AccessKey string
+	SecretKey string
+	// Email and Password are used to log in again when the cookie expires
+	Email    string
+	Password string
+	// expired accounts aren't used until they log in again
+	expired bool
+	// keyRejected is whether archive.org rejected the API keys the last
+	// time they were used
+	keyRejected   bool
+	loggingIn     bool
+	lastLogin     time.Time
+	loginFailures int
+	// uses has the time of every request made in the last hour
+	uses          []time.Time
+	total         int
+	rateLimited   int
+	cooldownUntil time.Time
+}
+
+// accountPool hands out archive.org accounts in turn, skipping accounts
+// that are cooling down after being rate limited or that have used up
+// their hourly budget
+type accountPool struct {
+	mu       sync.Mutex
+	accounts []*archiveAccount
+	next     int
+	// hourlyBudget is the most requests an account makes in an hour, 0
+	// means no limit
+	hourlyBudget int
+	loginUrl     string
+	// onLoginChange is called when an account's login expires and again
+	// after trying to log in
+	onLoginChange func(a *archiveAccount, change loginChange, err error)
+}
+
+// loginChange is what happened to the login of an archive.org account
+type loginChange int
+
+const (
+	loginExpired loginChange = iota
+	loginRenewed
+	loginFailed
+)
+
+// newAccountPool returns an accountPool with an account for each cookie
+// and API key. cookies has cookies separated by accountSeparator, extra is
+// added to them if it's not empty. Cookies that look like "LOW access:secret"
+// are API keys. keys has access:secret pairs separated by accountSeparator.
+// logins has email:password pairs separated by accountSeparator, which are
+// matched to cookies by email. Logins without a cookie log in the first
+// time they're needed
+func newAccountPool(cookies string, extra string, keys string, logins string, loginUrl string, hourlyBudget int) *accountPool {
+	if loginUrl == "" {
+		loginUrl = archiveOrgLoginUrl
+	}
+	pool := &accountPool{hourlyBudget: hourlyBudget, loginUrl: loginUrl}
+	all := strings.Split(cookies, accountSeparator)
+	if extra != "" {
+		all = append(all, extra)
+	}
+
+	seen := map[string]bool{}
+	for _, cookie := range all {
+		cookie = strings.TrimSpace(cookie)
+		if cookie == "" || seen[cookie] {
+			continue
+		}
+		seen[cookie] = true
+		account := &archiveAccount{Name: fmt.Sprintf("account %d", len(pool.accounts)+1)}
+		if strings.HasPrefix(cookie, apiKeyPrefix) {
+			account.AccessKey, account.SecretKey, _ = strings.Cut(strings.TrimPrefix(cookie, apiKeyPrefix), ":")
+			// So the same keys in ARCHIVE_ORG_KEYS aren't added again
+			seen[account.AccessKey+":"+account.SecretKey] = true
+		} else {
+			account.Cookie = cookie
+		}
+		pool.accounts = append(pool.accounts, account)
+	}
+
+	for _, key := range strings.Split(keys, accountSeparator) {
+		access, secret, found := strings.Cut(strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(key), apiKeyPrefix)), ":")
+		if !found || access == "" || seen[access+":"+secret] {
+			continue
+		}
+		seen[access+":"+secret] = true
+		pool.accounts = append(pool.accounts, &archiveAccount{
+			Name:      fmt.Sprintf("account %d", len(pool.accounts)+1),
+			AccessKey: access,
+			SecretKey: secret,
+		})
+	}
+
+	for _, login := range strings.Split(logins, accountSeparator) {
+		email, password, found := strings.Cut(strings.TrimSpace(login), ":")
+		if !found || email == "" {
+			continue
+		}
+		var account *archiveAccount
+		for _, a := range pool.accounts {
+			if strings.EqualFold(cookieEmail(a.Cookie), email) {
+				account = a
+			}
+		}
+		if account == nil {
+			account = &archiveAccount{
+				Name:    fmt.Sprintf("account %d", len(pool.accounts)+1),
+				expired: true,
+			}
+			pool.accounts = append(pool.accounts, account)
+		}
+		account.Email, account.Password = email, password
+	}
+	return pool
+}
+
+// cookieEmail returns the email of the account a cookie is for
+func cookieEmail(cookie string) string {
+	for _, part := range strings.Split(cookie, ";") {
+		name, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		if name == "logged-in-user" {
+			email, err := url.QueryUnescape(value)
+			if err != nil {
+				return value
+			}
+			return email
+		}
+	}
+	return ""
+}
+
+// Acquire returns the next account that can make a request and counts the
+// request against its budget. With no accounts configured it returns nil,
+// so requests are made without logging in
+func (p *accountPool) Acquire() (*archiveAccount, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.accounts) == 0 {
+		return nil, nil
+	}
+
+	now := time.Now()
+	var available time.Time
+	expired := 0
+	for i := 0; i < len(p.accounts); i++ {
+		a := p.accounts[(p.next+i)%len(p.accounts)]
+		a.prune(now)
+
+		if a.expired {
+			expired++
+			// Accounts with a password log in again in the background
+			p.startLogin(a)
+			continue
+		}
+
+		if now.Before(a.cooldownUntil) {
+			if available.IsZero() || a.cooldownUntil.Before(available) {
+				available = a.cooldownUntil
+			}
+			continue
+		}
+		if p.hourlyBudget > 0 && len(a.uses) >= p.hourlyBudget {
+			// The oldest use falls out of the window first
+			if free := a.uses[0].Add(time.Hour); available.IsZero() || free.Before(available) {
+				available = free
+			}
+			continue
+		}
+
+		p.next = (p.next + i + 1) % len(p.accounts)
+		a.uses = append(a.uses, now)
+		a.total++
+		log.Debugf("using archive.org %s (%d requests in the last hour, %d total, rate limited %d times)",
+			a.Name, len(a.uses), a.total, a.rateLimited)
+		return a, nil
+	}
+	if expired == len(p.accounts) {
+		return nil, fmt.Errorf("every archive.org login has expired")
+	}
+	// Every account is resting, so requests are paused until one is ready
+	return nil, circuitOpenError{service: archiveOrgService, until: available}
+}
+
+// Expire stops using an account because its login is no longer accepted
+// and tries to log in again if it has a password. API keys are rested for
+// rejectedKeyCooldown instead
+func (p *accountPool) Expire(a *archiveAccount, reason string) {
+	p.mu.Lock()
+	if a.expired {
+		p.mu.Unlock()
+		return
+	}
+	if a.AccessKey != "" {
+		// The administrators only need to hear about it once
+		notify := !a.keyRejected
+		a.keyRejected = true
+		a.cooldownUntil = time.Now().Add(rejectedKeyCooldown)
+		onLoginChange := p.onLoginChange
+		p.mu.Unlock()
+
+		log.Errorf("archive.org rejected the API keys for %s, trying them again at %s: %s",
+			a.Name, a.cooldownUntil.Format(time.RFC1123Z), reason)
+		if onLoginChange != nil && notify {
+			onLoginChange(a, loginExpired, fmt.Errorf("%s", reason))
+		}
+		return
+	}
+	a.expired = true
+	a.lastLogin = time.Time{}
+	onLoginChange := p.onLoginChange
+	p.startLogin(a)
+	p.mu.Unlock()
+
+	log.Errorf("archive.org login for %s has expired: %s", a.Name, reason)
+	if onLoginChange != nil {
+		onLoginChange(a, loginExpired, fmt.Errorf("%s", reason))
+	}
+}
+
+// startLogin logs an expired account with a password in again in the
+// background, unless it's already logging in or tried too recently. The
+// pool must be locked
+func (p *accountPool) startLogin(a *archiveAccount) {
+	if !a.expired || a.Password == "" || a.loggingIn || time.Since(a.lastLogin) < loginRetryInterval {
+		return
+	}
+	a.loggingIn = true
+	a.lastLogin = time.Now()
+	go p.login(a)
+}
+
+// login logs an expired account in again and uses the new cookie. It's
+// started by startLogin, so only one runs at a time per account
+func (p *accountPool) login(a *archiveAccount) {
+	p.mu.Lock()
+	loginUrl, onLoginChange := p.loginUrl, p.onLoginChange
+	p.mu.Unlock()
+
+	log.Infof("logging in to archive.org again with %s", a.Name)
+	cookie, err := archiveOrgLogin(loginUrl, a.Email, a.Password)
+
+	p.mu.Lock()
+	a.loggingIn = false
+	if err == nil {
+		a.mu.Lock()
+		a.Cookie = cookie
+		a.mu.Unlock()
+		a.expired = false
+		a.cooldownUntil = time.Time{}
+		a.loginFailures = 0
+	} else {
+		a.loginFailures++
+	}
+	// Only the first failure is worth telling anyone about
+	notify := err == nil || a.loginFailures == 1
+	p.mu.Unlock()
+
+	if err != nil {
+		log.Errorf("unable to log in to archive.org with %s: %v", a.Name, err)
+	} else {
+		log.Infof("logged in to archive.org again with %s", a.Name)
+	}
+	if onLoginChange != nil && notify {
+		change := loginRenewed
+		if err != nil {
+			change = loginFailed
+		}
+		onLoginChange(a, change, err)
+	}
+}
+
+// Accepted records that archive.org accepted an account's login, which
+// matters for API keys that were rejected before
+func (p *accountPool) Accepted(a *archiveAccount) {
+	p.mu.Lock()
+	rejected := a.keyRejected
+	a.keyRejected = false
+	onLoginChange := p.onLoginChange
+	p.mu.Unlock()
+
+	if !rejected {
+		return
+	}
+	log.Infof("archive.org accepted the API keys for %s again", a.Name)
+	if onLoginChange != nil {
+		onLoginChange(a, loginRenewed, nil)
+	}
+}
+
+// LoginExpired logs in again with every expired account that has a password
+func (p *accountPool) LoginExpired() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, a := range p.accounts {
+		p.startLogin(a)
+	}
+}
+
+// OnLoginChange sets the function called when a login expires, is renewed
+// or can't be renewed
+func (p *accountPool) OnLoginChange(onLoginChange func(a *archiveAccount, change loginChange, err error)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.onLoginChange = onLoginChange
+}
+
+// Expired returns the names of accounts whose login has expired or whose
+// API keys were rejected
+func (p *accountPool) Expired() (names []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, a := range p.accounts {
+		if a.expired || a.keyRejected {
+			names = append(names, a.Name)
+		}
+	}
+	return names
+}
+
+// RateLimited puts an account on cooldown for retryAfter, or accountCooldown
+// if that's 0
+func (p *accountPool) RateLimited(a *archiveAccount, retryAfter time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if retryAfter <= 0 {
+		retryAfter = accountCooldown
+	}
+	a.rateLimited++
+	a.cooldownUntil = time.Now().Add(retryAfter)
+	log.Warnf("archive.org %s was rate limited, cooling down until %s", a.Name, a.cooldownUntil.Format(time.RFC1123Z))
+}
+
+// authorize adds the account's API keys or cookie to r
+func (a *archiveAccount) authorize(r *http.Request) {
+	if a.AccessKey != "" {
+		r.Header.Set("Authorization", apiKeyPrefix+a.AccessKey+":"+a.SecretKey)
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	r.Header.Set("Cookie", a.Cookie)
+}
+
+// prune forgets uses from more than an hour ago. The pool must be locked
+func (a *archiveAccount) prune(now time.Time) {
+	expired := 0
+	for _, used := range a.uses {
+		if now.Sub(used) < time.Hour {
+			break
+		}
+		expired++
+	}
+	a.uses = a.uses[expired:]
+}
+
+// retryAfter returns how long a response asked to wait before trying
+// again, or 0 if it didn't say
+func retryAfter(h http.Header) time.Duration {
+	value := strings.TrimSpace(h.Get("Retry-After"))
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return time.Until(at)
+	}
+	return 0
+}
+
+// archiveOrgLogin logs in to archive.org and returns the cookie for the
+// session
+func archiveOrgLogin(loginUrl string, email string, password string) (cookie string, err error) {
+	form := url.Values{
+		"username":     {email},
+		"password":     {password},
+		"remember":     {"true"},
+		"referer":      {"https://archive.org/"},
+		"login":        {"true"},
+		"submit_by_js": {"true"},
+	}
+	r, err := http.NewRequest(http.MethodPost, loginUrl, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("could not build http request: %w", err)
+	}
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("User-Agent", userAgent)
+	// archive.org checks that cookies work before logging in
+	r.Header.Set("Cookie", "test-cookie=1")
+
+	client := http.Client{
+		Timeout: time.Minute,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Do(r)
+	if err != nil {
+		return "", fmt.Errorf("error calling archive.org login: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var parts []string
+	loggedIn := false
+	for _, c := range resp.Cookies() {
+		if c.Value == "" {
+			continue
+		}
+		if c.Name == "logged-in-sig" {
+			loggedIn = true
+		}
+		parts = append(parts, c.Name+"="+c.Value)
+	}
+	if !loggedIn {
+		return "", fmt.Errorf("archive.org login did not succeed (http status code %v)", resp.StatusCode)
+	}
+	return strings.Join(parts, "; "), nil
+}
@@ -0,0 +1,300 @@
+package bot
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestLoginServer stands in for the archive.org login page. Only
+// user@example.com with the password "right" can log in
+func newTestLoginServer(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if c, err := r.Cookie("test-cookie"); err != nil || c.Value != "1" {
+			http.Error(w, "cookies are off", http.StatusBadRequest)
+			return
+		}
+		if r.PostFormValue("username") != "user@example.com" || r.PostFormValue("password") != "right" {
+			// archive.org shows the form again with an error
+			_, _ = w.Write([]byte("Incorrect password!"))
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: "logged-in-user", Value: url.QueryEscape("user@example.com")})
+		http.SetCookie(w, &http.Cookie{Name: "logged-in-sig", Value: "signature"})
+		http.Redirect(w, r, "https://archive.org/", http.StatusFound)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestArchiveOrgLogin(t *testing.T) {
+	server := newTestLoginServer(t)
+
+	cookie, err := archiveOrgLogin(server.URL, "user@example.com", "right")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := "logged-in-user=user%40example.com; logged-in-sig=signature"; cookie != want {
+		t.Errorf("got cookie %q, want %q", cookie, want)
+	}
+	if email := cookieEmail(cookie); email != "user@example.com" {
+		t.Errorf("got email %q from the cookie, want user@example.com", email)
+	}
+
+	if _, err := archiveOrgLogin(server.URL, "user@example.com", "wrong"); err == nil {
+		t.Errorf("got no error logging in with the wrong password")
+	}
+}
+
+func TestAccountPoolLoginExpired(t *testing.T) {
+	server := newTestLoginServer(t)
+	pool := newAccountPool("", "", "", "user@example.com:right|other@example.com:wrong", server.URL, 0)
+
+	type change struct {
+		name   string
+		change loginChange
+	}
+	changes := make(chan change, 2)
+	pool.OnLoginChange(func(a *archiveAccount, c loginChange, err error) {
+		changes <- change{a.Name, c}
+	})
+
+	if _, err := pool.Acquire(); err == nil {
+		t.Fatalf("got an account before logging in")
+	}
+	got := map[string]loginChange{}
+	for len(got) < 2 {
+		select {
+		case c := <-changes:
+			got[c.name] = c.change
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for logins, got %v", got)
+		}
+	}
+	if got["account 1"] != loginRenewed || got["account 2"] != loginFailed {
+		t.Errorf("got login changes %v, want account 1 renewed and account 2 failed", got)
+	}
+
+	a, err := pool.Acquire()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if a.Name != "account 1" || cookieEmail(a.Cookie) != "user@example.com" {
+		t.Errorf("got %s with cookie %q, want account 1 logged in", a.Name, a.Cookie)
+	}
+	if expired := pool.Expired(); len(expired) != 1 || expired[0] != "account 2" {
+		t.Errorf("got expired accounts %v, want account 2", expired)
+	}
+
+	// Logins aren't retried until loginRetryInterval has passed
+	pool.LoginExpired()
+	select {
+	case c := <-changes:
+		t.Errorf("got login change %v straight after a failed login", c)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestAccountPoolOneLoginAtATime(t *testing.T) {
+	var logins int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&logins, 1)
+		time.Sleep(100 * time.Millisecond)
+		_, _ = w.Write([]byte("Incorrect password!"))
+	}))
+	t.Cleanup(server.Close)
+	pool := newAccountPool("", "", "", "user@example.com:wrong", server.URL, 0)
+	failed := make(chan bool, 1)
+	pool.OnLoginChange(func(a *archiveAccount, c loginChange, err error) {
+		failed <- c == loginFailed
+	})
+
+	// Every request for an account, and the bot starting, wants the
+	// expired account logged in again
+	for i := 0; i < 5; i++ {
+		if _, err := pool.Acquire(); err == nil {
+			t.Fatalf("got an account before logging in")
+		}
+		pool.LoginExpired()
+	}
+	select {
+	case f := <-failed:
+		if !f {
+			t.Errorf("got a login change other than loginFailed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for the login")
+	}
+	if n := atomic.LoadInt32(&logins); n != 1 {
+		t.Errorf("logged in %d times, want once", n)
+	}
+}
+
+func TestAccountPoolEmpty(t *testing.T) {
+	// Without accounts snapshots are taken without logging in
+	pool := newAccountPool("", "", "", "", "", 0)
+	if a, err := pool.Acquire(); a != nil || err != nil {
+		t.Errorf("got %v and %v, want no account and no error", a, err)
+	}
+}
+
+// testAccount is the part of an archiveAccount newAccountPool sets up
+type testAccount struct {
+	Cookie    string
+	AccessKey string
+	SecretKey string
+	Email     string
+	Password  string
+	expired   bool
+}
+
+func TestNewAccountPool(t *testing.T) {
+	tests := []struct {
+		name    string
+		cookies string
+		extra   string
+		keys    string
+		logins  string
+		want    []testAccount
+	}{
+		{
+			name: "nothing",
+		},
+		{
+			name:    "cookies and extra",
+			cookies: "logged-in-user=a%40example.com; logged-in-sig=1 | logged-in-user=b%40example.com; logged-in-sig=2",
+			extra:   "logged-in-user=a%40example.com; logged-in-sig=1",
+			want: []testAccount{
+				{Cookie: "logged-in-user=a%40example.com; logged-in-sig=1"},
+				{Cookie: "logged-in-user=b%40example.com; logged-in-sig=2"},
+			},
+		},
+		{
+			name:    "api keys",
+			cookies: "LOW access1:secret1",
+			keys:    "access1:secret1|LOW access2:secret2|invalid",
+			want: []testAccount{
+				{AccessKey: "access1", SecretKey: "secret1"},
+				{AccessKey: "access2", SecretKey: "secret2"},
+			},
+		},
+		{
+			name:    "logins matched to cookies",
+			cookies: "logged-in-user=a%40example.com; logged-in-sig=1",
+			logins:  "A@example.com:password1|b@example.com:password2",
+			want: []testAccount{
+				{Cookie: "logged-in-user=a%40example.com; logged-in-sig=1", Email: "A@example.com", Password: "password1"},
+				{Email: "b@example.com", Password: "password2", expired: true},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pool := newAccountPool(test.cookies, test.extra, test.keys, test.logins, "", 0)
+			if pool.loginUrl != archiveOrgLoginUrl {
+				t.Errorf("got login url %s, want %s", pool.loginUrl, archiveOrgLoginUrl)
+			}
+			if len(pool.accounts) != len(test.want) {
+				t.Fatalf("got %d accounts, want %d", len(pool.accounts), len(test.want))
+			}
+			for i, a := range pool.accounts {
+				got := testAccount{a.Cookie, a.AccessKey, a.SecretKey, a.Email, a.Password, a.expired}
+				if got != test.want[i] {
+					t.Errorf("account %d is %+v, want %+v", i+1, got, test.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestAccountPoolAcquire(t *testing.T) {
+	pool := newAccountPool("cookie1|cookie2", "", "", "", "", 2)
+
+	var names []string
+	for i := 0; i < 4; i++ {
+		a, err := pool.Acquire()
+		if err != nil {
+			t.Fatalf("unexpected error on request %d: %v", i+1, err)
+		}
+		names = append(names, a.Name)
+	}
+	if want := "account 1,account 2,account 1,account 2"; strings.Join(names, ",") != want {
+		t.Errorf("got accounts %s, want %s", strings.Join(names, ","), want)
+	}
+
+	// Both accounts have used their budget for the hour
+	_, err := pool.Acquire()
+	var open circuitOpenError
+	if !errors.As(err, &open) || time.Until(open.until) < 59*time.Minute {
+		t.Errorf("got %v, want a circuitOpenError for about an hour", err)
+	}
+
+	pool = newAccountPool("cookie1|cookie2", "", "", "", "", 0)
+	pool.RateLimited(pool.accounts[0], time.Minute)
+	for i := 0; i < 2; i++ {
+		if a, err := pool.Acquire(); err != nil || a.Name != "account 2" {
+			t.Errorf("got %v and %v, want account 2 while account 1 cools down", a, err)
+		}
+	}
+}
+
+func TestAccountPoolRejectedKey(t *testing.T) {
+	pool := newAccountPool("", "", "access:secret", "", "", 0)
+	var changes []loginChange
+	pool.OnLoginChange(func(a *archiveAccount, c loginChange, err error) {
+		changes = append(changes, c)
+	})
+
+	a := pool.accounts[0]
+	pool.Expire(a, "bad keys")
+	pool.Expire(a, "bad keys")
+	if a.expired {
+		t.Errorf("API keys were expired for good")
+	}
+	if time.Until(a.cooldownUntil) < rejectedKeyCooldown-time.Minute {
+		t.Errorf("API keys are only resting until %s", a.cooldownUntil)
+	}
+	if expired := pool.Expired(); len(expired) != 1 {
+		t.Errorf("got expired accounts %v, want the rejected keys", expired)
+	}
+
+	pool.Accepted(a)
+	pool.Accepted(a)
+	if len(changes) != 2 || changes[0] != loginExpired || changes[1] != loginRenewed {
+		t.Errorf("got login changes %v, want one expired and one renewed", changes)
+	}
+	if expired := pool.Expired(); len(expired) != 0 {
+		t.Errorf("got expired accounts %v after the keys were accepted", expired)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		value string
+		min   time.Duration
+		max   time.Duration
+	}{
+		{"", 0, 0},
+		{"120", 2 * time.Minute, 2 * time.Minute},
+		{"soon", 0, 0},
+		{time.Now().Add(time.Hour).UTC().Format(http.TimeFormat), 58 * time.Minute, time.Hour},
+	}
+
+	for _, test := range tests {
+		h := http.Header{}
+		h.Set("Retry-After", test.value)
+		if got := retryAfter(h); got < test.min || got > test.max {
+			t.Errorf("retryAfter(%q) = %s, want between %s and %s", test.value, got, test.min, test.max)
+		}
+	}
+}
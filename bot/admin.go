@@ -0,0 +1,95 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	log "github.com/sirupsen/logrus"
+	globals "github.com/tyzbit/go-discord-archiver/globals"
+)
+
+// waybackAccounts returns the archive.org accounts used by the Wayback
+// Machine provider, or nil if it isn't configured
+func (bot *ArchiverBot) waybackAccounts() *accountPool {
+	if p, ok := bot.getProvider(waybackProviderName).(waybackProvider); ok {
+		return p.accounts
+	}
+	return nil
+}
+
+// watchArchiveAccounts tells the administrators when an archive.org login
+// expires or is renewed and logs in again with accounts that need it
+func (bot *ArchiverBot) watchArchiveAccounts() {
+	accounts := bot.waybackAccounts()
+	if accounts == nil {
+		return
+	}
+	accounts.OnLoginChange(bot.archiveLoginChanged)
+	accounts.LoginExpired()
+}
+
+// archiveLoginChanged tells the administrators about a change to an
+// archive.org login
+func (bot *ArchiverBot) archiveLoginChanged(a *archiveAccount, change loginChange, err error) {
+	embed := &discordgo.MessageEmbed{Color: globals.BrightRed}
+	switch change {
+	case loginExpired:
+		embed.Title = "⚠️ Archive.org login expired"
+		embed.Description = fmt.Sprintf("The login for %s is no longer accepted (%v), "+
+			"new snapshots won't use it until it's renewed.", a.Name, err)
+		switch {
+		case a.Password != "":
+			embed.Description += " The bot is trying to log in again."
+		case a.AccessKey != "":
+			embed.Title = "⚠️ Archive.org API keys rejected"
+			embed.Description = fmt.Sprintf("The API keys for %s were rejected (%v). The bot will try them "+
+				"again every %d minutes, check the API keys in ARCHIVE_ORG_KEYS.",
+				a.Name, err, int(rejectedKeyCooldown.Minutes()))
+		default:
+			embed.Description += " Update the cookie in the bot's configuration, " +
+				"or add the login to ARCHIVE_ORG_LOGINS so the bot can log in again by itself."
+		}
+	case loginFailed:
+		embed.Title = "⚠️ Archive.org login failed"
+		embed.Description = fmt.Sprintf("Unable to log in to archive.org again with %s: %v", a.Name, err)
+	case loginRenewed:
+		embed.Title = "✅ Archive.org login renewed"
+		embed.Description = fmt.Sprintf("Logged in to archive.org again with %s.", a.Name)
+		if a.AccessKey != "" {
+			embed.Title = "✅ Archive.org API keys accepted"
+			embed.Description = fmt.Sprintf("Archive.org accepted the API keys for %s again.", a.Name)
+		}
+		embed.Color = globals.FrenchGray
+	}
+	bot.notifyAdmins(embed)
+}
+
+// notifyAdmins sends embed to every administrator in ADMINISTRATOR_IDS
+func (bot *ArchiverBot) notifyAdmins(embed *discordgo.MessageEmbed) {
+	for _, id := range strings.Split(bot.Config.AdminIds, ",") {
+		id = strings.TrimSpace(id)
+		if id == "" {
+			continue
+		}
+		channel, err := bot.DG.UserChannelCreate(id)
+		if err != nil {
+			log.Errorf("unable to open a DM with administrator %s: %v", id, err)
+			continue
+		}
+		if _, err := bot.DG.ChannelMessageSendEmbed(channel.ID, embed); err != nil {
+			log.Errorf("unable to message administrator %s: %v", id, err)
+		}
+	}
+}
+
+// degradedReasons returns why the bot can't do everything it should, if
+// anything
+func (bot *ArchiverBot) degradedReasons() (reasons []string) {
+	if accounts := bot.waybackAccounts(); accounts != nil {
+		for _, name := range accounts.Expired() {
+			reasons = append(reasons, fmt.Sprintf("archive.org login for %s has expired", name))
+		}
+	}
+	return append(reasons, serviceGuards.Paused()...)
+}
@@ -0,0 +1,392 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	log "github.com/sirupsen/logrus"
+	"github.com/tyzbit/go-discord-archiver/globals"
+	"gorm.io/gorm"
+)
+
+const (
+	archiveJobQueued  string = "queued"
+	archiveJobRunning string = "running"
+	archiveJobDone    string = "done"
+	archiveJobFailed  string = "failed"
+
+	// Interactive jobs are started before any bulk ones
+	archivePriorityInteractive int = 0
+	archivePriorityBulk        int = 1
+
+	defaultArchiveWorkers int = 4
+	// Most bulk jobs that run at once if BULK_ARCHIVE_WORKERS isn't set
+	defaultBulkArchiveWorkers int = 1
+	// Jobs that can't send their reply are tried this many times
+	archiveJobMaxAttempts int = 3
+	// Workers check for jobs this often even if they aren't told about one
+	archiveJobPollInterval time.Duration = 5 * time.Second
+	// Interaction tokens are good for 15 minutes, replies to jobs older
+	// than this are sent to the channel instead
+	interactionTokenLifetime time.Duration = 14 * time.Minute
+)
+
+// archiveQueue wakes up workers when jobs are added and keeps bulk jobs
+// from taking every worker
+type archiveQueue struct {
+	wake chan bool
+	// mu is held while claiming a job, so bulkRunning stays under
+	// bulkLimit
+	mu          sync.Mutex
+	bulkRunning int
+	bulkLimit   int
+}
+
+// newArchiveJob returns a queued ArchiveJob for messageUrls
+func newArchiveJob(messageUrls []string, newSnapshot bool, options SnapshotOptions) *ArchiveJob {
+	return &ArchiveJob{
+		State: archiveJobQueued,
+		// Jobs are for someone waiting on a reply unless they're marked
+		// as bulk
+		Priority:             archivePriorityInteractive,
+		URLs:                 strings.Join(messageUrls, "\n"),
+		NewSnapshot:          newSnapshot,
+		CaptureOutlinks:      options.CaptureOutlinks,
+		CaptureScreenshot:    options.CaptureScreenshot,
+		SkipIfArchivedWithin: int32(options.SkipIfArchivedWithin.Hours()),
+		JSDelay:              int32(options.JSDelay.Seconds()),
+	}
+}
+
+// urls returns the URLs the job archives
+func (job ArchiveJob) urls() []string {
+	return strings.Fields(job.URLs)
+}
+
+// request returns the settings used for every URL in the job
+func (job ArchiveJob) request() ArchiveRequest {
+	req := ArchiveRequest{
+		Options: SnapshotOptions{
+			CaptureOutlinks:      job.CaptureOutlinks,
+			CaptureScreenshot:    job.CaptureScreenshot,
+			SkipIfArchivedWithin: time.Duration(job.SkipIfArchivedWithin) * time.Hour,
+			JSDelay:              time.Duration(job.JSDelay) * time.Second,
+		},
+	}
+	if job.RequestedTime.Valid {
+		req.At = job.RequestedTime.Time
+	}
+	req.Bulk = job.Priority == archivePriorityBulk
+	req.ServerID, req.UserID = job.ServerID, job.UserID
+	return req
+}
+
+// interaction returns enough of the interaction that made the job to edit
+// its response
+func (job ArchiveJob) interaction() *discordgo.Interaction {
+	i := &discordgo.Interaction{
+		AppID:     job.AppID,
+		Token:     job.InteractionToken,
+		GuildID:   job.ServerID,
+		ChannelID: job.ChannelID,
+	}
+	if job.ServerID != "" {
+		i.Member = &discordgo.Member{User: &discordgo.User{ID: job.UserID}}
+	} else {
+		i.User = &discordgo.User{ID: job.UserID}
+	}
+	return i
+}
+
+// StartArchiveWorkers starts the workers that handle archive jobs. Jobs
+// that were running when the bot stopped are started over
+func (bot *ArchiverBot) StartArchiveWorkers() {
+	workers := defaultArchiveWorkers
+	if bot.Config.ArchiveWorkers > 0 {
+		workers = bot.Config.ArchiveWorkers
+	}
+	// There's always a worker left for interactive jobs, unless there's
+	// only one
+	bulkWorkers := defaultBulkArchiveWorkers
+	if bot.Config.BulkArchiveWorkers > 0 {
+		bulkWorkers = bot.Config.BulkArchiveWorkers
+	}
+	if bulkWorkers >= workers {
+		bulkWorkers = workers - 1
+	}
+	if bulkWorkers < 1 {
+		bulkWorkers = 1
+	}
+	bot.jobs = &archiveQueue{wake: make(chan bool, workers), bulkLimit: bulkWorkers}
+
+	tx := bot.DB.Model(&ArchiveJob{}).Where(&ArchiveJob{State: archiveJobRunning}).Update("state", archiveJobQueued)
+	if tx.Error != nil {
+		log.Errorf("unable to requeue unfinished archive jobs: %v", tx.Error)
+	}
+	var queued int64
+	bot.DB.Model(&ArchiveJob{}).Where(&ArchiveJob{State: archiveJobQueued}).Count(&queued)
+	log.Infof("starting %d archive workers (up to %d for bulk jobs), %d jobs waiting", workers, bulkWorkers, queued)
+
+	for n := 0; n < workers; n++ {
+		go bot.archiveWorker()
+	}
+}
+
+// enqueueArchiveJob saves job and lets a worker know about it
+func (bot *ArchiverBot) enqueueArchiveJob(job *ArchiveJob) error {
+	job.State = archiveJobQueued
+	tx := bot.DB.Create(job)
+	if tx.Error != nil {
+		return fmt.Errorf("unable to save archive job: %w", tx.Error)
+	}
+	if bot.jobs == nil {
+		log.Warn("archive workers aren't running, job will wait")
+		return nil
+	}
+	select {
+	case bot.jobs.wake <- true:
+	default:
+		// Every worker has already been told there's work
+	}
+	return nil
+}
+
+// archiveJobsAhead returns how many queued jobs will be started before job
+func (bot *ArchiverBot) archiveJobsAhead(job *ArchiveJob) (ahead int64) {
+	bot.DB.Model(&ArchiveJob{}).Where("state = ? AND (priority < ? OR (priority = ? AND id < ?))",
+		archiveJobQueued, job.Priority, job.Priority, job.ID).Count(&ahead)
+	return ahead
+}
+
+// archiveWorker handles archive jobs one at a time until the bot shuts down
+func (bot *ArchiverBot) archiveWorker() {
+	life := bot.lifecycle()
+	for {
+		if !life.begin(nil) {
+			return
+		}
+		job, ok := bot.claimArchiveJob()
+		if ok {
+			life.jobStarted(job.ID)
+			bot.runArchiveJob(job)
+			life.jobFinished(job.ID)
+			if job.Priority == archivePriorityBulk {
+				bot.jobs.mu.Lock()
+				bot.jobs.bulkRunning--
+				bot.jobs.mu.Unlock()
+			}
+		}
+		life.end(nil)
+		if ok {
+			continue
+		}
+		select {
+		case <-bot.jobs.wake:
+		case <-time.After(archiveJobPollInterval):
+		case <-life.stopped:
+			return
+		}
+	}
+}
+
+// claimArchiveJob marks the oldest queued job with the lowest priority as
+// running and returns it. Bulk jobs are skipped if bulkLimit of them are
+// already running. ok is false if there are no jobs to start
+func (bot *ArchiverBot) claimArchiveJob() (job ArchiveJob, ok bool) {
+	bot.jobs.mu.Lock()
+	defer bot.jobs.mu.Unlock()
+	for {
+		job = ArchiveJob{}
+		query := bot.DB.Where(&ArchiveJob{State: archiveJobQueued})
+		if bot.jobs.bulkRunning >= bot.jobs.bulkLimit {
+			query = query.Where("priority < ?", archivePriorityBulk)
+		}
+		tx := query.Order("priority").Order("id").Limit(1).Find(&job)
+		if tx.Error != nil {
+			log.Errorf("unable to look up queued archive jobs: %v", tx.Error)
+			return job, false
+		}
+		if tx.RowsAffected == 0 {
+			return job, false
+		}
+
+		// Another worker may have claimed it first, in which case nothing
+		// is updated
+		tx = bot.DB.Model(&ArchiveJob{}).Where("id = ? AND state = ?", job.ID, archiveJobQueued).
+			Updates(map[string]interface{}{"state": archiveJobRunning, "attempts": gorm.Expr("attempts + 1")})
+		if tx.Error != nil {
+			log.Errorf("unable to claim archive job %d: %v", job.ID, tx.Error)
+			return job, false
+		}
+		if tx.RowsAffected == 1 {
+			job.State = archiveJobRunning
+			job.Attempts++
+			if job.Priority == archivePriorityBulk {
+				bot.jobs.bulkRunning++
+			}
+			return job, true
+		}
+	}
+}
+
+// runArchiveJob archives the URLs in job (or the site, for crawls), sends
+// the reply and records how it went. Jobs whose reply couldn't be sent are
+// queued again until they run out of attempts, and only send the reply the
+// next time
+func (bot *ArchiverBot) runArchiveJob(job ArchiveJob) {
+	log.Debugf("starting archive job %d (attempt %d)", job.ID, job.Attempts)
+
+	// Only replies in the channel show the bot typing
+	var typingStop chan bool
+	if job.InteractionToken == "" {
+		typingStop = make(chan bool, 1)
+		go bot.typeInChannel(typingStop, job.ChannelID)
+	}
+
+	var problems []string
+	var err error
+	interrupted := false
+	if job.CrawlPages > 0 {
+		interrupted, err = bot.runCrawlJob(&job)
+	} else {
+		messagesToSend, tracker, errs := bot.buildArchiveJobResponse(&job)
+		for _, err := range errs {
+			if err != nil {
+				log.Errorf("problem handling archive job %d: %v", job.ID, err)
+				problems = append(problems, err.Error())
+			}
+		}
+		err = bot.sendArchiveJobReply(job, messagesToSend, tracker)
+	}
+
+	state := archiveJobDone
+	switch {
+	case interrupted || errors.Is(err, errArchiveJobAbandoned):
+		// The bot is shutting down, so it's started again after the
+		// restart
+		state = archiveJobQueued
+	case err != nil:
+		log.Errorf("unable to send reply for archive job %d: %v", job.ID, err)
+		problems = append(problems, err.Error())
+		state = archiveJobFailed
+		if job.Attempts < archiveJobMaxAttempts {
+			state = archiveJobQueued
+		}
+	}
+	if typingStop != nil {
+		typingStop <- true
+	}
+
+	tx := bot.DB.Model(&ArchiveJob{}).Where("id = ?", job.ID).
+		Updates(map[string]interface{}{"state": state, "last_error": strings.Join(problems, "; ")})
+	if tx.RowsAffected != 1 {
+		log.Errorf("unexpected number of rows affected updating archive job: %v", tx.RowsAffected)
+	}
+}
+
+// sendArchiveJobReply sends the reply for a job. Jobs from interactions
+// edit the interaction response if the token is still good. Otherwise the
+// reply goes to the channel, or to the user if only they were meant to
+// see it
+func (bot *ArchiverBot) sendArchiveJobReply(job ArchiveJob, messagesToSend []*discordgo.MessageSend,
+	tracker *snapshotTracker) error {
+	if len(messagesToSend) == 0 {
+		log.Warnf("no embeds were generated for archive job %d", job.ID)
+		return nil
+	}
+	if !bot.lifecycle().replying(job.ID) {
+		return errArchiveJobAbandoned
+	}
+	for index, message := range messagesToSend {
+		if message == nil {
+			log.Errorf("empty message, not trying to send")
+			messagesToSend[index] = &discordgo.MessageSend{
+				Embeds: []*discordgo.MessageEmbed{{Description: "Error handling interaction"}},
+			}
+		}
+	}
+
+	if job.InteractionToken != "" && time.Since(job.CreatedAt) < interactionTokenLifetime {
+		i := job.interaction()
+		messagesToSend[0].Content = job.Note
+		for _, message := range messagesToSend {
+			if err := bot.sendArchiveCommandResponse(i, message); err != nil {
+				return err
+			}
+		}
+
+		// Update the reply as snapshots that are still being taken finish
+		if tracker != nil {
+			bot.startSnapshotTracker(tracker, func(index int, message *discordgo.MessageSend) error {
+				return bot.editArchiveCommandResponse(i, message)
+			})
+		}
+		return nil
+	}
+
+	m := discordgo.Message{
+		Member: &discordgo.Member{
+			User: &discordgo.User{
+				ID: job.UserID,
+			},
+		},
+		GuildID:   job.ServerID,
+		ChannelID: job.ChannelID,
+	}
+	if job.Ephemeral {
+		channel, err := bot.DG.UserChannelCreate(job.UserID)
+		if err != nil {
+			return fmt.Errorf("unable to message user %s: %w", job.UserID, err)
+		}
+		m.ChannelID = channel.ID
+		m.GuildID = ""
+	}
+
+	var botMessages []*discordgo.Message
+	for _, message := range messagesToSend {
+		if job.InteractionToken != "" {
+			message.Content = globals.LateReplyText
+		}
+		// Auto-archive replies go under the message with the links
+		if job.MessageID != "" && !job.Ephemeral {
+			message.Reference = &discordgo.MessageReference{
+				MessageID: job.MessageID,
+				ChannelID: job.ChannelID,
+				GuildID:   job.ServerID,
+			}
+		}
+		botMessage, err := bot.sendArchiveResponse(&m, message)
+		if err != nil {
+			return err
+		}
+		botMessages = append(botMessages, botMessage)
+	}
+
+	// One tracker keeps every message of the reply up to date
+	if tracker != nil {
+		bot.startSnapshotTracker(tracker, func(index int, message *discordgo.MessageSend) error {
+			if index >= len(botMessages) {
+				return nil
+			}
+			return bot.editArchiveResponse(botMessages[index], message)
+		})
+	}
+	return nil
+}
+
+// reportQueuePosition lets the user know how many requests are ahead of
+// theirs, if any
+func (bot *ArchiverBot) reportQueuePosition(i *discordgo.Interaction, job *ArchiveJob) {
+	ahead := bot.archiveJobsAhead(job)
+	if ahead == 0 {
+		return
+	}
+	content := fmt.Sprintf("⏳ Your request is queued behind %d others.", ahead)
+	if _, err := bot.DG.InteractionResponseEdit(i, &discordgo.WebhookEdit{Content: &content}); err != nil {
+		log.Errorf("unable to report queue position for archive job %d: %v", job.ID, err)
+	}
+}
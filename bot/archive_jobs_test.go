@@ -0,0 +1,54 @@
+package bot
+
+import "testing"
+
+func TestClaimArchiveJob(t *testing.T) {
+	bot, _ := newTestBot(t)
+	bot.jobs = &archiveQueue{wake: make(chan bool, 1), bulkLimit: 1}
+
+	for _, job := range []ArchiveJob{
+		{URLs: "https://example.com/running", State: archiveJobRunning},
+		{URLs: "https://example.com/bulk1", State: archiveJobQueued, Priority: archivePriorityBulk},
+		{URLs: "https://example.com/bulk2", State: archiveJobQueued, Priority: archivePriorityBulk},
+		{URLs: "https://example.com/interactive1", State: archiveJobQueued},
+		{URLs: "https://example.com/interactive2", State: archiveJobQueued, Attempts: 1},
+	} {
+		if tx := bot.DB.Create(&job); tx.Error != nil {
+			t.Fatalf("unable to save archive job: %v", tx.Error)
+		}
+	}
+
+	// Jobs someone is waiting on go first, and only bulkLimit bulk jobs
+	// run at once
+	claim := func(want string, wantAttempts int) {
+		t.Helper()
+		job, ok := bot.claimArchiveJob()
+		if want == "" {
+			if ok {
+				t.Errorf("claimed job for %s, want none", job.URLs)
+			}
+			return
+		}
+		if !ok || job.URLs != want {
+			t.Fatalf("claimed job for %s (%v), want %s", job.URLs, ok, want)
+		}
+		var saved ArchiveJob
+		bot.DB.First(&saved, job.ID)
+		if saved.State != archiveJobRunning || saved.Attempts != wantAttempts || job.Attempts != wantAttempts {
+			t.Errorf("%s is %s after %d attempts, want running after %d", want, saved.State, saved.Attempts,
+				wantAttempts)
+		}
+	}
+	claim("https://example.com/interactive1", 1)
+	claim("https://example.com/interactive2", 2)
+	claim("https://example.com/bulk1", 1)
+	claim("", 0)
+	if bot.jobs.bulkRunning != 1 {
+		t.Errorf("got %d bulk jobs running, want 1", bot.jobs.bulkRunning)
+	}
+
+	// The next bulk job starts once the first is done
+	bot.jobs.bulkRunning--
+	claim("https://example.com/bulk2", 1)
+	claim("", 0)
+}
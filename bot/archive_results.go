@@ -0,0 +1,115 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+)
+
+// archiveOutcome is how archiving a URL went
+type archiveOutcome int
+
+const (
+	// archiveSucceeded is a snapshot that was found or taken just now
+	archiveSucceeded archiveOutcome = iota
+	// archiveCached is a snapshot found by an earlier request
+	archiveCached
+	// archiveLocalCopy is a page that only has a local copy
+	archiveLocalCopy
+	// archivePending is a snapshot that's still being taken
+	archivePending
+	// archiveFailed has the reason in Reason
+	archiveFailed
+)
+
+// archiveResult is the outcome of archiving one requested URL
+type archiveResult struct {
+	URL     string
+	Outcome archiveOutcome
+	// Link is the main snapshot, or a note about the outcome if there
+	// isn't one
+	Link string
+	// Provider is where Link came from
+	Provider string
+	Reason   string
+}
+
+// Failed returns whether no snapshot could be found or taken
+func (r archiveResult) Failed() bool {
+	return r.Outcome == archiveFailed
+}
+
+// archiveResults returns the result for each of requestUrls, in order and
+// without repeats. statuses has the results of finished snapshot jobs, by
+// job ID
+func archiveResults(archives []ArchiveEvent, requestUrls []string, statuses map[string]SnapshotJobStatus) (results []archiveResult) {
+	seen := map[string]bool{}
+	for _, requestUrl := range requestUrls {
+		if seen[requestUrl] {
+			continue
+		}
+		seen[requestUrl] = true
+		results = append(results, archiveResultFor(archives, requestUrl, statuses))
+	}
+	return results
+}
+
+// archiveResultFor returns the result for requestUrl: the snapshot from the
+// first provider that has one, a local copy, the state of a snapshot job
+// or why there's nothing
+func archiveResultFor(archives []ArchiveEvent, requestUrl string, statuses map[string]SnapshotJobStatus) archiveResult {
+	result := archiveResult{URL: requestUrl, Outcome: archiveFailed}
+	var localCapture, pending, atTime bool
+	var reasons []string
+	for _, archive := range archives {
+		if archive.RequestURL != requestUrl {
+			continue
+		}
+		if archive.ResponseURL != "" {
+			result.Link = archive.ResponseURL
+			if strings.HasPrefix(result.Link, "http://") {
+				result.Link = "https://" + strings.SplitN(result.Link, "http://", 2)[1]
+			}
+			result.Provider = archive.Provider
+			result.Outcome = archiveSucceeded
+			if archive.Cached {
+				result.Outcome = archiveCached
+			}
+			return result
+		}
+		if archive.RequestedTime.Valid {
+			atTime = true
+		}
+		if archive.LocalCapturePath != "" {
+			localCapture = true
+		}
+		if archive.SnapshotJobID != "" {
+			status, done := statuses[archive.SnapshotJobID]
+			switch {
+			case !done:
+				pending = true
+			case status.Err != nil:
+				reasons = append(reasons, fmt.Sprintf("Unable to take a snapshot: %v", status.Err))
+			}
+			continue
+		}
+		if archive.Error != "" {
+			reasons = append(reasons, archive.Error)
+		}
+	}
+
+	switch {
+	case localCapture:
+		result.Outcome = archiveLocalCopy
+		result.Link = "No snapshot could be found, so a local copy was saved instead."
+	case pending:
+		result.Outcome = archivePending
+		result.Link = snapshotPendingText
+	case len(reasons) > 0:
+		result.Reason = strings.Join(reasons, "\n")
+	case atTime:
+		result.Reason = "No snapshot could be found near the requested date."
+	default:
+		result.Reason = "No snapshot could be found."
+	}
+	return result
+}
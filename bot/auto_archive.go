@@ -0,0 +1,189 @@
+package bot
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	log "github.com/sirupsen/logrus"
+	globals "github.com/tyzbit/go-discord-archiver/globals"
+)
+
+// MessageCreateHandler archives the links in messages posted in channels
+// with auto-archive turned on. It's only registered if AUTO_ARCHIVE is set,
+// because it needs the message content intent
+func (bot *ArchiverBot) MessageCreateHandler(s *discordgo.Session, m *discordgo.MessageCreate) {
+	if m.GuildID == "" || m.Author == nil || m.Author.ID == s.State.User.ID {
+		return
+	}
+
+	cc, ok := bot.getChannelConfig(m.GuildID, m.ChannelID)
+	if !ok || !cc.AutoArchive.Valid || !cc.AutoArchive.Bool || !cc.respondsIn() {
+		return
+	}
+	fromBot := m.Author.Bot || m.WebhookID != ""
+	if fromBot && !(cc.IncludeBots.Valid && cc.IncludeBots.Bool) {
+		return
+	}
+	// Private replies are sent to whoever posted the links, which bots
+	// can't get
+	if fromBot && cc.forcesPrivate() {
+		return
+	}
+
+	sc := bot.getServerConfig(m.GuildID)
+	if sc.ArchiveEnabled.Valid && !sc.ArchiveEnabled.Bool {
+		return
+	}
+
+	// Feeds often put the link in an embed instead of the message
+	messageUrls, _ := bot.extractMessageUrls(m.Content)
+	if fromBot {
+		for _, embed := range m.Embeds {
+			if embed.URL != "" {
+				messageUrls = append(messageUrls, embed.URL)
+			}
+		}
+	}
+	if len(messageUrls) == 0 {
+		return
+	}
+
+	life := bot.lifecycle()
+	if !life.begin(nil) {
+		return
+	}
+	defer life.end(nil)
+
+	job := newArchiveJob(messageUrls, false, snapshotOptions(sc))
+	job.ServerID = m.GuildID
+	job.ChannelID = m.ChannelID
+	job.MessageID = m.ID
+	job.UserID = m.Author.ID
+	job.Ephemeral = cc.forcesPrivate()
+	// Nobody is waiting on replies to feeds
+	if fromBot {
+		job.Priority = archivePriorityBulk
+	}
+
+	quota, err := bot.takeJobQuota(job)
+	if err != nil {
+		log.Debugf("not auto-archiving links from message %s in channel %s: %v", m.ID, m.ChannelID, err)
+		// Feeds can't do anything about it, and people only need to hear
+		// it once until they can take snapshots again
+		if !fromBot && autoArchiveQuotaNotices.notify(m.GuildID, m.Author.ID, err) {
+			if err := bot.sendArchiveJobReply(*job, []*discordgo.MessageSend{quotaExceededReply(err)}, nil); err != nil {
+				log.Errorf("unable to tell user %s they're over their quota: %v", m.Author.ID, err)
+			}
+		}
+		return
+	}
+	log.Debugf("auto-archiving %d links from message %s in channel %s", len(messageUrls), m.ID, m.ChannelID)
+	if err := bot.enqueueArchiveJob(job); err != nil {
+		log.Errorf("unable to queue auto-archive request: %v", err)
+		bot.refundSnapshotQuota(quota)
+	}
+}
+
+// quotaNotices is when each user in each server can next be told they're
+// over their quota
+type quotaNotices struct {
+	mu    sync.Mutex
+	until map[string]time.Time
+}
+
+// autoArchiveQuotaNotices keeps auto-archive channels from telling people
+// they're over their quota for every message they post
+var autoArchiveQuotaNotices = &quotaNotices{until: map[string]time.Time{}}
+
+// notify returns whether the user should be told about err, which is the
+// case once until the quota or cooldown in err is over
+func (n *quotaNotices) notify(serverID string, userID string, err error) bool {
+	now := time.Now()
+	until := now.Add(time.Hour)
+	var exceeded quotaExceededError
+	if errors.As(err, &exceeded) && !exceeded.until.IsZero() {
+		until = exceeded.until
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for key, t := range n.until {
+		if now.After(t) {
+			delete(n.until, key)
+		}
+	}
+	key := serverID + ":" + userID
+	if _, ok := n.until[key]; ok {
+		return false
+	}
+	n.until[key] = until
+	return true
+}
+
+// autoArchiveInteraction turns auto-archive on or off for a channel
+func (bot *ArchiverBot) autoArchiveInteraction(i *discordgo.InteractionCreate) {
+	if i.GuildID == "" {
+		err := bot.DG.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: bot.settingsDMFailureIntegrationResponse(),
+		})
+		if err != nil {
+			log.Errorf("error responding to auto-archive DM, err: %v", err)
+		}
+		return
+	}
+
+	// The channel the command is used in, unless another one is picked
+	channelID := i.ChannelID
+	options := i.ApplicationCommandData().Options
+	for _, option := range options {
+		if option.Name == globals.ChannelOption {
+			channelID = option.ChannelValue(nil).ID
+		}
+	}
+	cc := bot.channelConfig(i.GuildID, channelID)
+	for _, option := range options {
+		switch option.Name {
+		case globals.EnabledOption:
+			cc.AutoArchive = sql.NullBool{Bool: option.BoolValue(), Valid: true}
+		case globals.IncludeBotsOption:
+			cc.IncludeBots = sql.NullBool{Bool: option.BoolValue(), Valid: true}
+		}
+	}
+
+	description := fmt.Sprintf("Auto-archive is %s in <#%s>.", globals.Enabled[cc.AutoArchive.Bool], cc.ChannelID)
+	if cc.AutoArchive.Bool {
+		if cc.IncludeBots.Valid && cc.IncludeBots.Bool {
+			description += " Links posted by bots and webhooks are archived too."
+		} else {
+			description += " Links posted by bots and webhooks are ignored."
+		}
+	}
+	color := globals.FrenchGray
+	if tx := bot.DB.Save(&cc); tx.Error != nil {
+		log.Errorf("unable to save channel config for %s: %v", cc.ChannelID, tx.Error)
+		description = "Unable to update auto-archive, please try again."
+		color = globals.BrightRed
+	} else if cc.AutoArchive.Bool && !bot.Config.AutoArchive {
+		description += "\n\nThe bot can't read messages yet, so nothing will be archived until whoever runs it turns on auto-archive."
+	}
+
+	err := bot.DG.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Flags: discordgo.MessageFlagsEphemeral,
+			Embeds: []*discordgo.MessageEmbed{{
+				Title:       "🗄️ Auto-archive",
+				Description: description,
+				Color:       color,
+			}},
+		},
+	})
+	if err != nil {
+		log.Errorf("error responding to slash command "+globals.AutoArchive+", err: %v", err)
+	}
+}
@@ -0,0 +1,30 @@
+package bot
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestQuotaNotices(t *testing.T) {
+	n := &quotaNotices{until: map[string]time.Time{}}
+	cooldown := quotaExceededError{reason: "cooldown", until: time.Now().Add(50 * time.Millisecond)}
+
+	if !n.notify("server", "user", cooldown) {
+		t.Errorf("the first notice wasn't sent")
+	}
+	if n.notify("server", "user", cooldown) {
+		t.Errorf("the notice was sent again during the cooldown")
+	}
+	if !n.notify("server", "other", cooldown) || !n.notify("other", "user", cooldown) {
+		t.Errorf("the notice wasn't sent to someone else")
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if !n.notify("server", "user", fmt.Errorf("over the quota")) {
+		t.Errorf("the notice wasn't sent again after the cooldown")
+	}
+	if n.until["server:user"].Before(time.Now().Add(59 * time.Minute)) {
+		t.Errorf("a quota with no end is only quiet until %s, want an hour", n.until["server:user"])
+	}
+}
@@ -0,0 +1,109 @@
+package bot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	cdxApi      string = "https://web.archive.org/cdx/search/cdx"
+	cdxPageSize int    = 10
+)
+
+// cdxCapture is a single capture of a URL from the Wayback Machine CDX index
+type cdxCapture struct {
+	Timestamp  string
+	Original   string
+	MimeType   string
+	StatusCode string
+	Digest     string
+}
+
+// cdxQuery is a page of captures to look up for a URL. StatusCode and Year
+// are only used to filter captures if they're not 0
+type cdxQuery struct {
+	URL        string
+	Page       int
+	StatusCode int
+	Year       int
+}
+
+// queryCDX returns the captures on the page of the CDX index that q asks
+// for, oldest first, and whether there are any more pages
+func queryCDX(q cdxQuery, attempts uint) (captures []cdxCapture, more bool, err error) {
+	return queryCDXApi(cdxApi, q, attempts)
+}
+
+// queryCDXApi is queryCDX for the CDX API at api
+func queryCDXApi(api string, q cdxQuery, attempts uint) (captures []cdxCapture, more bool, err error) {
+	params := url.Values{
+		"url":    {q.URL},
+		"output": {"json"},
+		"fl":     {"timestamp,original,mimetype,statuscode,digest"},
+		// One extra to tell if there's another page
+		"limit":  {fmt.Sprint(cdxPageSize + 1)},
+		"offset": {fmt.Sprint(q.Page * cdxPageSize)},
+	}
+	if q.StatusCode != 0 {
+		params.Set("filter", fmt.Sprintf("statuscode:%d", q.StatusCode))
+	}
+	if q.Year != 0 {
+		params.Set("from", fmt.Sprint(q.Year))
+		params.Set("to", fmt.Sprint(q.Year))
+	}
+
+	var rows [][]string
+	err = retryRequest(attempts, func() error {
+		r, err := http.NewRequest(http.MethodGet, api+"?"+params.Encode(), nil)
+		if err != nil {
+			return fmt.Errorf("could not build http request: %w", err)
+		}
+		r.Header.Set("User-Agent", userAgent)
+
+		client := http.Client{Timeout: time.Minute}
+		resp, err := guardedDo(archiveOrgService, &client, r)
+		if err != nil {
+			return fmt.Errorf("error calling archive.org cdx api: %w", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("archive.org cdx api had unexpected http status code: %v", resp.StatusCode)
+		}
+
+		rows = [][]string{}
+		// An empty body means there are no captures. It isn't always sent
+		// with a Content-Length, so check for nothing to decode instead
+		if err := json.NewDecoder(resp.Body).Decode(&rows); err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("error unmarshalling json: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return captures, more, err
+	}
+
+	// The first row has the field names
+	for i, row := range rows {
+		if i == 0 || len(row) < 5 {
+			continue
+		}
+		captures = append(captures, cdxCapture{
+			Timestamp:  row[0],
+			Original:   row[1],
+			MimeType:   row[2],
+			StatusCode: row[3],
+			Digest:     row[4],
+		})
+	}
+
+	if len(captures) > cdxPageSize {
+		captures = captures[:cdxPageSize]
+		more = true
+	}
+	return captures, more, nil
+}
@@ -0,0 +1,82 @@
+package bot
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+// newTestCDXServer stands in for the CDX API. http://example.com/ has 13
+// captures, anything else has none and gets an empty chunked body
+func newTestCDXServer(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if query.Get("url") != "http://example.com/" {
+			// Flushing before writing anything sends the body chunked,
+			// without a Content-Length
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+			return
+		}
+
+		offset, _ := strconv.Atoi(query.Get("offset"))
+		limit, _ := strconv.Atoi(query.Get("limit"))
+		rows := [][]string{{"timestamp", "original", "mimetype", "statuscode", "digest"}}
+		for i := offset; i < 13 && i < offset+limit; i++ {
+			rows = append(rows, []string{fmt.Sprintf("20200101%06d", i), "http://example.com/", "text/html", "200",
+				fmt.Sprintf("DIGEST%d", i)})
+		}
+		_ = json.NewEncoder(w).Encode(rows)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestQueryCDX(t *testing.T) {
+	server := newTestCDXServer(t)
+
+	tests := []struct {
+		name      string
+		q         cdxQuery
+		wantCount int
+		wantFirst string
+		wantMore  bool
+	}{
+		{
+			name:      "first page",
+			q:         cdxQuery{URL: "http://example.com/"},
+			wantCount: cdxPageSize,
+			wantFirst: "20200101000000",
+			wantMore:  true,
+		},
+		{
+			name:      "last page",
+			q:         cdxQuery{URL: "http://example.com/", Page: 1},
+			wantCount: 3,
+			wantFirst: "20200101000010",
+		},
+		{
+			name: "no captures",
+			q:    cdxQuery{URL: "http://example.net/"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			captures, more, err := queryCDXApi(server.URL, test.q, 1)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(captures) != test.wantCount || more != test.wantMore {
+				t.Fatalf("got %d captures and more %v, want %d and %v", len(captures), more, test.wantCount,
+					test.wantMore)
+			}
+			if test.wantCount > 0 && captures[0].Timestamp != test.wantFirst {
+				t.Errorf("got first capture %s, want %s", captures[0].Timestamp, test.wantFirst)
+			}
+		})
+	}
+}
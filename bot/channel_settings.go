@@ -0,0 +1,279 @@
+package bot
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	log "github.com/sirupsen/logrus"
+	globals "github.com/tyzbit/go-discord-archiver/globals"
+	"gorm.io/gorm"
+)
+
+// Most channels listed on the channel settings page
+const maxListedChannels int = 15
+
+// channelRestricted has the commands, buttons and modals that are turned
+// off in channels the bot doesn't respond in. Settings and help always work
+var channelRestricted = map[string]bool{
+	globals.Archive:                   true,
+	globals.ArchiveMessage:            true,
+	globals.ArchiveMessagePrivate:     true,
+	globals.ArchiveMessageNewSnapshot: true,
+	globals.ArchiveMessageAtDate:      true,
+	globals.ArchiveDateModal:          true,
+	globals.Snapshots:                 true,
+	globals.SnapshotsPage:             true,
+	globals.Diff:                      true,
+	globals.ArchiveSite:               true,
+	globals.Retry:                     true,
+	globals.CompareSnapshots:          true,
+}
+
+// MigrateChannelConfigs makes the channel settings table again if it's
+// still keyed by channel only, keeping the settings in it. AutoMigrate
+// doesn't change primary keys, so it has to happen before that
+func MigrateChannelConfigs(db *gorm.DB) error {
+	m := db.Migrator()
+	if !m.HasTable(&ChannelConfig{}) || !m.HasIndex(&ChannelConfig{}, "idx_channel_configs_channel_id") {
+		return nil
+	}
+
+	var configs []ChannelConfig
+	if tx := db.Find(&configs); tx.Error != nil {
+		return fmt.Errorf("unable to read channel configs: %w", tx.Error)
+	}
+	log.Infof("keying %d channel configs by server and channel", len(configs))
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Migrator().DropTable(&ChannelConfig{}); err != nil {
+			return fmt.Errorf("unable to drop channel configs: %w", err)
+		}
+		if err := tx.Migrator().CreateTable(&ChannelConfig{}); err != nil {
+			return fmt.Errorf("unable to create channel configs: %w", err)
+		}
+		if len(configs) == 0 {
+			return nil
+		}
+		if err := tx.Create(&configs).Error; err != nil {
+			return fmt.Errorf("unable to save channel configs: %w", err)
+		}
+		return nil
+	})
+}
+
+// getChannelConfig returns the settings for a channel in a server. ok is
+// false if the channel has never been configured
+func (bot *ArchiverBot) getChannelConfig(guildID string, channelID string) (cc ChannelConfig, ok bool) {
+	tx := bot.DB.Where("server_id = ? AND channel_id = ?", guildID, channelID).Limit(1).Find(&cc)
+	if tx.Error != nil {
+		log.Errorf("unable to look up channel config for %s: %v", channelID, tx.Error)
+		return cc, false
+	}
+	return cc, tx.RowsAffected == 1
+}
+
+// channelConfig returns the settings for a channel in a server, or the
+// defaults if it has never been configured
+func (bot *ArchiverBot) channelConfig(guildID string, channelID string) ChannelConfig {
+	if cc, ok := bot.getChannelConfig(guildID, channelID); ok {
+		return cc
+	}
+	return ChannelConfig{
+		ChannelID:    channelID,
+		ServerID:     guildID,
+		Respond:      sql.NullBool{Bool: true, Valid: true},
+		AutoArchive:  sql.NullBool{Bool: false, Valid: true},
+		IncludeBots:  sql.NullBool{Bool: false, Valid: true},
+		ForcePrivate: sql.NullBool{Bool: false, Valid: true},
+	}
+}
+
+// respondsIn returns whether the bot responds in a channel. Channels that
+// were never configured allow responses
+func (cc ChannelConfig) respondsIn() bool {
+	return !cc.Respond.Valid || cc.Respond.Bool
+}
+
+// forcesPrivate returns whether replies in a channel are only shown to
+// the person who asked
+func (cc ChannelConfig) forcesPrivate() bool {
+	return cc.ForcePrivate.Valid && cc.ForcePrivate.Bool
+}
+
+// respondChannelDenied tells the user the bot doesn't respond in the
+// channel the interaction came from
+func (bot *ArchiverBot) respondChannelDenied(i *discordgo.Interaction) {
+	err := bot.DG.InteractionRespond(i, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Flags: discordgo.MessageFlagsEphemeral,
+			Embeds: []*discordgo.MessageEmbed{{
+				Title:       "The bot doesn't respond in this channel",
+				Description: "Server admins can change this on the channels page of `/settings`.",
+				Color:       globals.FrenchGray,
+			}},
+		},
+	})
+	if err != nil {
+		log.Errorf("error responding to interaction in denied channel, err: %v", err)
+	}
+}
+
+// ChannelSettingsIntegrationResponse returns the channel settings page
+// before a channel is picked in a *discordgo.InteractionResponseData
+func (bot *ArchiverBot) ChannelSettingsIntegrationResponse(sc ServerConfig) *discordgo.InteractionResponseData {
+	return bot.channelSettingsPage(sc.DiscordId, nil)
+}
+
+// channelSettingsPage returns the channel settings page with the settings
+// for cc, if a channel has been picked
+func (bot *ArchiverBot) channelSettingsPage(guildID string, cc *ChannelConfig) *discordgo.InteractionResponseData {
+	buttons := []discordgo.MessageComponent{
+		discordgo.Button{
+			Label:    "◀ Limits",
+			Style:    discordgo.SuccessButton,
+			CustomID: globals.SettingsLimits},
+	}
+	channelMenu := discordgo.SelectMenu{
+		MenuType:     discordgo.ChannelSelectMenu,
+		CustomID:     globals.SettingsChannel,
+		Placeholder:  "Pick a channel to change",
+		ChannelTypes: []discordgo.ChannelType{discordgo.ChannelTypeGuildText, discordgo.ChannelTypeGuildNews},
+	}
+
+	var description string
+	if cc == nil {
+		description = "Pick a channel to choose whether the bot responds there, auto-archives links posted " +
+			"there and keeps its replies private."
+	} else {
+		channelMenu.DefaultValues = []discordgo.SelectMenuDefaultValue{
+			{ID: cc.ChannelID, Type: discordgo.SelectMenuDefaultValueChannel},
+		}
+		for _, setting := range []struct {
+			field    string
+			customID string
+			value    sql.NullBool
+		}{
+			{"Respond", globals.ChannelRespond, sql.NullBool{Bool: cc.respondsIn(), Valid: true}},
+			{"AutoArchive", globals.ChannelAutoArchive, cc.AutoArchive},
+			{"IncludeBots", globals.ChannelIncludeBots, cc.IncludeBots},
+			{"ForcePrivate", globals.ChannelForcePrivate, cc.ForcePrivate},
+		} {
+			buttons = append(buttons, discordgo.Button{
+				Label:    getTagValue(*cc, setting.field, "pretty"),
+				Style:    globals.ButtonStyle[setting.value.Valid && setting.value.Bool],
+				CustomID: setting.customID + globals.CustomIDSeparator + cc.ChannelID,
+			})
+		}
+		description = fmt.Sprintf("Settings for <#%s>.", cc.ChannelID)
+		if cc.AutoArchive.Valid && cc.AutoArchive.Bool && !bot.Config.AutoArchive {
+			description += " The bot can't read messages yet, so nothing will be auto-archived until " +
+				"whoever runs it turns on auto-archive."
+		}
+	}
+
+	if summary := bot.channelSettingsSummary(guildID); summary != "" {
+		description += "\n\n" + summary
+	}
+
+	return &discordgo.InteractionResponseData{
+		Flags: discordgo.MessageFlagsEphemeral,
+		Embeds: []*discordgo.MessageEmbed{{
+			Title:       "Channel settings",
+			Description: description,
+			Color:       globals.FrenchGray,
+		}},
+		Components: []discordgo.MessageComponent{
+			discordgo.ActionsRow{Components: buttons},
+			discordgo.ActionsRow{Components: []discordgo.MessageComponent{channelMenu}},
+		},
+	}
+}
+
+// channelSettingsSummary lists the channels in a server that don't use the
+// default settings
+func (bot *ArchiverBot) channelSettingsSummary(guildID string) string {
+	var configs []ChannelConfig
+	bot.DB.Where(&ChannelConfig{ServerID: guildID}).Order("channel_id").Find(&configs)
+
+	var lines []string
+	for _, cc := range configs {
+		var rules []string
+		if !cc.respondsIn() {
+			rules = append(rules, "no responses")
+		}
+		if cc.AutoArchive.Valid && cc.AutoArchive.Bool {
+			rule := "auto-archive"
+			if cc.IncludeBots.Valid && cc.IncludeBots.Bool {
+				rule += " (including bots)"
+			}
+			rules = append(rules, rule)
+		}
+		if cc.forcesPrivate() {
+			rules = append(rules, "private replies")
+		}
+		if len(rules) > 0 {
+			lines = append(lines, fmt.Sprintf("<#%s>: %s", cc.ChannelID, strings.Join(rules, ", ")))
+		}
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	if len(lines) > maxListedChannels {
+		lines = append(lines[:maxListedChannels], fmt.Sprintf("...and %d more", len(lines)-maxListedChannels))
+	}
+	return "**Channels with their own settings**\n" + strings.Join(lines, "\n")
+}
+
+// respondWithChannelSettings switches a settings message to the settings
+// for the channel picked in the channel menu
+func (bot *ArchiverBot) respondWithChannelSettings(i *discordgo.InteractionCreate) {
+	mcd := i.MessageComponentData()
+	var data *discordgo.InteractionResponseData
+	if len(mcd.Values) == 0 {
+		data = bot.channelSettingsPage(i.GuildID, nil)
+	} else {
+		cc := bot.channelConfig(i.GuildID, mcd.Values[0])
+		data = bot.channelSettingsPage(i.GuildID, &cc)
+	}
+	err := bot.DG.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseUpdateMessage,
+		Data: data,
+	})
+	if err != nil {
+		log.Errorf("error responding to channel settings interaction, err: %v", err)
+	}
+}
+
+// respondToChannelSettingsChoice flips a setting for the channel in the
+// button's custom ID
+func (bot *ArchiverBot) respondToChannelSettingsChoice(i *discordgo.InteractionCreate) {
+	name, channelID, _ := strings.Cut(i.MessageComponentData().CustomID, globals.CustomIDSeparator)
+	cc := bot.channelConfig(i.GuildID, channelID)
+	switch name {
+	case globals.ChannelRespond:
+		cc.Respond = sql.NullBool{Bool: !cc.respondsIn(), Valid: true}
+	case globals.ChannelAutoArchive:
+		cc.AutoArchive = sql.NullBool{Bool: !(cc.AutoArchive.Valid && cc.AutoArchive.Bool), Valid: true}
+	case globals.ChannelIncludeBots:
+		cc.IncludeBots = sql.NullBool{Bool: !(cc.IncludeBots.Valid && cc.IncludeBots.Bool), Valid: true}
+	case globals.ChannelForcePrivate:
+		cc.ForcePrivate = sql.NullBool{Bool: !cc.forcesPrivate(), Valid: true}
+	}
+
+	var data *discordgo.InteractionResponseData
+	if tx := bot.DB.Save(&cc); tx.Error != nil {
+		log.Errorf("unable to save channel config for %s: %v", cc.ChannelID, tx.Error)
+		data = bot.settingsFailureIntegrationResponse()
+	} else {
+		data = bot.channelSettingsPage(i.GuildID, &cc)
+	}
+	err := bot.DG.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseUpdateMessage,
+		Data: data,
+	})
+	if err != nil {
+		log.Errorf("error responding to channel settings interaction, err: %v", err)
+	}
+}
@@ -0,0 +1,183 @@
+package bot
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/tyzbit/go-discord-archiver/globals"
+)
+
+// oldChannelConfig is how channel settings were saved before they were
+// keyed by server and channel
+type oldChannelConfig struct {
+	ChannelID    string `gorm:"primaryKey;uniqueIndex"`
+	ServerID     string `gorm:"index"`
+	Respond      sql.NullBool
+	AutoArchive  sql.NullBool
+	IncludeBots  sql.NullBool
+	ForcePrivate sql.NullBool
+	UpdatedAt    time.Time
+}
+
+func (oldChannelConfig) TableName() string {
+	return "channel_configs"
+}
+
+func TestMigrateChannelConfigs(t *testing.T) {
+	bot, _ := newTestBot(t)
+	if err := bot.DB.Migrator().DropTable(&ChannelConfig{}); err != nil {
+		t.Fatalf("unable to drop channel configs: %v", err)
+	}
+	if err := bot.DB.AutoMigrate(&oldChannelConfig{}); err != nil {
+		t.Fatalf("unable to make the old channel configs table: %v", err)
+	}
+	old := oldChannelConfig{ChannelID: "channel", ServerID: "server1", Respond: sql.NullBool{Valid: true}}
+	if tx := bot.DB.Create(&old); tx.Error != nil {
+		t.Fatalf("unable to save old channel config: %v", tx.Error)
+	}
+
+	// Running it again does nothing
+	for i := 0; i < 2; i++ {
+		if err := MigrateChannelConfigs(bot.DB); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if err := bot.DB.AutoMigrate(&ChannelConfig{}); err != nil {
+		t.Fatalf("unable to migrate channel configs: %v", err)
+	}
+
+	cc, ok := bot.getChannelConfig("server1", "channel")
+	if !ok || cc.respondsIn() {
+		t.Errorf("got %+v, want the old settings kept", cc)
+	}
+	other := ChannelConfig{ServerID: "server2", ChannelID: "channel", Respond: sql.NullBool{Bool: true, Valid: true}}
+	if tx := bot.DB.Create(&other); tx.Error != nil {
+		t.Errorf("unable to save the same channel ID in another server: %v", tx.Error)
+	}
+}
+
+func TestChannelSettingsPage(t *testing.T) {
+	bot, _ := newTestBot(t)
+	bot.DB.Create(&ChannelConfig{ServerID: "server", ChannelID: "quiet", Respond: sql.NullBool{Valid: true}})
+	bot.DB.Create(&ChannelConfig{ServerID: "other", ChannelID: "elsewhere", Respond: sql.NullBool{Valid: true}})
+
+	auto := bot.channelConfig("server", "links")
+	auto.AutoArchive = sql.NullBool{Bool: true, Valid: true}
+
+	tests := []struct {
+		name        string
+		cc          *ChannelConfig
+		wantButtons []string
+		wantStyle   discordgo.ButtonStyle
+		want        []string
+	}{
+		{
+			name:        "no channel picked",
+			wantButtons: []string{globals.SettingsLimits},
+			want:        []string{"Pick a channel", "<#quiet>: no responses"},
+		},
+		{
+			name: "channel picked",
+			cc:   &auto,
+			wantButtons: []string{globals.SettingsLimits, globals.ChannelRespond + ":links",
+				globals.ChannelAutoArchive + ":links", globals.ChannelIncludeBots + ":links",
+				globals.ChannelForcePrivate + ":links"},
+			wantStyle: discordgo.PrimaryButton,
+			want:      []string{"Settings for <#links>", "can't read messages yet", "<#quiet>: no responses"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data := bot.channelSettingsPage("server", test.cc)
+			buttons := data.Components[0].(discordgo.ActionsRow).Components
+			var ids []string
+			for _, b := range buttons {
+				ids = append(ids, b.(discordgo.Button).CustomID)
+			}
+			if strings.Join(ids, ",") != strings.Join(test.wantButtons, ",") {
+				t.Errorf("got buttons %v, want %v", ids, test.wantButtons)
+			}
+			if test.cc != nil {
+				if style := buttons[2].(discordgo.Button).Style; style != test.wantStyle {
+					t.Errorf("got auto-archive button style %v, want %v", style, test.wantStyle)
+				}
+			}
+
+			description := data.Embeds[0].Description
+			for _, want := range test.want {
+				if !strings.Contains(description, want) {
+					t.Errorf("description %q doesn't have %q", description, want)
+				}
+			}
+			if strings.Contains(description, "elsewhere") {
+				t.Errorf("description %q lists a channel from another server", description)
+			}
+		})
+	}
+}
+
+func TestRespondToChannelSettingsChoice(t *testing.T) {
+	bot, discord := newTestBot(t)
+	bot.DB.Create(&ChannelConfig{ServerID: "other", ChannelID: "channel", Respond: sql.NullBool{Bool: true, Valid: true}})
+
+	press := func(customID string) {
+		bot.respondToChannelSettingsChoice(&discordgo.InteractionCreate{Interaction: &discordgo.Interaction{
+			ID:      "1",
+			Token:   "token",
+			GuildID: "server",
+			Type:    discordgo.InteractionMessageComponent,
+			Data:    discordgo.MessageComponentInteractionData{CustomID: customID},
+		}})
+	}
+
+	tests := []struct {
+		customID string
+		want     ChannelConfig
+	}{
+		{
+			customID: globals.ChannelRespond + ":channel",
+			want: ChannelConfig{Respond: sql.NullBool{Valid: true},
+				AutoArchive: sql.NullBool{Valid: true}},
+		},
+		{
+			customID: globals.ChannelAutoArchive + ":channel",
+			want: ChannelConfig{Respond: sql.NullBool{Valid: true},
+				AutoArchive: sql.NullBool{Bool: true, Valid: true}},
+		},
+		{
+			customID: globals.ChannelRespond + ":channel",
+			want: ChannelConfig{Respond: sql.NullBool{Bool: true, Valid: true},
+				AutoArchive: sql.NullBool{Bool: true, Valid: true}},
+		},
+	}
+
+	for _, test := range tests {
+		press(test.customID)
+		cc, ok := bot.getChannelConfig("server", "channel")
+		if !ok {
+			t.Fatalf("%s: channel config wasn't saved", test.customID)
+		}
+		if cc.Respond != test.want.Respond || cc.AutoArchive != test.want.AutoArchive {
+			t.Errorf("%s: got respond %v and auto-archive %v, want %v and %v", test.customID,
+				cc.Respond, cc.AutoArchive, test.want.Respond, test.want.AutoArchive)
+		}
+	}
+
+	if cc, _ := bot.getChannelConfig("other", "channel"); !cc.respondsIn() || cc.AutoArchive.Bool {
+		t.Errorf("the same channel ID in another server was changed: %+v", cc)
+	}
+	requests := discord.Requests()
+	if len(requests) != len(tests) {
+		t.Fatalf("got %d requests to Discord, want %d", len(requests), len(tests))
+	}
+	for _, request := range requests {
+		if !strings.HasPrefix(request, "POST /api/v9/interactions/1/token/callback") ||
+			!strings.Contains(request, `"type":7`) {
+			t.Errorf("got request %q, want the settings message updated", request)
+		}
+	}
+}
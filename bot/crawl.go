@@ -0,0 +1,433 @@
+package bot
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	log "github.com/sirupsen/logrus"
+	globals "github.com/tyzbit/go-discord-archiver/globals"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+const (
+	defaultCrawlDepth    int = 1
+	defaultCrawlMaxDepth int = 2
+	defaultCrawlMaxPages int = 25
+	// How often the progress message is updated at most
+	crawlProgressInterval time.Duration = 3 * time.Second
+	// Crawls stop after this long and archive what they found. Progress is
+	// shown while the interaction token is good, which is 15 minutes
+	crawlTimeout time.Duration = 13 * time.Minute
+)
+
+// crawlLimits returns the most pages and the deepest a site crawl can go
+func (bot *ArchiverBot) crawlLimits() (maxDepth int, maxPages int) {
+	maxDepth, maxPages = defaultCrawlMaxDepth, defaultCrawlMaxPages
+	if bot.Config.CrawlMaxDepth > 0 {
+		maxDepth = bot.Config.CrawlMaxDepth
+	}
+	if bot.Config.CrawlMaxPages > 0 {
+		maxPages = bot.Config.CrawlMaxPages
+	}
+	return maxDepth, maxPages
+}
+
+// sameSite returns whether two URLs are on the same host, ignoring www.
+func sameSite(a *url.URL, b *url.URL) bool {
+	return strings.TrimPrefix(a.Hostname(), "www.") == strings.TrimPrefix(b.Hostname(), "www.")
+}
+
+// extractLinks returns the http(s) links on an HTML page, resolved against
+// base and without fragments
+func extractLinks(page []byte, base *url.URL) (links []*url.URL) {
+	doc, err := html.Parse(bytes.NewReader(page))
+	if err != nil {
+		return links
+	}
+
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.DataAtom == atom.A {
+			if link, err := base.Parse(strings.TrimSpace(attr(n, "href"))); err == nil &&
+				(link.Scheme == "http" || link.Scheme == "https") {
+				link.Fragment = ""
+				links = append(links, link)
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+	return links
+}
+
+// crawlSite returns start and the pages on the same site linked from it,
+// breadth first, up to depth links away and at most maxPages pages. It
+// stops early at deadline or when stop is closed. progress is called with
+// the number of pages found so far
+func crawlSite(start string, depth int, maxPages int, attempts uint, deadline time.Time, stop <-chan bool,
+	progress func(found int)) (pages []string) {
+	startUrl, err := url.Parse(start)
+	if err != nil {
+		return []string{start}
+	}
+
+	seen := map[string]bool{startUrl.String(): true}
+	pages = []string{startUrl.String()}
+	level := []string{startUrl.String()}
+	for d := 0; d < depth && len(pages) < maxPages; d++ {
+		var next []string
+		for _, page := range level {
+			if len(pages) >= maxPages || time.Now().After(deadline) || isClosed(stop) {
+				break
+			}
+
+			body, finalUrl, err := fetchPage(page, attempts)
+			if err != nil {
+				log.Debugf("unable to fetch page while crawling: %v", err)
+				continue
+			}
+			if !strings.HasPrefix(http.DetectContentType(body), "text/html") {
+				continue
+			}
+			base, err := url.Parse(finalUrl)
+			if err != nil {
+				continue
+			}
+
+			for _, link := range extractLinks(body, base) {
+				if !sameSite(startUrl, link) || seen[link.String()] {
+					continue
+				}
+				seen[link.String()] = true
+				pages = append(pages, link.String())
+				next = append(next, link.String())
+				if len(pages) >= maxPages {
+					break
+				}
+			}
+			progress(len(pages))
+		}
+		level = next
+	}
+	return pages
+}
+
+// crawlSummary returns an embed with the state of every page in a site
+// crawl, the first processed of which have been archived. statuses has
+// the results of finished snapshot jobs, by job ID
+func (bot *ArchiverBot) crawlSummary(start string, pages []string, archives []ArchiveEvent,
+	statuses map[string]SnapshotJobStatus, processed int, done bool) *discordgo.MessageEmbed {
+	archived, pending, failed := 0, 0, 0
+	var lines []string
+	for index, page := range pages {
+		result := archiveResultFor(archives, page, statuses)
+		var line string
+		switch {
+		case index >= processed:
+			line = "⬜ " + crawlLabel(page)
+		case result.Outcome == archiveSucceeded || result.Outcome == archiveCached:
+			archived++
+			line = fmt.Sprintf("✅ [%s](%s)", crawlLabel(page), result.Link)
+		case result.Outcome == archivePending:
+			pending++
+			line = "⏳ " + crawlLabel(page)
+		case result.Outcome == archiveLocalCopy:
+			archived++
+			line = "💾 " + crawlLabel(page) + " (local copy)"
+		default:
+			failed++
+			line = "❌ " + crawlLabel(page)
+		}
+		lines = append(lines, line)
+	}
+
+	// Embed descriptions can only be 4096 characters long
+	var description strings.Builder
+	for i, line := range lines {
+		more := fmt.Sprintf("…and %d more", len(lines)-i)
+		if description.Len()+len(line)+len(more)+2 > 4096 {
+			description.WriteString(more)
+			break
+		}
+		description.WriteString(line + "\n")
+	}
+
+	title := "🕸️ Archiving Site"
+	if done {
+		title = "🕸️ Site Archive"
+	}
+	return &discordgo.MessageEmbed{
+		Title:       title,
+		URL:         start,
+		Description: description.String(),
+		Color:       globals.FrenchGray,
+		Fields: []*discordgo.MessageEmbedField{
+			{Name: "Pages", Value: fmt.Sprint(len(pages)), Inline: true},
+			{Name: "Archived", Value: fmt.Sprint(archived), Inline: true},
+			{Name: "In Progress", Value: fmt.Sprint(pending), Inline: true},
+			{Name: "Failed", Value: fmt.Sprint(failed), Inline: true},
+		},
+	}
+}
+
+// crawlLabel returns a short name for a page in a crawl summary
+func crawlLabel(page string) string {
+	u, err := url.Parse(page)
+	if err != nil || (u.Path == "" && u.RawQuery == "") {
+		return page
+	}
+	label := u.Path
+	if u.RawQuery != "" {
+		label += "?" + u.RawQuery
+	}
+	if len(label) > 80 {
+		label = label[:77] + "..."
+	}
+	return label
+}
+
+// archiveSiteInteraction is called by using /archive-site. The crawl is
+// queued as a bulk archive job, so it waits for requests people are
+// waiting on
+func (bot *ArchiverBot) archiveSiteInteraction(i *discordgo.InteractionCreate) {
+	log.Debug("handling archive site command request")
+	ephemeral := i.GuildID != "" && bot.channelConfig(i.GuildID, i.ChannelID).forcesPrivate()
+	// Send a response immediately that says the bot is thinking
+	var flags discordgo.MessageFlags
+	if ephemeral {
+		flags = discordgo.MessageFlagsEphemeral
+	}
+	_ = bot.deferResponse(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Flags: flags,
+		},
+	})
+
+	sc := bot.getServerConfig(i.GuildID)
+	maxDepth, maxPages := bot.crawlLimits()
+	depth, pageLimit := defaultCrawlDepth, maxPages
+	var start string
+	var newSnapshot bool
+	for _, option := range i.ApplicationCommandData().Options {
+		switch option.Name {
+		case globals.UrlOption:
+			messageUrls, _ := bot.extractMessageUrls(option.StringValue())
+			if len(messageUrls) > 0 {
+				start = messageUrls[0]
+			}
+		case globals.DepthOption:
+			depth = int(option.IntValue())
+		case globals.PagesOption:
+			pageLimit = int(option.IntValue())
+		case globals.TakeNewSnapshotOption:
+			newSnapshot = option.BoolValue()
+		}
+	}
+	if depth > maxDepth {
+		depth = maxDepth
+	}
+	if pageLimit > maxPages || pageLimit < 1 {
+		pageLimit = maxPages
+	}
+
+	embed := &discordgo.MessageEmbed{Title: "No URL found", Color: globals.BrightRed}
+	if start != "" {
+		job := newArchiveJob([]string{start}, newSnapshot, snapshotOptions(sc))
+		job.Priority = archivePriorityBulk
+		job.CrawlDepth = int32(depth)
+		job.CrawlPages = int32(pageLimit)
+		job.ServerID = i.GuildID
+		job.ChannelID = i.ChannelID
+		job.AppID = i.AppID
+		job.InteractionToken = i.Token
+		job.Ephemeral = ephemeral
+		if i.Member != nil && i.Member.User != nil {
+			job.UserID = i.Member.User.ID
+		} else if i.User != nil {
+			job.UserID = i.User.ID
+		}
+
+		embed = &discordgo.MessageEmbed{
+			Title:       "🕸️ Archiving Site",
+			URL:         start,
+			Description: "Waiting to start.",
+			Color:       globals.FrenchGray,
+		}
+		if err := bot.enqueueArchiveJob(job); err != nil {
+			log.Errorf("unable to queue archive site request: %v", err)
+			embed = &discordgo.MessageEmbed{
+				Title:       "Unable to archive",
+				Description: "Something went wrong queueing your request, please try again.",
+				Color:       globals.BrightRed,
+			}
+		} else {
+			defer bot.reportQueuePosition(i.Interaction, job)
+		}
+	}
+	if _, err := bot.DG.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
+		Embeds: &[]*discordgo.MessageEmbed{embed},
+	}); err != nil {
+		log.Errorf("unable to respond to archive site request: %v", err)
+	}
+}
+
+// runCrawlJob crawls the site in job, archives every page it found as one
+// batch and sends a summary. Progress is shown while the interaction token
+// is still good. If the bot starts shutting down first, interrupted is true
+// and the job should be started again after the restart
+func (bot *ArchiverBot) runCrawlJob(job *ArchiveJob) (interrupted bool, err error) {
+	life := bot.lifecycle()
+	deadline := time.Now().Add(crawlTimeout)
+	sc := bot.getServerConfig(job.ServerID)
+	if len(job.urls()) == 0 {
+		return false, bot.sendArchiveJobReply(*job, bot.noUrlsReply(), nil)
+	}
+	start := job.urls()[0]
+
+	lastUpdate := time.Time{}
+	update := func(embed *discordgo.MessageEmbed, force bool) {
+		if job.InteractionToken == "" || time.Since(job.CreatedAt) >= interactionTokenLifetime {
+			return
+		}
+		if !force && time.Since(lastUpdate) < crawlProgressInterval {
+			return
+		}
+		lastUpdate = time.Now()
+		// This also clears the queue position
+		content := ""
+		if _, err := bot.DG.InteractionResponseEdit(job.interaction(), &discordgo.WebhookEdit{
+			Content: &content,
+			Embeds:  &[]*discordgo.MessageEmbed{embed},
+		}); err != nil {
+			log.Errorf("unable to update site archive progress: %v", err)
+		}
+	}
+
+	// Crawls are only tried again when their reply couldn't be sent, so
+	// the pages archived the first time are reused
+	archives, err := bot.archiveJobEvents(job)
+	if err != nil {
+		log.Errorf("problem handling archive job %d: %v", job.ID, err)
+	}
+	var pages []string
+	for _, archive := range archives {
+		if len(pages) == 0 || pages[len(pages)-1] != archive.RequestURL {
+			pages = append(pages, archive.RequestURL)
+		}
+	}
+	processed := len(pages)
+
+	if archives == nil {
+		pages = crawlSite(start, int(job.CrawlDepth), int(job.CrawlPages), uint(sc.RetryAttempts.Int32), deadline,
+			life.stopped, func(found int) {
+				update(&discordgo.MessageEmbed{
+					Title:       "🕸️ Archiving Site",
+					URL:         start,
+					Description: fmt.Sprintf("Looking for pages, found %d so far.", found),
+					Color:       globals.FrenchGray,
+				}, false)
+			})
+		if isClosed(life.stopped) {
+			return true, nil
+		}
+
+		// How many new snapshots the crawl takes is only known now
+		crawled := *job
+		crawled.URLs = strings.Join(pages, "\n")
+		if _, err := bot.takeJobQuota(&crawled); err != nil {
+			return false, bot.sendArchiveJobReply(*job, []*discordgo.MessageSend{quotaExceededReply(err)}, nil)
+		}
+
+		var handled int
+		archives, handled, processed = bot.archiveCrawledPages(job, sc, pages, deadline, life.stopped,
+			func(archives []ArchiveEvent, processed int) {
+				update(bot.crawlSummary(start, pages, archives, nil, processed, false), false)
+			})
+		// Only pages that were archived are saved, since the rest were never
+		// asked for if the crawl ran out of time or the bot is shutting down.
+		// They're saved either way, so they're found in the cache if the
+		// crawl is started again
+		archives = archives[:handled]
+		interrupted = isClosed(life.stopped)
+		if err := bot.saveArchiveJobEvents(job, archives, !interrupted); err != nil {
+			log.Errorf("problem handling archive job %d: %v", job.ID, err)
+		}
+		if interrupted {
+			return true, nil
+		}
+	}
+
+	tracker := bot.newSnapshotTracker(archives, pages, sc, false)
+	tracker.stop = life.stopped
+	if len(tracker.pendingJobs()) > 0 {
+		update(bot.crawlSummary(start, pages, tracker.archives, tracker.statuses, processed, false), true)
+		tracker.wait(deadline, func(archive *ArchiveEvent) {
+			update(bot.crawlSummary(start, pages, tracker.archives, tracker.statuses, processed, false), false)
+		})
+	}
+
+	summary := bot.crawlSummary(start, pages, tracker.archives, tracker.statuses, processed, true)
+	if tracker.interrupted {
+		summary.Footer = &discordgo.MessageEmbedFooter{
+			Text: "The bot restarted before every new snapshot finished, they may still show up later.",
+		}
+	}
+	return false, bot.sendArchiveJobReply(*job, []*discordgo.MessageSend{{Embeds: []*discordgo.MessageEmbed{summary}}}, nil)
+}
+
+// archiveCrawledPages archives pages one at a time until deadline or stop
+// is closed, calling progress after each one. handled is how many of the
+// archive events have been archived, and processed how many pages
+func (bot *ArchiverBot) archiveCrawledPages(job *ArchiveJob, sc ServerConfig, pages []string, deadline time.Time,
+	stop <-chan bool, progress func(archives []ArchiveEvent, processed int)) (archives []ArchiveEvent, handled int, processed int) {
+	guild, err := bot.DG.Guild(job.ServerID)
+	if err != nil {
+		guild = &discordgo.Guild{ID: job.ServerID, Name: "GuildLookupError"}
+	}
+	// Every page shares an ArchiveEventEventUUID, so the crawl is one batch
+	archives, _ = bot.populateArchiveEventCache(pages, job.NewSnapshot, *guild)
+	req := job.request()
+	for _, page := range pages {
+		if time.Now().After(deadline) {
+			log.Warnf("ran out of time archiving site %s", pages[0])
+			break
+		}
+		if isClosed(stop) {
+			break
+		}
+
+		// Each page's events are next to each other
+		first, last := -1, -1
+		for index, archive := range archives {
+			if archive.RequestURL == page {
+				if first == -1 {
+					first = index
+				}
+				last = index
+			}
+		}
+		if first == -1 {
+			continue
+		}
+		pageArchives := archives[first : last+1]
+		_, errs := bot.executeArchiveEventRequest(&pageArchives, sc, job.NewSnapshot, req)
+		for _, err := range errs {
+			if err != nil {
+				log.Errorf("problem archiving page %s: %v", page, err)
+			}
+		}
+		handled = last + 1
+		processed++
+		progress(archives, processed)
+	}
+	return archives, handled, processed
+}
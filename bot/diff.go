@@ -0,0 +1,395 @@
+package bot
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	log "github.com/sirupsen/logrus"
+	globals "github.com/tyzbit/go-discord-archiver/globals"
+)
+
+const (
+	// Lines of unchanged text around each change
+	diffContextLines int = 3
+	// Past this many changed lines, the texts are treated as completely
+	// different instead of working out exactly what changed
+	maxDiffEdits int = 2000
+)
+
+var (
+	// Archive.org timestamps can be shortened, 2006 means the start of 2006
+	partialTimestampRegex = regexp.MustCompile(`^\d{4,14}$`)
+)
+
+// diffOp is a line of a diff. Kind is ' ' for unchanged lines, '-' for
+// removed lines and '+' for added lines
+type diffOp struct {
+	Kind byte
+	Text string
+}
+
+// diffLines returns the changes that turn a into b using Myers' algorithm
+func diffLines(a []string, b []string) (ops []diffOp) {
+	// Most of a page doesn't change, so skip the unchanged start and end
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	for _, line := range a[:prefix] {
+		ops = append(ops, diffOp{Kind: ' ', Text: line})
+	}
+	ops = append(ops, myersDiff(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, line := range a[len(a)-suffix:] {
+		ops = append(ops, diffOp{Kind: ' ', Text: line})
+	}
+	return ops
+}
+
+// myersDiff returns the shortest set of changes that turn a into b, or
+// all of a removed and all of b added if there are more than maxDiffEdits
+func myersDiff(a []string, b []string) []diffOp {
+	n, m := len(a), len(b)
+	offset := n + m
+	v := make([]int, 2*offset+2)
+	// trace has v for each number of edits, which is used to walk back
+	// through the changes at the end
+	var trace [][]int
+
+	for d := 0; d <= n+m; d++ {
+		if d > maxDiffEdits {
+			break
+		}
+		snapshot := make([]int, 2*d+1)
+		copy(snapshot, v[offset-d:offset+d+1])
+		trace = append(trace, snapshot)
+
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+
+			if x >= n && y >= m {
+				return myersBacktrack(a, b, trace)
+			}
+		}
+	}
+
+	var ops []diffOp
+	for _, line := range a {
+		ops = append(ops, diffOp{Kind: '-', Text: line})
+	}
+	for _, line := range b {
+		ops = append(ops, diffOp{Kind: '+', Text: line})
+	}
+	return ops
+}
+
+// myersBacktrack walks back through trace from the end of a and b to build
+// the list of changes
+func myersBacktrack(a []string, b []string, trace [][]int) []diffOp {
+	var reversed []diffOp
+	x, y := len(a), len(b)
+	for d := len(trace) - 1; d > 0; d-- {
+		v := trace[d]
+		k := x - y
+		var previousK int
+		if k == -d || (k != d && v[d+k-1] < v[d+k+1]) {
+			previousK = k + 1
+		} else {
+			previousK = k - 1
+		}
+		previousX := v[d+previousK]
+		previousY := previousX - previousK
+
+		for x > previousX && y > previousY {
+			reversed = append(reversed, diffOp{Kind: ' ', Text: a[x-1]})
+			x--
+			y--
+		}
+		if x == previousX {
+			reversed = append(reversed, diffOp{Kind: '+', Text: b[y-1]})
+			y--
+		} else {
+			reversed = append(reversed, diffOp{Kind: '-', Text: a[x-1]})
+			x--
+		}
+	}
+	for x > 0 && y > 0 {
+		reversed = append(reversed, diffOp{Kind: ' ', Text: a[x-1]})
+		x--
+		y--
+	}
+
+	ops := make([]diffOp, 0, len(reversed))
+	for i := len(reversed) - 1; i >= 0; i-- {
+		ops = append(ops, reversed[i])
+	}
+	return ops
+}
+
+// unifiedDiff formats ops as a unified diff, or returns an empty string if
+// nothing changed
+func unifiedDiff(fromName string, toName string, ops []diffOp) string {
+	// The line numbers in a and b before each op
+	aLines, bLines := make([]int, len(ops)+1), make([]int, len(ops)+1)
+	for i, op := range ops {
+		aLines[i+1], bLines[i+1] = aLines[i], bLines[i]
+		if op.Kind != '+' {
+			aLines[i+1]++
+		}
+		if op.Kind != '-' {
+			bLines[i+1]++
+		}
+	}
+
+	var out bytes.Buffer
+	for i := 0; i < len(ops); i++ {
+		if ops[i].Kind == ' ' {
+			continue
+		}
+		if out.Len() == 0 {
+			fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+		}
+
+		// Changes close enough together share a hunk
+		start := i - diffContextLines
+		if start < 0 {
+			start = 0
+		}
+		last := i
+		for j := i; j < len(ops) && j-last <= 2*diffContextLines; j++ {
+			if ops[j].Kind != ' ' {
+				last = j
+			}
+		}
+		end := last + diffContextLines + 1
+		if end > len(ops) {
+			end = len(ops)
+		}
+
+		aCount, bCount := aLines[end]-aLines[start], bLines[end]-bLines[start]
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(aLines[start], aCount), hunkRange(bLines[start], bCount))
+		for _, op := range ops[start:end] {
+			fmt.Fprintf(&out, "%c%s\n", op.Kind, op.Text)
+		}
+		i = end - 1
+	}
+	return out.String()
+}
+
+// hunkRange formats the start and length of a hunk the way unified diffs do
+func hunkRange(start int, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprint(start + 1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+// parseSnapshotTimestamp returns an archive.org timestamp for value, which
+// can be a (possibly shortened) timestamp or a date parseRequestedDate
+// understands
+func parseSnapshotTimestamp(value string, sc ServerConfig) (string, error) {
+	value = strings.TrimSpace(value)
+	if partialTimestampRegex.MatchString(value) {
+		return value, nil
+	}
+	at, err := parseRequestedDate(value, sc)
+	if err != nil {
+		return "", err
+	}
+	return at.UTC().Format(globals.ArchiveOrgTimestampLayout), nil
+}
+
+// diffReply compares the text of the captures of originalUrl closest to
+// from and to and returns a message with a summary and the diff attached
+func (bot *ArchiverBot) diffReply(originalUrl string, from string, to string, sc ServerConfig) *discordgo.MessageSend {
+	embed := &discordgo.MessageEmbed{
+		Title: "📝 Snapshot Comparison",
+		URL:   originalUrl,
+		Color: globals.FrenchGray,
+	}
+
+	attempts := uint(sc.RetryAttempts.Int32)
+	var texts [2][]string
+	var snapshotUrls [2]string
+	for i, timestamp := range []string{from, to} {
+		body, snapshotUrl, err := fetchSnapshot(timestamp, originalUrl, attempts)
+		if err == nil {
+			var text string
+			text, err = extractText(bytes.NewReader(body))
+			texts[i] = strings.Split(text, "\n")
+		}
+		if err != nil {
+			log.Errorf("unable to get text of snapshot of url: %v, timestamp: %v, err: %v", originalUrl, timestamp, err)
+			embed.Description = fmt.Sprintf("Unable to get the snapshot from %s: %v", timestamp, err)
+			embed.Color = globals.BrightRed
+			return &discordgo.MessageSend{Embeds: []*discordgo.MessageEmbed{embed}}
+		}
+		snapshotUrls[i] = snapshotUrl
+	}
+
+	ops := diffLines(texts[0], texts[1])
+	added, removed := 0, 0
+	for _, op := range ops {
+		switch op.Kind {
+		case '+':
+			added++
+		case '-':
+			removed++
+		}
+	}
+
+	embed.Fields = []*discordgo.MessageEmbedField{
+		{
+			Name:   "From",
+			Value:  bot.snapshotLink(snapshotUrls[0], sc),
+			Inline: true,
+		},
+		{
+			Name:   "To",
+			Value:  bot.snapshotLink(snapshotUrls[1], sc),
+			Inline: true,
+		},
+	}
+
+	diff := unifiedDiff(snapshotUrls[0], snapshotUrls[1], ops)
+	if diff == "" {
+		embed.Description = "The text didn't change between these snapshots."
+		return &discordgo.MessageSend{Embeds: []*discordgo.MessageEmbed{embed}}
+	}
+
+	embed.Description = fmt.Sprintf("%d lines added, %d lines removed. The full diff is attached.", added, removed)
+	return &discordgo.MessageSend{
+		Embeds: []*discordgo.MessageEmbed{embed},
+		Files: []*discordgo.File{
+			{
+				Name:        fmt.Sprintf("snapshot-%s-%s.diff", from, to),
+				ContentType: "text/x-diff",
+				Reader:      strings.NewReader(diff),
+			},
+		},
+	}
+}
+
+// snapshotLink returns a link to a snapshot labelled with when it was taken
+func (bot *ArchiverBot) snapshotLink(snapshotUrl string, sc ServerConfig) string {
+	label := "Snapshot"
+	if at, ok := snapshotTime(snapshotUrl); ok {
+		label = at.In(serverLocation(sc)).Format(time.RFC1123Z)
+	}
+	return fmt.Sprintf("[%s](%s)", label, snapshotUrl)
+}
+
+// compareSnapshotsID returns the custom ID for a button that compares the
+// captures from and to of the URL of the embed at index
+func compareSnapshotsID(index int, from string, to string) string {
+	return strings.Join([]string{globals.CompareSnapshots, fmt.Sprint(index), from, to}, globals.CustomIDSeparator)
+}
+
+// sendDiffReply sends a message from diffReply as the response to a
+// deferred interaction
+func (bot *ArchiverBot) sendDiffReply(i *discordgo.InteractionCreate, message *discordgo.MessageSend) {
+	_, err := bot.DG.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
+		Embeds: &message.Embeds,
+		Files:  message.Files,
+	})
+	if err != nil {
+		log.Errorf("problem sending diff message: %v", err)
+	}
+}
+
+// diffInteraction is called by using /diff
+func (bot *ArchiverBot) diffInteraction(i *discordgo.InteractionCreate) {
+	log.Debug("handling diff command request")
+	// Send a response immediately that says the bot is thinking
+	_ = bot.deferResponse(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Flags: discordgo.MessageFlagsEphemeral,
+		},
+	})
+
+	sc := bot.getServerConfig(i.GuildID)
+	var originalUrl, from, to string
+	var err error
+	for _, option := range i.ApplicationCommandData().Options {
+		switch option.Name {
+		case globals.UrlOption:
+			messageUrls, _ := bot.extractMessageUrls(option.StringValue())
+			if len(messageUrls) > 0 {
+				originalUrl = messageUrls[0]
+			}
+		case globals.FromOption:
+			from, err = parseSnapshotTimestamp(option.StringValue(), sc)
+		case globals.ToOption:
+			to, err = parseSnapshotTimestamp(option.StringValue(), sc)
+		}
+		if err != nil {
+			log.Errorf("unable to parse diff timestamp: %v", err)
+			bot.sendDiffReply(i, bot.invalidDateReply()[0])
+			return
+		}
+	}
+
+	if originalUrl == "" {
+		bot.sendDiffReply(i, &discordgo.MessageSend{
+			Embeds: []*discordgo.MessageEmbed{
+				{
+					Title: "No URL found",
+					Color: globals.BrightRed,
+				},
+			},
+		})
+		return
+	}
+
+	bot.sendDiffReply(i, bot.diffReply(originalUrl, from, to, sc))
+}
+
+// compareSnapshotsInteraction is called by the compare button on archive
+// replies
+func (bot *ArchiverBot) compareSnapshotsInteraction(i *discordgo.InteractionCreate) {
+	parts := strings.Split(i.MessageComponentData().CustomID, globals.CustomIDSeparator)
+	if len(parts) != 4 {
+		log.Errorf("unexpected compare snapshots custom id: %v", i.MessageComponentData().CustomID)
+		return
+	}
+	index, err := strconv.Atoi(parts[1])
+	if err != nil || i.Message == nil || index >= len(i.Message.Embeds) {
+		log.Errorf("compare snapshots button does not match an embed: %v", i.MessageComponentData().CustomID)
+		return
+	}
+
+	// The comparison is only shown to whoever asked for it
+	_ = bot.deferResponse(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Flags: discordgo.MessageFlagsEphemeral,
+		},
+	})
+
+	sc := bot.getServerConfig(i.GuildID)
+	bot.sendDiffReply(i, bot.diffReply(i.Message.Embeds[index].URL, parts[2], parts[3], sc))
+}
@@ -0,0 +1,123 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// diffString returns ops as their kinds and text, like " a -b +c"
+func diffString(ops []diffOp) string {
+	var parts []string
+	for _, op := range ops {
+		parts = append(parts, string(op.Kind)+op.Text)
+	}
+	return strings.Join(parts, " ")
+}
+
+// applyDiff returns the lines ops turn from and to
+func applyDiff(ops []diffOp) (from []string, to []string) {
+	for _, op := range ops {
+		if op.Kind != '+' {
+			from = append(from, op.Text)
+		}
+		if op.Kind != '-' {
+			to = append(to, op.Text)
+		}
+	}
+	return from, to
+}
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		name string
+		a    string
+		b    string
+		want string
+	}{
+		{"both empty", "", "", ""},
+		{"same", "a b c", "a b c", " a  b  c"},
+		{"added", "", "a b", "+a +b"},
+		{"removed", "a b", "", "-a -b"},
+		{"changed line", "a b c", "a x c", " a -b +x  c"},
+		{"inserted in the middle", "a b c d", "a b x c d", " a  b +x  c  d"},
+		{"removed at the start", "x a b", "a b", "-x  a  b"},
+		{"moved line", "a b c", "b c a", "-a  b  c +a"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ops := diffLines(strings.Fields(test.a), strings.Fields(test.b))
+			if got := diffString(ops); got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestDiffLinesRoundTrip(t *testing.T) {
+	a := strings.Fields("the quick brown fox jumps over the lazy dog and the cat sat on the mat")
+	b := strings.Fields("a quick red fox jumped over the lazy cat and the dog sat on a mat today")
+
+	from, to := applyDiff(diffLines(a, b))
+	if strings.Join(from, " ") != strings.Join(a, " ") || strings.Join(to, " ") != strings.Join(b, " ") {
+		t.Errorf("diff doesn't turn %v into %v, got %v and %v", a, b, from, to)
+	}
+}
+
+func TestDiffLinesMaxEdits(t *testing.T) {
+	var a, b []string
+	for i := 0; i <= maxDiffEdits; i++ {
+		a = append(a, fmt.Sprintf("old %d", i))
+		b = append(b, fmt.Sprintf("new %d", i))
+	}
+
+	ops := diffLines(a, b)
+	if len(ops) != len(a)+len(b) || ops[0].Kind != '-' || ops[len(ops)-1].Kind != '+' {
+		t.Fatalf("got %d ops, want every line removed and then added", len(ops))
+	}
+	from, to := applyDiff(ops)
+	if len(from) != len(a) || len(to) != len(b) {
+		t.Errorf("diff doesn't turn a into b")
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	a := strings.Fields("1 2 3 4 5 6 7 8 9 10 11 12 13 14 15 16 17 18 19 20")
+	b := strings.Fields("1 2 3 4 x 6 7 8 9 10 11 12 13 14 15 16 17 18 20")
+
+	want := `--- from
++++ to
+@@ -2,7 +2,7 @@
+ 2
+ 3
+ 4
+-5
++x
+ 6
+ 7
+ 8
+@@ -16,5 +16,4 @@
+ 16
+ 17
+ 18
+-19
+ 20
+`
+	if got := unifiedDiff("from", "to", diffLines(a, b)); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	if got := unifiedDiff("from", "to", diffLines(a, a)); got != "" {
+		t.Errorf("got %q for texts that are the same, want nothing", got)
+	}
+}
+
+func TestParseSnapshotTimestamp(t *testing.T) {
+	for _, value := range []string{"2006", "20060102", " 20060102150405 "} {
+		got, err := parseSnapshotTimestamp(value, ServerConfig{})
+		if err != nil || got != strings.TrimSpace(value) {
+			t.Errorf("parseSnapshotTimestamp(%q) = %q, %v, want the timestamp back", value, got, err)
+		}
+	}
+}
@@ -1,6 +1,8 @@
 package bot
 
 import (
+	"strings"
+
 	"github.com/bwmarrin/discordgo"
 	log "github.com/sirupsen/logrus"
 	globals "github.com/tyzbit/go-discord-archiver/globals"
@@ -71,6 +73,8 @@ func (bot *ArchiverBot) BotReadyHandler(s *discordgo.Session, r *discordgo.Ready
 		}
 	}
 
+	bot.watchArchiveAccounts()
+
 	err = bot.updateServersWatched()
 	if err != nil {
 		log.Error("unable to update servers watched")
@@ -104,6 +108,13 @@ func (bot *ArchiverBot) GuildDeleteHandler(s *discordgo.Session, gd *discordgo.G
 
 // InteractionInit configures all interactive commands
 func (bot *ArchiverBot) InteractionHandler(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	life := bot.lifecycle()
+	if !life.begin(i.Interaction) {
+		bot.respondRestarting(i.Interaction)
+		return
+	}
+	defer life.end(i.Interaction)
+
 	commandsHandlers := map[string]func(s *discordgo.Session, i *discordgo.InteractionCreate){
 		globals.Help: func(s *discordgo.Session, i *discordgo.InteractionCreate) {
 			err := bot.DG.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
@@ -132,6 +143,22 @@ func (bot *ArchiverBot) InteractionHandler(s *discordgo.Session, i *discordgo.In
 		globals.ArchiveMessage:            func(s *discordgo.Session, i *discordgo.InteractionCreate) { bot.archiveInteraction(i, false, false) },
 		globals.ArchiveMessagePrivate:     func(s *discordgo.Session, i *discordgo.InteractionCreate) { bot.archiveInteraction(i, false, true) },
 		globals.ArchiveMessageNewSnapshot: func(s *discordgo.Session, i *discordgo.InteractionCreate) { bot.archiveInteraction(i, true, true) },
+		globals.ArchiveMessageAtDate: func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+			// Ask for the date, the modal is handled by modalHandlers
+			err := bot.DG.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+				Type: discordgo.InteractionResponseModal,
+				Data: archiveDateModal(i.ApplicationCommandData().TargetID),
+			})
+			if err != nil {
+				log.Errorf("error responding to message command "+globals.ArchiveMessageAtDate+", err: %v", err)
+			}
+		},
+		globals.Snapshots: func(s *discordgo.Session, i *discordgo.InteractionCreate) { bot.snapshotsInteraction(i) },
+		globals.Diff:      func(s *discordgo.Session, i *discordgo.InteractionCreate) { bot.diffInteraction(i) },
+		globals.ArchiveSite: func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+			bot.archiveSiteInteraction(i)
+		},
+		globals.AutoArchive: func(s *discordgo.Session, i *discordgo.InteractionCreate) { bot.autoArchiveInteraction(i) },
 		globals.Settings: func(s *discordgo.Session, i *discordgo.InteractionCreate) {
 			log.Debug("handling settings request")
 			if i.GuildID == "" {
@@ -166,17 +193,40 @@ func (bot *ArchiverBot) InteractionHandler(s *discordgo.Session, i *discordgo.In
 
 	buttonHandlers := map[string]func(s *discordgo.Session, i *discordgo.InteractionCreate){
 		globals.Retry: func(s *discordgo.Session, i *discordgo.InteractionCreate) {
-			typingStop := make(chan bool, 1)
-			go bot.typeInChannel(typingStop, i.ChannelID)
-
-			// Remove retry button
-			i.Message.Components = []discordgo.MessageComponent{}
+			message := *i.Message
+			message.GuildID = i.GuildID
+			// The new snapshot is requested by whoever pressed the button
+			message.Member = i.Member
+			job, messagesToSend, errs := bot.messageArchiveJob(&message, true)
+			for _, err := range errs {
+				if err != nil {
+					log.Errorf("problem handling archive request: %v", err)
+				}
+			}
 
-			guild, err := bot.DG.Guild(i.Interaction.GuildID)
-			if err != nil {
-				guild.Name = "GuildLookupError"
+			// The button is left alone if the user is over their quota
+			var quota snapshotQuota
+			if job != nil {
+				var err error
+				quota, err = bot.takeJobQuota(job)
+				if err != nil {
+					err = bot.DG.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+						Type: discordgo.InteractionResponseChannelMessageWithSource,
+						Data: &discordgo.InteractionResponseData{
+							Flags:  discordgo.MessageFlagsEphemeral,
+							Embeds: quotaExceededReply(err).Embeds,
+						},
+					})
+					if err != nil {
+						log.Errorf("error responding to retry interaction, err: %v", err)
+					}
+					return
+				}
 			}
 
+			// Remove retry button
+			i.Message.Components = withoutRetryButton(i.Message.Components)
+
 			interactionErr := bot.DG.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
 				Type: discordgo.InteractionResponseUpdateMessage,
 				Data: &discordgo.InteractionResponseData{
@@ -189,59 +239,34 @@ func (bot *ArchiverBot) InteractionHandler(s *discordgo.Session, i *discordgo.In
 				log.Errorf("error responding to archive message messagesToSend interaction, err: %v", interactionErr)
 			}
 
-			var messagesToBeSent []*discordgo.MessageSend
-			var messageResponses []*discordgo.MessageSend
-			var errs []error
-			if i.Interaction != nil {
-				i.Interaction.Message.GuildID = guild.ID
-				messageResponses, errs = bot.buildMessageResponse(i.Interaction.Message, true)
-				messagesToBeSent = append(messagesToBeSent, messageResponses...)
-			} else {
-				i.Message.GuildID = guild.ID
-				messageResponses, errs = bot.buildMessageResponse(i.Message, true)
-				messagesToBeSent = append(messagesToBeSent, messageResponses...)
-			}
-
-			for _, err := range errs {
-				if err != nil {
-					log.Errorf("problem handling archive request: %v", err)
+			if job == nil {
+				m := discordgo.Message{Member: i.Member, GuildID: i.GuildID, ChannelID: i.ChannelID}
+				for _, message := range messagesToSend {
+					if _, err := bot.sendArchiveResponse(&m, message); err != nil {
+						log.Errorf("problem sending message: %v", err)
+					}
 				}
+				return
 			}
-
-			// This is necessary because the type is unknown
-			if len(messagesToBeSent) == 0 {
-				log.Warn("retry used but no messagesToSend was generated")
-				typingStop <- true
+			if err := bot.enqueueArchiveJob(job); err != nil {
+				log.Errorf("unable to queue retry: %v", err)
+				bot.refundSnapshotQuota(quota)
 				return
 			}
-
-			for index, messagesToSend := range messagesToBeSent {
-				m := discordgo.Message{
-					Member: &discordgo.Member{
-						User: &discordgo.User{
-							ID: i.Member.User.ID,
-						},
-					},
-					GuildID:   i.GuildID,
-					ChannelID: i.ChannelID,
-				}
-
-				if len(errs) >= index+1 {
-					if errs[index] != nil {
-						guild.Name = "None"
-						guild.ID = "0"
-					}
-				}
-
-				err = bot.sendArchiveResponse(&m, messagesToSend)
-
+			// Only the user who pressed the button sees how much quota is left
+			if note := quota.remainingText(); note != "" {
+				_, err := bot.DG.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+					Content: note,
+					Flags:   discordgo.MessageFlagsEphemeral,
+				})
 				if err != nil {
-					log.Errorf("problem sending message: %v", err)
+					log.Errorf("unable to send remaining quota: %v", err)
 				}
 			}
-
-			// This only has an effect if the message is not ephemeral
-			typingStop <- true
+		},
+		globals.SnapshotsPage: func(s *discordgo.Session, i *discordgo.InteractionCreate) { bot.snapshotsPageInteraction(i) },
+		globals.CompareSnapshots: func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+			bot.compareSnapshotsInteraction(i)
 		},
 		// Settings buttons/choices
 		globals.BotEnabled: func(s *discordgo.Session, i *discordgo.InteractionCreate) {
@@ -259,6 +284,64 @@ func (bot *ArchiverBot) InteractionHandler(s *discordgo.Session, i *discordgo.In
 			inverse := sc.AlwaysArchiveFirst.Valid && !sc.AlwaysArchiveFirst.Bool
 			bot.respondToSettingsChoice(i, "always_archive_first", inverse)
 		},
+		globals.ShowMementos: func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+			sc := bot.getServerConfig(i.GuildID)
+			inverse := sc.ShowMementos.Valid && !sc.ShowMementos.Bool
+			bot.respondToSettingsChoice(i, "show_mementos", inverse)
+		},
+		globals.CaptureOutlinks: func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+			sc := bot.getServerConfig(i.GuildID)
+			inverse := sc.CaptureOutlinks.Valid && !sc.CaptureOutlinks.Bool
+			bot.respondToSettingsChoice(i, "capture_outlinks", inverse)
+		},
+		globals.CaptureScreenshot: func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+			sc := bot.getServerConfig(i.GuildID)
+			inverse := sc.CaptureScreenshot.Valid && !sc.CaptureScreenshot.Bool
+			bot.respondToSettingsChoice(i, "capture_screenshot", inverse)
+		},
+		globals.ReaderMode: func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+			sc := bot.getServerConfig(i.GuildID)
+			inverse := sc.ReaderMode.Valid && !sc.ReaderMode.Bool
+			bot.respondToSettingsChoice(i, "reader_mode", inverse)
+		},
+		globals.SkipIfArchivedWithin: func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+			mcd := i.MessageComponentData()
+			bot.respondToSettingsChoice(i, "skip_if_archived_within", mcd.Values[0])
+		},
+		globals.JSDelay: func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+			mcd := i.MessageComponentData()
+			bot.respondToSettingsChoice(i, "js_delay", mcd.Values[0])
+		},
+		// Settings pages
+		globals.SettingsGeneral: func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+			bot.respondWithSettingsPage(i, bot.SettingsIntegrationResponse)
+		},
+		globals.SettingsCapture: func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+			bot.respondWithSettingsPage(i, bot.CaptureSettingsIntegrationResponse)
+		},
+		globals.SettingsLimits: func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+			bot.respondWithSettingsPage(i, bot.LimitsSettingsIntegrationResponse)
+		},
+		globals.SettingsChannels: func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+			bot.respondWithSettingsPage(i, bot.ChannelSettingsIntegrationResponse)
+		},
+		globals.SettingsChannel:     func(s *discordgo.Session, i *discordgo.InteractionCreate) { bot.respondWithChannelSettings(i) },
+		globals.ChannelRespond:      func(s *discordgo.Session, i *discordgo.InteractionCreate) { bot.respondToChannelSettingsChoice(i) },
+		globals.ChannelAutoArchive:  func(s *discordgo.Session, i *discordgo.InteractionCreate) { bot.respondToChannelSettingsChoice(i) },
+		globals.ChannelIncludeBots:  func(s *discordgo.Session, i *discordgo.InteractionCreate) { bot.respondToChannelSettingsChoice(i) },
+		globals.ChannelForcePrivate: func(s *discordgo.Session, i *discordgo.InteractionCreate) { bot.respondToChannelSettingsChoice(i) },
+		globals.SnapshotsPerUserHour: func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+			mcd := i.MessageComponentData()
+			bot.respondToSettingsChoice(i, "snapshots_per_user_hour", mcd.Values[0])
+		},
+		globals.SnapshotsPerServerDay: func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+			mcd := i.MessageComponentData()
+			bot.respondToSettingsChoice(i, "snapshots_per_server_day", mcd.Values[0])
+		},
+		globals.SnapshotCooldown: func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+			mcd := i.MessageComponentData()
+			bot.respondToSettingsChoice(i, "snapshot_cooldown", mcd.Values[0])
+		},
 		globals.UTCOffset: func(s *discordgo.Session, i *discordgo.InteractionCreate) {
 			mcd := i.MessageComponentData()
 			bot.respondToSettingsChoice(i, "utc_offset", mcd.Values[0])
@@ -277,54 +360,95 @@ func (bot *ArchiverBot) InteractionHandler(s *discordgo.Session, i *discordgo.In
 		},
 	}
 
+	// Modal custom IDs have data after the separator, so they're looked up
+	// by the part before it
+	modalHandlers := map[string]func(s *discordgo.Session, i *discordgo.InteractionCreate){
+		globals.ArchiveDateModal: func(s *discordgo.Session, i *discordgo.InteractionCreate) { bot.archiveInteraction(i, false, false) },
+	}
+
+	var name string
+	var h func(s *discordgo.Session, i *discordgo.InteractionCreate)
 	switch i.Type {
 	case discordgo.InteractionApplicationCommand:
-		if h, ok := commandsHandlers[i.ApplicationCommandData().Name]; ok {
-			h(s, i)
-		}
+		name = i.ApplicationCommandData().Name
+		h = commandsHandlers[name]
 	case discordgo.InteractionMessageComponent:
-		if h, ok := buttonHandlers[i.MessageComponentData().CustomID]; ok {
-			h(s, i)
-		}
+		// Some custom IDs have data after the separator
+		name, _, _ = strings.Cut(i.MessageComponentData().CustomID, globals.CustomIDSeparator)
+		h = buttonHandlers[name]
+	case discordgo.InteractionModalSubmit:
+		name, _, _ = strings.Cut(i.ModalSubmitData().CustomID, globals.CustomIDSeparator)
+		h = modalHandlers[name]
+	}
+	if h == nil {
+		return
 	}
+	if i.GuildID != "" && channelRestricted[name] && !bot.channelConfig(i.GuildID, i.ChannelID).respondsIn() {
+		bot.respondChannelDenied(i.Interaction)
+		return
+	}
+	h(s, i)
 }
 
-// archiveInteraction is called by using /archive and using the "Get archived snapshots" app function.
+// archiveInteraction is called by using /archive, the "Get archived snapshots" app function
+// and submitting the date modal.
 func (bot *ArchiverBot) archiveInteraction(i *discordgo.InteractionCreate, newSnapshot bool, ephemeral bool) {
 	log.Debug("handling archive command request")
+	if i.GuildID != "" && bot.channelConfig(i.GuildID, i.ChannelID).forcesPrivate() {
+		ephemeral = true
+	}
 	var flags discordgo.MessageFlags
 	if ephemeral {
 		flags = discordgo.MessageFlagsEphemeral
 	}
 	// Send a response immediately that says the bot is thinking
-	_ = bot.DG.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+	_ = bot.deferResponse(i.Interaction, &discordgo.InteractionResponse{
 		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
 		Data: &discordgo.InteractionResponseData{
 			Flags: flags,
 		},
 	})
-	messagesToSend, errs := bot.buildInteractionResponse(i, newSnapshot)
+	job, messagesToSend, errs := bot.interactionArchiveJob(i, newSnapshot)
 	for _, err := range errs {
 		if err != nil {
 			log.Errorf("problem handling archive command request: %v", err)
 		}
 	}
 
-	if len(messagesToSend) == 0 {
-		log.Warn("no embeds were generated")
+	// There's nothing to archive, so the reply is sent right away
+	if job == nil {
+		for _, message := range messagesToSend {
+			if err := bot.sendArchiveCommandResponse(i.Interaction, message); err != nil {
+				log.Errorf("problem sending message: %v", err)
+				return
+			}
+		}
 		return
 	}
 
-	for _, message := range messagesToSend {
-		if message == nil {
-			log.Errorf("empty message, not trying to send")
-			message = &discordgo.MessageSend{
-				Content: "Error handling interaction",
-			}
-		}
-		err := bot.sendArchiveCommandResponse(i.Interaction, message)
-		if err != nil {
-			log.Errorf("problem sending message: %v", err)
-		}
+	// Requests over a quota aren't queued
+	quota, err := bot.takeJobQuota(job)
+	if err != nil {
+		bot.respondQuotaExceeded(i.Interaction, ephemeral, err)
+		return
+	}
+	if ephemeral {
+		job.Note = quota.remainingText()
+	}
+
+	// A worker archives the URLs and sends the reply
+	job.Ephemeral = ephemeral
+	if err := bot.enqueueArchiveJob(job); err != nil {
+		log.Errorf("unable to queue archive command request: %v", err)
+		bot.refundSnapshotQuota(quota)
+		_ = bot.sendArchiveCommandResponse(i.Interaction, &discordgo.MessageSend{
+			Embeds: []*discordgo.MessageEmbed{{
+				Title:       "Unable to archive",
+				Description: "Something went wrong queueing your request, please try again.",
+				Color:       globals.BrightRed,
+			}},
+		})
+		return
 	}
+	bot.reportQueuePosition(i.Interaction, job)
 }
@@ -3,11 +3,14 @@ package bot
 import (
 	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
 )
 
-func (b ArchiverBot) StartHealthAPI() {
+// StartHealthAPI serves the healthcheck until Shutdown is called
+func (b *ArchiverBot) StartHealthAPI() {
 	app := gin.New()
 	app.Use(
 		// Disable logging for healthcheck endpoint and favicon
@@ -27,7 +30,18 @@ func (b ArchiverBot) StartHealthAPI() {
 			content = fmt.Sprintf("Error pinging db: %v", pingResult)
 			status = http.StatusInternalServerError
 		}
+		// The bot still works when degraded, so the status is still OK
+		if reasons := b.degradedReasons(); status == http.StatusOK && len(reasons) > 0 {
+			content = "Degraded: " + strings.Join(reasons, ", ")
+		}
 		c.String(status, content)
 	})
-	_ = app.Run(":8080")
+	server := &http.Server{Addr: ":8080", Handler: app}
+	life := b.lifecycle()
+	life.mu.Lock()
+	life.health = server
+	life.mu.Unlock()
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Errorf("health api stopped: %v", err)
+	}
 }
@@ -0,0 +1,76 @@
+package bot
+
+import (
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// testDiscord stands in for the Discord API. It records every request and
+// answers them with an empty JSON object
+type testDiscord struct {
+	mu       sync.Mutex
+	requests []string
+}
+
+func (d *testDiscord) RoundTrip(r *http.Request) (*http.Response, error) {
+	var body string
+	if r.Body != nil {
+		b, _ := io.ReadAll(r.Body)
+		body = string(b)
+	}
+	d.mu.Lock()
+	d.requests = append(d.requests, r.Method+" "+r.URL.Path+" "+body)
+	d.mu.Unlock()
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       io.NopCloser(strings.NewReader("{}")),
+		Request:    r,
+	}, nil
+}
+
+// Requests returns the requests made so far, as the method, path and body
+func (d *testDiscord) Requests() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string(nil), d.requests...)
+}
+
+// newTestBot returns a bot with an empty database and a Discord session
+// that only talks to a testDiscord
+func newTestBot(t *testing.T) (*ArchiverBot, *testDiscord) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")),
+		&gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("unable to open database: %v", err)
+	}
+	err = db.AutoMigrate(&ServerRegistration{}, &ServerConfig{}, &ArchiveEvent{}, &ArchiveJob{},
+		&ScheduledTask{}, &SnapshotUsage{}, &ChannelConfig{})
+	if err != nil {
+		t.Fatalf("unable to migrate database: %v", err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+
+	discord := &testDiscord{}
+	dg, err := discordgo.New("Bot test")
+	if err != nil {
+		t.Fatalf("unable to make discord session: %v", err)
+	}
+	dg.Client = &http.Client{Transport: discord}
+	dg.State.User = &discordgo.User{ID: "bot"}
+
+	return &ArchiverBot{DB: db, DG: dg}, discord
+}
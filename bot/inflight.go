@@ -0,0 +1,93 @@
+package bot
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tyzbit/go-discord-archiver/globals"
+)
+
+// archiveRequests is shared by every server, so the same URL asked for in
+// several places at once is only sent to the provider once
+var archiveRequests = &inflightRequests{calls: map[string]*inflightCall{}}
+
+// inflightCall is a provider request that others can wait on
+type inflightCall struct {
+	done    chan bool
+	url     string
+	jobID   string
+	err     error
+	waiters int
+}
+
+// inflightRequests lets requests for the same thing share one call to a
+// provider while it's in progress
+type inflightRequests struct {
+	mu    sync.Mutex
+	calls map[string]*inflightCall
+}
+
+// Do calls fn, unless a call with the same key is already in progress, in
+// which case it waits for that one and returns its results. shared is
+// whether the results came from another call
+func (r *inflightRequests) Do(key string, fn func() (url string, jobID string, err error)) (
+	url string, jobID string, shared bool, err error) {
+	r.mu.Lock()
+	if call, ok := r.calls[key]; ok {
+		call.waiters++
+		r.mu.Unlock()
+		<-call.done
+		return call.url, call.jobID, true, call.err
+	}
+	// Waiters get this error if fn panics instead of returning
+	call := &inflightCall{done: make(chan bool), err: fmt.Errorf("archive request for %s did not finish", key)}
+	r.calls[key] = call
+	r.mu.Unlock()
+
+	// Waiters are let go even if fn panics
+	defer func() {
+		r.mu.Lock()
+		delete(r.calls, key)
+		if call.waiters > 0 {
+			log.Debugf("shared archive request with %d others: %s", call.waiters, key)
+		}
+		r.mu.Unlock()
+		close(call.done)
+	}()
+
+	call.url, call.jobID, call.err = fn()
+	return call.url, call.jobID, false, call.err
+}
+
+// normalizeUrl returns rawUrl in a form that's the same for URLs that only
+// differ in ways that don't change the page, like the case of the host
+// or a fragment
+func normalizeUrl(rawUrl string) string {
+	u, err := url.Parse(strings.TrimSpace(rawUrl))
+	if err != nil || u.Host == "" {
+		return rawUrl
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	if (u.Scheme == "http" && u.Port() == "80") || (u.Scheme == "https" && u.Port() == "443") {
+		u.Host = u.Hostname()
+	}
+	u.Fragment = ""
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	return u.String()
+}
+
+// archiveRequestKey returns the key requests are shared by: the provider,
+// the normalized URL and everything that changes what the provider does
+func archiveRequestKey(p ArchiveProvider, req ArchiveRequest, takeSnapshot bool) string {
+	key := fmt.Sprintf("%s|%s|snapshot=%t|%+v", p.Name(), normalizeUrl(req.URL), takeSnapshot, req.Options)
+	if !req.At.IsZero() {
+		key += "|at=" + req.At.UTC().Format(globals.ArchiveOrgTimestampLayout)
+	}
+	return key
+}
@@ -0,0 +1,172 @@
+package bot
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestInflightShared(t *testing.T) {
+	r := &inflightRequests{calls: map[string]*inflightCall{}}
+	release := make(chan bool)
+	calls := 0
+
+	var wg sync.WaitGroup
+	results := make(chan bool, 3)
+	started := make(chan bool)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		u, jobID, shared, err := r.Do("key", func() (string, string, error) {
+			calls++
+			close(started)
+			<-release
+			return "https://web.archive.org/web/1/http://example.com/", "job", nil
+		})
+		results <- shared
+		if u == "" || jobID != "job" || err != nil {
+			t.Errorf("got %q, %q, %v from the first call", u, jobID, err)
+		}
+	}()
+	<-started
+
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			u, jobID, shared, err := r.Do("key", func() (string, string, error) {
+				t.Errorf("a second call was made while the first was in progress")
+				return "", "", nil
+			})
+			results <- shared
+			if u == "" || jobID != "job" || err != nil {
+				t.Errorf("got %q, %q, %v from a shared call", u, jobID, err)
+			}
+		}()
+	}
+	// Give the waiters time to join the first call
+	for {
+		r.mu.Lock()
+		waiters := r.calls["key"].waiters
+		r.mu.Unlock()
+		if waiters == 2 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+	close(results)
+
+	sharedCount := 0
+	for shared := range results {
+		if shared {
+			sharedCount++
+		}
+	}
+	if calls != 1 || sharedCount != 2 {
+		t.Errorf("got %d calls and %d shared results, want 1 and 2", calls, sharedCount)
+	}
+	if len(r.calls) != 0 {
+		t.Errorf("finished calls weren't removed")
+	}
+
+	// Calls after the first finished start again
+	_, _, shared, err := r.Do("key", func() (string, string, error) {
+		return "", "", errors.New("failed")
+	})
+	if shared || err == nil {
+		t.Errorf("got shared %v and %v, want a new call that failed", shared, err)
+	}
+}
+
+func TestInflightPanic(t *testing.T) {
+	r := &inflightRequests{calls: map[string]*inflightCall{}}
+	started := make(chan bool)
+	release := make(chan bool)
+
+	go func() {
+		defer func() { _ = recover() }()
+		_, _, _, _ = r.Do("key", func() (string, string, error) {
+			close(started)
+			<-release
+			panic("provider broke")
+		})
+	}()
+	<-started
+
+	done := make(chan error)
+	go func() {
+		_, _, _, err := r.Do("key", func() (string, string, error) { return "", "", nil })
+		done <- err
+	}()
+	for {
+		r.mu.Lock()
+		waiters := r.calls["key"].waiters
+		r.mu.Unlock()
+		if waiters == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Errorf("got no error waiting on a call that panicked")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("waiter wasn't released after the call panicked")
+	}
+}
+
+func TestNormalizeUrl(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://Example.COM/Page", "https://example.com/Page"},
+		{"HTTP://example.com:80/", "http://example.com/"},
+		{"https://example.com:443/a", "https://example.com/a"},
+		{"https://example.com:8443/a", "https://example.com:8443/a"},
+		{"https://example.com/a#section", "https://example.com/a"},
+		{"https://example.com", "https://example.com/"},
+		{" https://example.com/a?b=c ", "https://example.com/a?b=c"},
+		{"not a url", "not a url"},
+	}
+
+	for _, test := range tests {
+		if got := normalizeUrl(test.url); got != test.want {
+			t.Errorf("normalizeUrl(%q) = %q, want %q", test.url, got, test.want)
+		}
+	}
+}
+
+func TestArchiveRequestKey(t *testing.T) {
+	p := mementoProvider{}
+	req := ArchiveRequest{URL: "https://example.com/a"}
+	key := archiveRequestKey(p, req, false)
+
+	same := ArchiveRequest{URL: "https://EXAMPLE.com/a#top"}
+	if got := archiveRequestKey(p, same, false); got != key {
+		t.Errorf("got %q for the same page, want %q", got, key)
+	}
+
+	at := req
+	at.At = time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	withOptions := req
+	withOptions.Options.CaptureScreenshot = true
+	for name, got := range map[string]string{
+		"new snapshot":            archiveRequestKey(p, req, true),
+		"point in time":           archiveRequestKey(p, at, false),
+		"other options":           archiveRequestKey(p, withOptions, false),
+		"other page":              archiveRequestKey(p, ArchiveRequest{URL: "https://example.com/b"}, false),
+		"same page, new snapshot": archiveRequestKey(p, same, true),
+	} {
+		if got == key {
+			t.Errorf("%s shares a key with the first request: %q", name, got)
+		}
+	}
+}
@@ -0,0 +1,419 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// Requests per minute to each service if RATE_LIMIT isn't set
+	defaultRateLimit int = 60
+	// Requests that can be made at once before the rate limit kicks in
+	rateLimitBurst float64 = 5
+	// Failures in a row before requests to a service are paused
+	breakerThreshold int = 5
+	// How long requests are paused the first time, this doubles each time
+	// the service fails again after being paused, up to breakerMaxCooldown
+	breakerCooldown    time.Duration = 30 * time.Second
+	breakerMaxCooldown time.Duration = 10 * time.Minute
+	// Requests don't wait longer than this for their turn
+	rateLimitMaxWait time.Duration = time.Minute
+	// Bulk work gets this fraction of RATE_LIMIT if BULK_RATE_LIMIT isn't set
+	defaultBulkRateDivisor int = 4
+	// Most guards kept at once. Idle guards are dropped after this, so
+	// fetching pages from lots of sites doesn't grow the registry forever
+	maxServiceGuards int = 500
+)
+
+// serviceGuards has the guard for every service requests have been made to
+var serviceGuards = &guardRegistry{guards: map[string]*serviceGuard{}, rate: defaultRateLimit}
+
+// bulkGuard paces bulk work like /archive-site on top of the limit for
+// each service, so it leaves room for requests people are waiting on
+var bulkGuard = newServiceGuard("bulk archiving", defaultRateLimit/defaultBulkRateDivisor)
+
+// rateLimitedError is returned when a service asks us to slow down
+type rateLimitedError struct {
+	service    string
+	retryAfter time.Duration
+}
+
+func (e rateLimitedError) Error() string {
+	if e.retryAfter > 0 {
+		return fmt.Sprintf("rate limited by %s for %s", e.service, e.retryAfter.Round(time.Second))
+	}
+	return fmt.Sprintf("rate limited by %s", e.service)
+}
+
+// unavailableError is returned when a service has a server error
+type unavailableError struct {
+	service    string
+	statusCode int
+	retryAfter time.Duration
+}
+
+func (e unavailableError) Error() string {
+	return fmt.Sprintf("%s is unavailable (http status code %v)", e.service, e.statusCode)
+}
+
+// circuitOpenError is returned instead of making a request while requests
+// to a service are paused
+type circuitOpenError struct {
+	service string
+	until   time.Time
+}
+
+func (e circuitOpenError) Error() string {
+	return fmt.Sprintf("requests to %s are paused until %s", e.service, e.until.Format(time.RFC1123Z))
+}
+
+// retriable returns whether trying a request again right away could work
+func retriable(err error) bool {
+	var open circuitOpenError
+	var limited rateLimitedError
+	return !errors.As(err, &open) && !errors.As(err, &limited)
+}
+
+// userErrorText returns err as it should be shown to users. Pauses are shown
+// with a Discord timestamp, so it's in the user's time zone
+func userErrorText(err error) string {
+	var open circuitOpenError
+	if errors.As(err, &open) {
+		return fmt.Sprintf("%s is busy or not answering right now, so requests are paused. "+
+			"They'll start again <t:%d:R>.", open.service, open.until.Unix())
+	}
+	return err.Error()
+}
+
+// guardRegistry has a serviceGuard for each service
+type guardRegistry struct {
+	mu     sync.Mutex
+	guards map[string]*serviceGuard
+	// rate is requests per minute for new guards
+	rate int
+}
+
+// SetRate sets the requests per minute for every service
+func (r *guardRegistry) SetRate(rate int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if rate <= 0 {
+		rate = defaultRateLimit
+	}
+	r.rate = rate
+	for _, g := range r.guards {
+		g.SetRate(rate)
+	}
+}
+
+// Get returns the guard for service. If there are already
+// maxServiceGuards that aren't idle, the guard isn't kept
+func (r *guardRegistry) Get(service string) *serviceGuard {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	g, ok := r.guards[service]
+	if ok {
+		return g
+	}
+	g = newServiceGuard(service, r.rate)
+	if len(r.guards) >= maxServiceGuards {
+		now := time.Now()
+		for name, existing := range r.guards {
+			if existing.idle(now) {
+				delete(r.guards, name)
+			}
+		}
+	}
+	if len(r.guards) < maxServiceGuards {
+		r.guards[service] = g
+	}
+	return g
+}
+
+// Paused returns a description of each service requests are paused for
+func (r *guardRegistry) Paused() (paused []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, g := range r.guards {
+		if until := g.PausedUntil(); !until.IsZero() {
+			paused = append(paused, fmt.Sprintf("requests to %s are paused until %s",
+				g.service, until.Format(time.RFC1123Z)))
+		}
+	}
+	sort.Strings(paused)
+	return paused
+}
+
+// serviceGuard is a token bucket rate limiter and circuit breaker for one
+// service. Requests wait for a token, and once the service fails enough
+// times in a row (or asks us to back off) requests fail right away until
+// it's time to try again
+type serviceGuard struct {
+	mu        sync.Mutex
+	service   string
+	perSecond float64
+	tokens    float64
+	refilled  time.Time
+	// failures is how many requests have failed in a row
+	failures int
+	// pauses is how many times in a row requests have been paused
+	pauses    int
+	openUntil time.Time
+	// probing is whether a request is checking if the service is back
+	probing bool
+}
+
+// newServiceGuard returns a guard for service that allows rate requests
+// per minute
+func newServiceGuard(service string, rate int) *serviceGuard {
+	return &serviceGuard{
+		service:   service,
+		perSecond: float64(rate) / 60,
+		tokens:    rateLimitBurst,
+		refilled:  time.Now(),
+	}
+}
+
+// SetRate sets the requests per minute
+func (g *serviceGuard) SetRate(rate int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.perSecond = float64(rate) / 60
+}
+
+// Wait waits for a turn to make a request, or returns a circuitOpenError
+// if requests are paused
+func (g *serviceGuard) Wait() error {
+	deadline := time.Now().Add(rateLimitMaxWait)
+	for {
+		g.mu.Lock()
+		now := time.Now()
+		if now.Before(g.openUntil) {
+			until := g.openUntil
+			g.mu.Unlock()
+			return circuitOpenError{service: g.service, until: until}
+		}
+		// After a pause, one request checks if the service is back
+		// before everything else is let through
+		if g.failures >= breakerThreshold {
+			if g.probing {
+				g.mu.Unlock()
+				return circuitOpenError{service: g.service, until: now.Add(breakerCooldown)}
+			}
+			g.probing = true
+			g.mu.Unlock()
+			return nil
+		}
+
+		g.tokens += now.Sub(g.refilled).Seconds() * g.perSecond
+		if g.tokens > rateLimitBurst {
+			g.tokens = rateLimitBurst
+		}
+		g.refilled = now
+		if g.tokens >= 1 {
+			g.tokens--
+			g.mu.Unlock()
+			return nil
+		}
+		wait := time.Duration((1 - g.tokens) / g.perSecond * float64(time.Second))
+		g.mu.Unlock()
+
+		if now.Add(wait).After(deadline) {
+			return rateLimitedError{service: g.service, retryAfter: wait}
+		}
+		time.Sleep(wait)
+	}
+}
+
+// Done records how a request went. Rate limits pause requests for as long
+// as the service asked, and outages pause them once there have been
+// breakerThreshold in a row
+func (g *serviceGuard) Done(err error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.probing = false
+
+	var limited rateLimitedError
+	var unavailable unavailableError
+	var netErr net.Error
+	var retryAfter time.Duration
+	switch {
+	case errors.As(err, &limited):
+		retryAfter = limited.retryAfter
+		if retryAfter <= 0 {
+			retryAfter = breakerCooldown
+		}
+		g.failures = breakerThreshold
+	case errors.As(err, &unavailable):
+		retryAfter = unavailable.retryAfter
+		g.failures++
+	case errors.As(err, &netErr):
+		g.failures++
+	default:
+		// Anything else means the service answered
+		g.failures = 0
+		g.pauses = 0
+		return
+	}
+	if g.failures < breakerThreshold && retryAfter == 0 {
+		return
+	}
+	g.failures = breakerThreshold
+
+	if retryAfter == 0 {
+		retryAfter = breakerCooldown << g.pauses
+		if retryAfter > breakerMaxCooldown || retryAfter <= 0 {
+			retryAfter = breakerMaxCooldown
+		}
+	}
+	g.pauses++
+	g.openUntil = time.Now().Add(retryAfter)
+	log.Warnf("pausing requests to %s until %s: %v", g.service, g.openUntil.Format(time.RFC1123Z), err)
+}
+
+// Skip gives back a turn from Wait that wasn't used, so a check on
+// whether the service is back isn't left waiting for an answer
+func (g *serviceGuard) Skip() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.probing = false
+}
+
+// idle returns whether the guard is the same as a new one would be, so
+// dropping it changes nothing
+func (g *serviceGuard) idle(now time.Time) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.failures == 0 && !g.probing && !now.Before(g.openUntil) &&
+		g.tokens+now.Sub(g.refilled).Seconds()*g.perSecond >= rateLimitBurst
+}
+
+// PausedUntil returns when requests to the service start again, or the
+// zero time if they aren't paused
+func (g *serviceGuard) PausedUntil() time.Time {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if time.Now().Before(g.openUntil) {
+		return g.openUntil
+	}
+	return time.Time{}
+}
+
+// setBulkRate sets the requests per minute for bulk work. 0 uses a
+// fraction of rate, the limit for everything else
+func setBulkRate(bulkRate int, rate int) {
+	if rate <= 0 {
+		rate = defaultRateLimit
+	}
+	if bulkRate <= 0 {
+		bulkRate = rate / defaultBulkRateDivisor
+	}
+	if bulkRate < 1 {
+		bulkRate = 1
+	}
+	bulkGuard.SetRate(bulkRate)
+}
+
+// waitForBulkTurn waits until bulk work can make another request
+func waitForBulkTurn() {
+	for {
+		var limited rateLimitedError
+		err := bulkGuard.Wait()
+		if !errors.As(err, &limited) {
+			return
+		}
+		time.Sleep(limited.retryAfter)
+	}
+}
+
+// serviceName returns the name of the service a host belongs to, so every
+// archive.org host shares one guard
+func serviceName(host string) string {
+	host = strings.ToLower(host)
+	for _, service := range []string{"archive.org", "archive.today", "archive.ph", "archive.is"} {
+		if host == service || strings.HasSuffix(host, "."+service) {
+			return service
+		}
+	}
+	return host
+}
+
+// guardedDo sends r with client once the guard for service allows it and
+// records how it went. Rate limits and server errors are returned as a
+// rateLimitedError or unavailableError, with the response body closed
+func guardedDo(service string, client *http.Client, r *http.Request) (*http.Response, error) {
+	g := serviceGuards.Get(service)
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	resp, err := checkedDo(service, client, r)
+	g.Done(err)
+	return resp, err
+}
+
+// guardedAccountDo is guardedDo for a request to service made with one of
+// several accounts. It waits for the service's guard and the account's
+// own, so every account shares the limits for the service. Rate limits
+// only rest the account, since the others can keep going
+func guardedAccountDo(service string, account string, client *http.Client, r *http.Request) (*http.Response, error) {
+	shared := serviceGuards.Get(service)
+	g := serviceGuards.Get(service + " (" + account + ")")
+	if err := shared.Wait(); err != nil {
+		return nil, err
+	}
+	if err := g.Wait(); err != nil {
+		shared.Skip()
+		return nil, err
+	}
+
+	resp, err := checkedDo(g.service, client, r)
+	g.Done(err)
+	var limited rateLimitedError
+	if errors.As(err, &limited) {
+		shared.Skip()
+	} else {
+		shared.Done(err)
+	}
+	return resp, err
+}
+
+// checkedDo sends r with client. Rate limits and server errors are
+// returned as a rateLimitedError or unavailableError, with the response
+// body closed
+func checkedDo(service string, client *http.Client, r *http.Request) (*http.Response, error) {
+	resp, err := client.Do(r)
+	if err == nil {
+		switch {
+		case resp.StatusCode == http.StatusTooManyRequests:
+			err = rateLimitedError{service: service, retryAfter: retryAfter(resp.Header)}
+		case resp.StatusCode == http.StatusBadGateway || resp.StatusCode == http.StatusServiceUnavailable ||
+			resp.StatusCode == http.StatusGatewayTimeout:
+			err = unavailableError{service: service, statusCode: resp.StatusCode, retryAfter: retryAfter(resp.Header)}
+		}
+		if err != nil {
+			resp.Body.Close()
+			resp = nil
+		}
+	}
+	return resp, err
+}
+
+// guardedGet is guardedDo for a GET of u with the bot's User-Agent, guarded
+// by the service u's host belongs to
+func guardedGet(client *http.Client, u string) (*http.Response, error) {
+	r, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not build http request: %w", err)
+	}
+	r.Header.Set("User-Agent", userAgent)
+	return guardedDo(serviceName(r.URL.Hostname()), client, r)
+}
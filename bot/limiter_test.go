@@ -0,0 +1,237 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// timeoutError is a net.Error like the ones failed connections return
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestServiceName(t *testing.T) {
+	tests := []struct {
+		host string
+		want string
+	}{
+		{"archive.org", "archive.org"},
+		{"web.archive.org", "archive.org"},
+		{"WEB.Archive.org", "archive.org"},
+		{"archive.ph", "archive.ph"},
+		{"www.archive.is", "archive.is"},
+		{"notarchive.org", "notarchive.org"},
+		{"example.com", "example.com"},
+	}
+
+	for _, test := range tests {
+		if got := serviceName(test.host); got != test.want {
+			t.Errorf("serviceName(%s) = %s, want %s", test.host, got, test.want)
+		}
+	}
+}
+
+func TestRetriable(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{errors.New("something broke"), true},
+		{unavailableError{service: "example.com", statusCode: 503}, true},
+		{rateLimitedError{service: "example.com"}, false},
+		{fmt.Errorf("wrapped: %w", rateLimitedError{service: "example.com"}), false},
+		{circuitOpenError{service: "example.com", until: time.Now()}, false},
+	}
+
+	for _, test := range tests {
+		if got := retriable(test.err); got != test.want {
+			t.Errorf("retriable(%v) = %v, want %v", test.err, got, test.want)
+		}
+	}
+}
+
+func TestServiceGuardBreaker(t *testing.T) {
+	tests := []struct {
+		name       string
+		errs       []error
+		wantPaused bool
+		minPause   time.Duration
+	}{
+		{
+			name: "successes",
+			errs: []error{nil, nil, nil},
+		},
+		{
+			name: "failures below the threshold",
+			errs: []error{timeoutError{}, timeoutError{}, timeoutError{}, timeoutError{}},
+		},
+		{
+			name: "success resets failures",
+			errs: []error{timeoutError{}, timeoutError{}, timeoutError{}, timeoutError{}, nil, timeoutError{}},
+		},
+		{
+			name:       "failures at the threshold",
+			errs:       []error{timeoutError{}, timeoutError{}, timeoutError{}, timeoutError{}, unavailableError{}},
+			wantPaused: true,
+			minPause:   breakerCooldown - time.Second,
+		},
+		{
+			name:       "rate limited",
+			errs:       []error{rateLimitedError{retryAfter: 5 * time.Minute}},
+			wantPaused: true,
+			minPause:   5*time.Minute - time.Second,
+		},
+		{
+			name:       "unavailable with retry after",
+			errs:       []error{unavailableError{statusCode: 503, retryAfter: 2 * time.Minute}},
+			wantPaused: true,
+			minPause:   2*time.Minute - time.Second,
+		},
+		{
+			name: "other errors mean the service answered",
+			errs: []error{timeoutError{}, timeoutError{}, timeoutError{}, timeoutError{}, errors.New("not found")},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			g := newServiceGuard("example.com", 6000)
+			for _, err := range test.errs {
+				if err := g.Wait(); err != nil {
+					t.Fatalf("unexpected error waiting: %v", err)
+				}
+				g.Done(err)
+			}
+
+			until := g.PausedUntil()
+			if paused := !until.IsZero(); paused != test.wantPaused {
+				t.Fatalf("paused until %s, want paused %v", until, test.wantPaused)
+			}
+			err := g.Wait()
+			if !test.wantPaused {
+				if err != nil {
+					t.Errorf("unexpected error waiting: %v", err)
+				}
+				return
+			}
+			var open circuitOpenError
+			if !errors.As(err, &open) {
+				t.Errorf("got %v, want a circuitOpenError", err)
+			}
+			if time.Until(until) < test.minPause {
+				t.Errorf("paused until %s, want at least %s", until, test.minPause)
+			}
+		})
+	}
+}
+
+func TestServiceGuardProbe(t *testing.T) {
+	g := newServiceGuard("example.com", 6000)
+	for i := 0; i < breakerThreshold; i++ {
+		g.Done(timeoutError{})
+	}
+	// Pretend the pause is over
+	g.openUntil = time.Now().Add(-time.Second)
+
+	if err := g.Wait(); err != nil {
+		t.Fatalf("got %v, want one request to check the service", err)
+	}
+	if err := g.Wait(); err == nil {
+		t.Errorf("got a second request while the first was checking the service")
+	}
+	g.Skip()
+	if err := g.Wait(); err != nil {
+		t.Fatalf("got %v after the check was skipped", err)
+	}
+	g.Done(nil)
+	for i := 0; i < 3; i++ {
+		if err := g.Wait(); err != nil {
+			t.Errorf("got %v after the service came back", err)
+		}
+	}
+}
+
+func TestServiceGuardRate(t *testing.T) {
+	g := newServiceGuard("example.com", 60)
+	for i := 0; i < int(rateLimitBurst); i++ {
+		if err := g.Wait(); err != nil {
+			t.Fatalf("unexpected error on request %d: %v", i+1, err)
+		}
+	}
+	start := time.Now()
+	if err := g.Wait(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if waited := time.Since(start); waited < 900*time.Millisecond {
+		t.Errorf("waited %s after the burst, want about a second", waited)
+	}
+	if g.idle(time.Now()) {
+		t.Errorf("guard is idle straight after its burst was used")
+	}
+	if !g.idle(time.Now().Add(time.Duration(rateLimitBurst) * time.Second)) {
+		t.Errorf("guard isn't idle once its burst has refilled")
+	}
+}
+
+func TestGuardRegistryCap(t *testing.T) {
+	r := &guardRegistry{guards: map[string]*serviceGuard{}, rate: defaultRateLimit}
+	for i := 0; i < maxServiceGuards; i++ {
+		g := r.Get(fmt.Sprintf("site%d.example", i))
+		g.Done(timeoutError{})
+	}
+	if got := r.Get("site0.example"); got.failures != 1 {
+		t.Errorf("got a new guard for a site that already has one")
+	}
+
+	extra := r.Get("extra.example")
+	if len(r.guards) != maxServiceGuards || r.guards["extra.example"] == extra {
+		t.Errorf("a guard was kept past the cap while every guard was busy")
+	}
+
+	r.Get("site0.example").Done(nil)
+	r.Get("extra.example")
+	if len(r.guards) != maxServiceGuards || r.guards["extra.example"] == nil || r.guards["site0.example"] != nil {
+		t.Errorf("the idle guard wasn't swapped for the new one")
+	}
+}
+
+func TestGuardedAccountDo(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/limited" {
+			w.Header().Set("Retry-After", "120")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer server.Close()
+
+	// Guards are shared by the whole package, so each run gets its own
+	service := fmt.Sprintf("account test service %d", time.Now().UnixNano())
+	for _, account := range []string{"account 1", "account 2"} {
+		r, _ := http.NewRequest(http.MethodGet, server.URL+"/limited", nil)
+		_, err := guardedAccountDo(service, account, server.Client(), r)
+		var limited rateLimitedError
+		if !errors.As(err, &limited) || limited.retryAfter != 2*time.Minute {
+			t.Errorf("got %v, want rate limited for 2 minutes", err)
+		}
+	}
+
+	if until := serviceGuards.Get(service + " (account 1)").PausedUntil(); until.IsZero() {
+		t.Errorf("the rate limited account isn't resting")
+	}
+	if until := serviceGuards.Get(service).PausedUntil(); !until.IsZero() {
+		t.Errorf("one account being rate limited paused the service until %s", until)
+	}
+
+	r, _ := http.NewRequest(http.MethodGet, server.URL+"/ok", nil)
+	resp, err := guardedAccountDo(service, "account 3", server.Client(), r)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+}
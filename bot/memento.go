@@ -0,0 +1,279 @@
+package bot
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	mementoProviderName   string = "memento"
+	mementoTimeGateRoot   string = "https://timetravel.mementoweb.org/timegate/"
+	mementoTimeMapRoot    string = "https://timetravel.mementoweb.org/timemap/link/"
+	mementoMaxTimeMapSize int64  = 10 << 20
+)
+
+// Memento is a single capture of a URL by a web archive (RFC 7089)
+type Memento struct {
+	URL      string
+	Datetime time.Time
+	// Archive is the host name of the archive that has the memento
+	Archive string
+}
+
+// mementoClient talks to a Memento TimeGate and TimeMap, usually an
+// aggregator that knows about many web archives
+type mementoClient struct {
+	timeGate string
+	timeMap  string
+	client   *http.Client
+}
+
+// newMementoClient returns a mementoClient for the given TimeGate and
+// TimeMap roots, the URL being looked up is appended to each. Empty
+// roots use the Memento Time Travel aggregator
+func newMementoClient(timeGate string, timeMap string) mementoClient {
+	if timeGate == "" {
+		timeGate = mementoTimeGateRoot
+	}
+	if timeMap == "" {
+		timeMap = mementoTimeMapRoot
+	}
+	return mementoClient{
+		timeGate: timeGate,
+		timeMap:  timeMap,
+		client: &http.Client{
+			Timeout: time.Minute,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// Closest asks the TimeGate for the memento of u closest to at. If there
+// isn't one, an empty Memento is returned
+func (c mementoClient) Closest(u string, at time.Time) (m Memento, err error) {
+	r, err := http.NewRequest(http.MethodGet, c.timeGate+u, nil)
+	if err != nil {
+		return m, fmt.Errorf("could not build http request: %w", err)
+	}
+	r.Header.Set("User-Agent", userAgent)
+	r.Header.Set("Accept-Datetime", at.UTC().Format(http.TimeFormat))
+
+	resp, err := guardedDo(serviceName(r.URL.Hostname()), c.client, r)
+	if err != nil {
+		return m, fmt.Errorf("error calling memento timegate: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return m, nil
+	case resp.StatusCode >= 300 && resp.StatusCode < 400:
+		location := resp.Header.Get("Location")
+		if location == "" {
+			return m, fmt.Errorf("memento timegate did not reply with a location header")
+		}
+		m.URL = location
+		m.Archive = mementoArchive(location)
+		// Aggregators don't always include the datetime of the memento
+		if datetime, err := http.ParseTime(resp.Header.Get("Memento-Datetime")); err == nil {
+			m.Datetime = datetime
+		}
+		return m, nil
+	}
+	return m, fmt.Errorf("memento timegate had unexpected http status code: %v", resp.StatusCode)
+}
+
+// TimeMap returns every memento of u that the TimeMap knows about
+func (c mementoClient) TimeMap(u string) (mementos []Memento, err error) {
+	r, err := http.NewRequest(http.MethodGet, c.timeMap+u, nil)
+	if err != nil {
+		return mementos, fmt.Errorf("could not build http request: %w", err)
+	}
+	r.Header.Set("User-Agent", userAgent)
+	r.Header.Set("Accept", "application/link-format")
+
+	resp, err := guardedDo(serviceName(r.URL.Hostname()), c.client, r)
+	if err != nil {
+		return mementos, fmt.Errorf("error calling memento timemap: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return mementos, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return mementos, fmt.Errorf("memento timemap had unexpected http status code: %v", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, mementoMaxTimeMapSize))
+	if err != nil {
+		return mementos, fmt.Errorf("error reading body from memento timemap: %w", err)
+	}
+	return parseTimeMap(string(body)), nil
+}
+
+// BestPerArchive returns the memento of u closest to at from each archive
+// in the TimeMap, newest first
+func (c mementoClient) BestPerArchive(u string, at time.Time) (best []Memento, err error) {
+	mementos, err := c.TimeMap(u)
+	if err != nil {
+		return best, err
+	}
+
+	closest := map[string]Memento{}
+	for _, m := range mementos {
+		current, ok := closest[m.Archive]
+		if !ok || absDuration(m.Datetime.Sub(at)) < absDuration(current.Datetime.Sub(at)) {
+			closest[m.Archive] = m
+		}
+	}
+
+	for _, m := range closest {
+		best = append(best, m)
+	}
+	sort.Slice(best, func(i, j int) bool {
+		if best[i].Datetime.Equal(best[j].Datetime) {
+			return best[i].Archive < best[j].Archive
+		}
+		return best[i].Datetime.After(best[j].Datetime)
+	})
+	return best, nil
+}
+
+// parseTimeMap parses a TimeMap in link format (RFC 6690) and returns the
+// links that are mementos. Links that aren't mementos (the original,
+// the TimeGate, other TimeMaps) are skipped
+func parseTimeMap(body string) (mementos []Memento) {
+	for _, link := range splitLinks(body) {
+		start := strings.Index(link, "<")
+		end := strings.Index(link, ">")
+		if start == -1 || end < start {
+			continue
+		}
+
+		m := Memento{URL: strings.TrimSpace(link[start+1 : end])}
+		isMemento := false
+		for _, param := range strings.Split(link[end+1:], ";") {
+			key, value, found := strings.Cut(param, "=")
+			if !found {
+				continue
+			}
+			key = strings.ToLower(strings.TrimSpace(key))
+			value = strings.Trim(strings.TrimSpace(value), `"`)
+			switch key {
+			case "rel":
+				for _, rel := range strings.Fields(value) {
+					if rel == "memento" {
+						isMemento = true
+					}
+				}
+			case "datetime":
+				if datetime, err := http.ParseTime(value); err == nil {
+					m.Datetime = datetime
+				}
+			}
+		}
+
+		if isMemento && m.URL != "" {
+			m.Archive = mementoArchive(m.URL)
+			mementos = append(mementos, m)
+		}
+	}
+	return mementos
+}
+
+// splitLinks splits a link format document into individual links. Links
+// are separated by commas, but commas also show up in quoted datetimes
+func splitLinks(body string) (links []string) {
+	var current strings.Builder
+	inQuotes, inBrackets := false, false
+	for _, r := range body {
+		switch {
+		case r == '"' && !inBrackets:
+			inQuotes = !inQuotes
+		case r == '<' && !inQuotes:
+			inBrackets = true
+		case r == '>' && !inQuotes:
+			inBrackets = false
+		case r == ',' && !inQuotes && !inBrackets:
+			links = append(links, current.String())
+			current.Reset()
+			continue
+		}
+		current.WriteRune(r)
+	}
+	if strings.TrimSpace(current.String()) != "" {
+		links = append(links, current.String())
+	}
+	return links
+}
+
+// mementoArchive returns the name of the archive a memento URL belongs to
+func mementoArchive(mementoUrl string) string {
+	u, err := url.Parse(mementoUrl)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(u.Hostname(), "www.")
+}
+
+// absDuration returns the absolute value of d
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+// mementoProvider looks up snapshots from any archive a Memento TimeGate
+// knows about. It can't take new snapshots
+type mementoProvider struct {
+	client mementoClient
+}
+
+// Name returns the name of the provider
+func (p mementoProvider) Name() string {
+	return mementoProviderName
+}
+
+// DisplayName returns the name shown to users
+func (p mementoProvider) DisplayName() string {
+	return "Memento"
+}
+
+// Capabilities returns what a Memento TimeGate supports
+func (p mementoProvider) Capabilities() ProviderCapabilities {
+	return ProviderCapabilities{
+		Lookup:      true,
+		Snapshot:    false,
+		PointInTime: true,
+	}
+}
+
+// Lookup returns the newest memento of a URL from any archive, or the one
+// closest to req.At if it's set
+func (p mementoProvider) Lookup(req ArchiveRequest) (string, error) {
+	at := req.At
+	if at.IsZero() {
+		at = time.Now()
+	}
+
+	var m Memento
+	err := retryRequest(req.RetryAttempts, func() (err error) {
+		m, err = p.client.Closest(req.URL, at)
+		return err
+	})
+	return m.URL, err
+}
+
+// Snapshot is not supported by Memento
+func (p mementoProvider) Snapshot(req ArchiveRequest) (string, error) {
+	return "", fmt.Errorf("memento does not support taking snapshots")
+}
@@ -0,0 +1,180 @@
+package bot
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testTimeMap = `<http://example.com/>; rel="original",
+<http://timetravel.example/timemap/link/http://example.com/>; rel="self"; type="application/link-format",
+<http://timetravel.example/timegate/http://example.com/>; rel="timegate",
+<http://web.archive.org/web/20200102030405/http://example.com/>; rel="first memento"; datetime="Thu, 02 Jan 2020 03:04:05 GMT",
+<http://web.archive.org/web/20220304050607/http://example.com/>; rel="memento"; datetime="Fri, 04 Mar 2022 05:06:07 GMT",
+<https://archive.ph/20210101000000/http://example.com/>; rel="last memento"; datetime="Fri, 01 Jan 2021 00:00:00 GMT"`
+
+func TestParseTimeMap(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want []Memento
+	}{
+		{
+			name: "empty",
+			body: "",
+		},
+		{
+			name: "only non-mementos",
+			body: `<http://example.com/>; rel="original", <http://timetravel.example/timegate/http://example.com/>; rel="timegate"`,
+		},
+		{
+			name: "mementos from several archives",
+			body: testTimeMap,
+			want: []Memento{
+				{
+					URL:      "http://web.archive.org/web/20200102030405/http://example.com/",
+					Datetime: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+					Archive:  "web.archive.org",
+				},
+				{
+					URL:      "http://web.archive.org/web/20220304050607/http://example.com/",
+					Datetime: time.Date(2022, 3, 4, 5, 6, 7, 0, time.UTC),
+					Archive:  "web.archive.org",
+				},
+				{
+					URL:      "https://archive.ph/20210101000000/http://example.com/",
+					Datetime: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+					Archive:  "archive.ph",
+				},
+			},
+		},
+		{
+			name: "commas in urls",
+			body: `<http://www.example.org/web/1/http://example.com/?a=1,2>; rel="memento"; datetime="Fri, 01 Jan 2021 00:00:00 GMT"`,
+			want: []Memento{{
+				URL:      "http://www.example.org/web/1/http://example.com/?a=1,2",
+				Datetime: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+				Archive:  "example.org",
+			}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := parseTimeMap(test.body)
+			if len(got) != len(test.want) {
+				t.Fatalf("got %d mementos, want %d: %+v", len(got), len(test.want), got)
+			}
+			for i := range got {
+				if got[i].URL != test.want[i].URL || got[i].Archive != test.want[i].Archive ||
+					!got[i].Datetime.Equal(test.want[i].Datetime) {
+					t.Errorf("memento %d is %+v, want %+v", i, got[i], test.want[i])
+				}
+			}
+		})
+	}
+}
+
+// newTestMementoServer returns a TimeGate and TimeMap for
+// http://example.com/, which has the mementos in testTimeMap
+func newTestMementoServer(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/timegate/http://example.com/":
+			at, err := http.ParseTime(r.Header.Get("Accept-Datetime"))
+			if err != nil {
+				http.Error(w, "bad Accept-Datetime", http.StatusBadRequest)
+				return
+			}
+			location := "http://web.archive.org/web/20220304050607/http://example.com/"
+			datetime := time.Date(2022, 3, 4, 5, 6, 7, 0, time.UTC)
+			if at.Year() < 2021 {
+				location = "http://web.archive.org/web/20200102030405/http://example.com/"
+				datetime = time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+			}
+			w.Header().Set("Location", location)
+			w.Header().Set("Memento-Datetime", datetime.Format(http.TimeFormat))
+			w.WriteHeader(http.StatusFound)
+		case r.URL.Path == "/timemap/http://example.com/":
+			w.Header().Set("Content-Type", "application/link-format")
+			_, _ = w.Write([]byte(testTimeMap))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestMementoClosest(t *testing.T) {
+	server := newTestMementoServer(t)
+	client := newMementoClient(server.URL+"/timegate/", server.URL+"/timemap/")
+
+	tests := []struct {
+		name    string
+		url     string
+		at      time.Time
+		wantUrl string
+	}{
+		{
+			name:    "newest",
+			url:     "http://example.com/",
+			at:      time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+			wantUrl: "http://web.archive.org/web/20220304050607/http://example.com/",
+		},
+		{
+			name:    "point in time",
+			url:     "http://example.com/",
+			at:      time.Date(2019, 6, 1, 0, 0, 0, 0, time.UTC),
+			wantUrl: "http://web.archive.org/web/20200102030405/http://example.com/",
+		},
+		{
+			name: "not archived",
+			url:  "http://example.net/",
+			at:   time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m, err := client.Closest(test.url, test.at)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if m.URL != test.wantUrl {
+				t.Errorf("got %q, want %q", m.URL, test.wantUrl)
+			}
+			if test.wantUrl != "" && (m.Archive != "web.archive.org" || m.Datetime.IsZero()) {
+				t.Errorf("memento is missing its archive or datetime: %+v", m)
+			}
+		})
+	}
+}
+
+func TestMementoBestPerArchive(t *testing.T) {
+	server := newTestMementoServer(t)
+	client := newMementoClient(server.URL+"/timegate/", server.URL+"/timemap/")
+
+	best, err := client.BestPerArchive("http://example.com/", time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var got []string
+	for _, m := range best {
+		got = append(got, m.URL)
+	}
+	want := []string{
+		"https://archive.ph/20210101000000/http://example.com/",
+		"http://web.archive.org/web/20200102030405/http://example.com/",
+	}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	mementos, err := client.TimeMap("http://example.net/")
+	if err != nil || len(mementos) != 0 {
+		t.Errorf("got %v and %v for a URL that isn't archived, want nothing", mementos, err)
+	}
+}
@@ -1,16 +1,21 @@
 package bot
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
 	log "github.com/sirupsen/logrus"
+	globals "github.com/tyzbit/go-discord-archiver/globals"
 )
 
 // sendArchiveResponse sends the message with a result from archive.org
-func (bot *ArchiverBot) sendArchiveResponse(userMessage *discordgo.Message, messagesToSend *discordgo.MessageSend) error {
+func (bot *ArchiverBot) sendArchiveResponse(userMessage *discordgo.Message, messagesToSend *discordgo.MessageSend) (*discordgo.Message, error) {
 	username := ""
 	user, err := bot.DG.User(userMessage.Member.User.ID)
 	if err != nil {
@@ -26,25 +31,37 @@ func (bot *ArchiverBot) sendArchiveResponse(userMessage *discordgo.Message, mess
 		// Do a lookup for the full guild object
 		guild, gErr = bot.DG.Guild(userMessage.GuildID)
 		if gErr != nil {
-			return gErr
+			return nil, gErr
 		}
 		log.Debugf("sending archive message response in %s(%s), calling user: %s(%s)",
 			guild.Name, guild.ID, username, userMessage.Member.User.ID)
 	}
 
 	botMessage, err := bot.DG.ChannelMessageSendComplex(userMessage.ChannelID, messagesToSend)
+	if err != nil {
+		log.Errorf("problem sending message: %v", err)
+		return nil, err
+	}
+
 	// For some reason, this message is absent a Guild ID, so we copy from the previous message
-	if guild.ID != "" {
+	if guild != nil && guild.ID != "" {
 		botMessage.GuildID = guild.ID
 	}
 
-	if err != nil {
-		log.Errorf("problem sending message: %v", err)
-		return err
-	}
+	bot.scheduleRetryButtonRemoval(botMessage)
+	return botMessage, nil
+}
 
-	go bot.removeRetryButtonAfterSleep(botMessage)
-	return nil
+// editArchiveResponse replaces the embeds of a message sent with
+// sendArchiveResponse. Components are left alone so the retry button
+// isn't put back after it's removed
+func (bot *ArchiverBot) editArchiveResponse(botMessage *discordgo.Message, message *discordgo.MessageSend) error {
+	_, err := bot.DG.ChannelMessageEditComplex(&discordgo.MessageEdit{
+		Embeds:  &message.Embeds,
+		ID:      botMessage.ID,
+		Channel: botMessage.ChannelID,
+	})
+	return err
 }
 
 // sendArchiveResponse sends the message with a result from archive.org
@@ -75,8 +92,11 @@ func (bot *ArchiverBot) sendArchiveCommandResponse(i *discordgo.Interaction, mes
 	}
 
 	interactionMessage, err := bot.DG.InteractionResponseEdit(i, &discordgo.WebhookEdit{
+		// This also clears the queue position, if there was one
+		Content:    &message.Content,
 		Embeds:     &message.Embeds,
 		Components: &message.Components,
+		Files:      message.Files,
 	})
 
 	if err != nil {
@@ -95,26 +115,33 @@ func (bot *ArchiverBot) sendArchiveCommandResponse(i *discordgo.Interaction, mes
 	return nil
 }
 
-func (bot *ArchiverBot) removeRetryButtonAfterSleep(message *discordgo.Message) {
-	var guild *discordgo.Guild
-	var gErr error
-	guild, gErr = bot.DG.Guild(message.GuildID)
-	if gErr != nil || guild.ID == "" {
-		log.Errorf("unable to look up server by id: %v", message.GuildID)
-		message.GuildID = ""
-		guild = &discordgo.Guild{
-			Name: "GuildLookupError",
-		}
-	}
+// editArchiveCommandResponse replaces the embeds of a reply sent with
+// sendArchiveCommandResponse
+func (bot *ArchiverBot) editArchiveCommandResponse(i *discordgo.Interaction, message *discordgo.MessageSend) error {
+	_, err := bot.DG.InteractionResponseEdit(i, &discordgo.WebhookEdit{
+		Embeds: &message.Embeds,
+	})
+	return err
+}
 
-	sc := bot.getServerConfig(guild.ID)
-	var sleep int32
+// retryButtonRemoval is the payload of a taskRemoveRetryButton task
+type retryButtonRemoval struct {
+	GuildID   string
+	ChannelID string
+	MessageID string
+}
+
+// scheduleRetryButtonRemoval schedules removing the retry button from
+// message after the delay in the server's settings
+func (bot *ArchiverBot) scheduleRetryButtonRemoval(message *discordgo.Message) {
+	sc := bot.getServerConfig(message.GuildID)
+	var delay int32
 	if sc.RemoveRetriesDelay.Valid {
 		if sc.RemoveRetriesDelay.Int32 == 0 {
 			// 0 is disabled
 			return
 		}
-		sleep = sc.RemoveRetriesDelay.Int32
+		delay = sc.RemoveRetriesDelay.Int32
 	} else {
 		field := "RemoveRetriesDelay"
 		log.Debugf("%s was not set, getting gorm default", field)
@@ -122,23 +149,82 @@ func (bot *ArchiverBot) removeRetryButtonAfterSleep(message *discordgo.Message)
 		if value, err := strconv.ParseInt(strings.Split(gormDefault, ":")[1], 10, 32); err != nil {
 			log.Errorf("unable to get default gorm value for %s", field)
 		} else {
-			sleep = int32(value)
+			delay = int32(value)
 		}
 	}
-	time.Sleep(time.Duration(sleep) * time.Second)
+
+	err := bot.scheduleTask(taskRemoveRetryButton, time.Now().Add(time.Duration(delay)*time.Second), retryButtonRemoval{
+		GuildID:   message.GuildID,
+		ChannelID: message.ChannelID,
+		MessageID: message.ID,
+	})
+	if err != nil {
+		log.Errorf("unable to schedule removing retry button on message id %v: %v", message.ID, err)
+	}
+}
+
+// removeRetryButton handles taskRemoveRetryButton tasks. Messages that
+// were deleted in the meantime are skipped
+func (bot *ArchiverBot) removeRetryButton(payload []byte) error {
+	var removal retryButtonRemoval
+	if err := json.Unmarshal(payload, &removal); err != nil {
+		return fmt.Errorf("unable to decode retry button removal: %w", err)
+	}
+
+	message, err := bot.DG.ChannelMessage(removal.ChannelID, removal.MessageID)
+	if isNotFound(err) {
+		log.Debugf("message id %v was deleted before its retry button was removed", removal.MessageID)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to look up message id %v: %w", removal.MessageID, err)
+	}
+
+	components := withoutRetryButton(message.Components)
 	me := discordgo.MessageEdit{
-		// Remove the components (button)
-		Components: &[]discordgo.MessageComponent{},
-		Embeds:     &message.Embeds,
+		// Remove the retry button. Embeds are left alone because
+		// they may have been updated since the message was sent
+		Components: &components,
 		ID:         message.ID,
 		Channel:    message.ChannelID,
 	}
 
-	log.Debugf("removing retry button (waited %vs) for message ID %s in channel %s, guild: %s(%s)",
-		sleep, message.ID, message.ChannelID, sc.Name, sc.DiscordId)
-	_, err := bot.DG.ChannelMessageEditComplex(&me)
-	if err != nil {
-		log.Errorf("unable to remove retry button on message id %v, server: %s(%s): %v, ",
-			message.ID, message.GuildID, guild.Name, err)
+	log.Debugf("removing retry button for message ID %s in channel %s, guild: %s",
+		message.ID, message.ChannelID, removal.GuildID)
+	if _, err := bot.DG.ChannelMessageEditComplex(&me); err != nil && !isNotFound(err) {
+		return fmt.Errorf("unable to remove retry button on message id %v, server: %s: %w",
+			message.ID, removal.GuildID, err)
+	}
+	return nil
+}
+
+// isNotFound returns whether err is Discord saying something doesn't exist
+func isNotFound(err error) bool {
+	var restErr *discordgo.RESTError
+	return errors.As(err, &restErr) && restErr.Response != nil && restErr.Response.StatusCode == http.StatusNotFound
+}
+
+// withoutRetryButton returns components with the retry button removed,
+// along with any rows that are left empty
+func withoutRetryButton(components []discordgo.MessageComponent) (kept []discordgo.MessageComponent) {
+	kept = []discordgo.MessageComponent{}
+	for _, component := range components {
+		row, ok := component.(*discordgo.ActionsRow)
+		if !ok {
+			kept = append(kept, component)
+			continue
+		}
+
+		var buttons []discordgo.MessageComponent
+		for _, c := range row.Components {
+			if button, ok := c.(*discordgo.Button); ok && button.CustomID == globals.Retry {
+				continue
+			}
+			buttons = append(buttons, c)
+		}
+		if len(buttons) > 0 {
+			kept = append(kept, discordgo.ActionsRow{Components: buttons})
+		}
 	}
+	return kept
 }
@@ -0,0 +1,126 @@
+package bot
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	archiveTodayProviderName string = "archive.today"
+	archiveTodayRoot         string = "https://archive.ph"
+	archiveTodayWipPath      string = "/wip/"
+)
+
+// archiveTodayProvider looks up and takes snapshots with archive.today
+// (also known as archive.ph and archive.is)
+type archiveTodayProvider struct {
+	root   string
+	client *http.Client
+}
+
+// newArchiveTodayProvider returns an archiveTodayProvider that talks to root.
+// Redirects are not followed because archive.today answers with the
+// snapshot location
+func newArchiveTodayProvider(root string) archiveTodayProvider {
+	return archiveTodayProvider{
+		root: strings.TrimSuffix(root, "/"),
+		client: &http.Client{
+			Timeout: time.Minute,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// Name returns the name of the provider
+func (p archiveTodayProvider) Name() string {
+	return archiveTodayProviderName
+}
+
+// DisplayName returns the name shown to users
+func (p archiveTodayProvider) DisplayName() string {
+	return "archive.today"
+}
+
+// Capabilities returns what archive.today supports
+func (p archiveTodayProvider) Capabilities() ProviderCapabilities {
+	return ProviderCapabilities{
+		Lookup:      true,
+		Snapshot:    true,
+		PointInTime: true,
+	}
+}
+
+// Lookup asks the archive.today TimeGate for the newest capture of a URL,
+// or the one closest to req.At if it's set
+func (p archiveTodayProvider) Lookup(req ArchiveRequest) (string, error) {
+	var snapshotUrl string
+	err := retryRequest(req.RetryAttempts, func() error {
+		r, err := http.NewRequest(http.MethodGet, p.root+"/timegate/"+req.URL, nil)
+		if err != nil {
+			return fmt.Errorf("could not build http request: %w", err)
+		}
+		r.Header.Set("User-Agent", userAgent)
+		if !req.At.IsZero() {
+			r.Header.Set("Accept-Datetime", req.At.UTC().Format(http.TimeFormat))
+		}
+
+		resp, err := guardedDo(serviceName(r.URL.Hostname()), p.client, r)
+		if err != nil {
+			return fmt.Errorf("error calling archive.today timegate: %w", err)
+		}
+		defer resp.Body.Close()
+
+		switch {
+		case resp.StatusCode == http.StatusNotFound:
+			// There are no captures
+			return nil
+		case resp.StatusCode >= 300 && resp.StatusCode < 400:
+			snapshotUrl = resp.Header.Get("Location")
+			return nil
+		}
+		return fmt.Errorf("archive.today timegate had unexpected http status code: %v", resp.StatusCode)
+	})
+	return snapshotUrl, err
+}
+
+// Snapshot submits a URL to archive.today. archive.today either redirects
+// to an existing capture or returns a work-in-progress page, in which case
+// the URL the capture will have once it's finished is returned
+func (p archiveTodayProvider) Snapshot(req ArchiveRequest) (string, error) {
+	var snapshotUrl string
+	err := retryRequest(req.RetryAttempts, func() error {
+		form := url.Values{"url": {req.URL}, "anyway": {"1"}}
+		r, err := http.NewRequest(http.MethodPost, p.root+"/submit/", strings.NewReader(form.Encode()))
+		if err != nil {
+			return fmt.Errorf("could not build http request: %w", err)
+		}
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.Header.Set("User-Agent", userAgent)
+
+		resp, err := guardedDo(serviceName(r.URL.Hostname()), p.client, r)
+		if err != nil {
+			return fmt.Errorf("error calling archive.today: %w", err)
+		}
+		defer resp.Body.Close()
+
+		if location := resp.Header.Get("Location"); location != "" {
+			snapshotUrl = strings.Replace(location, archiveTodayWipPath, "/", 1)
+			return nil
+		}
+
+		// The Refresh header looks like "0;url=https://archive.ph/wip/abcde"
+		if refresh := resp.Header.Get("Refresh"); refresh != "" {
+			if _, location, found := strings.Cut(refresh, "url="); found {
+				snapshotUrl = strings.Replace(location, archiveTodayWipPath, "/", 1)
+				return nil
+			}
+		}
+		return fmt.Errorf("archive.today had unexpected http status code: %v", resp.StatusCode)
+	})
+	return snapshotUrl, err
+}
@@ -0,0 +1,103 @@
+package bot
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestArchiveTodayServer stands in for archive.today. It has a capture
+// of https://example.com/old and takes new ones of anything else, except
+// https://example.com/broken
+func newTestArchiveTodayServer(t *testing.T) *httptest.Server {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/timegate/"):
+			target := strings.TrimPrefix(r.URL.Path, "/timegate/")
+			if target != "https://example.com/old" {
+				http.NotFound(w, r)
+				return
+			}
+			id := "newest"
+			if r.Header.Get("Accept-Datetime") != "" {
+				id = "closest"
+			}
+			http.Redirect(w, r, server.URL+"/"+id, http.StatusFound)
+		case r.URL.Path == "/submit/" && r.Method == http.MethodPost:
+			switch r.PostFormValue("url") {
+			case "https://example.com/old":
+				http.Redirect(w, r, server.URL+"/newest", http.StatusFound)
+			case "https://example.com/broken":
+				w.WriteHeader(http.StatusOK)
+			case "https://example.com/redirected":
+				// New captures sometimes redirect to the work-in-progress page
+				http.Redirect(w, r, server.URL+"/wip/redirected", http.StatusFound)
+			default:
+				w.Header().Set("Refresh", "0;url="+server.URL+"/wip/abcde")
+				w.WriteHeader(http.StatusOK)
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestArchiveTodayLookup(t *testing.T) {
+	server := newTestArchiveTodayServer(t)
+	p := newArchiveTodayProvider(server.URL + "/")
+
+	tests := []struct {
+		name string
+		req  ArchiveRequest
+		want string
+	}{
+		{"newest", ArchiveRequest{URL: "https://example.com/old"}, server.URL + "/newest"},
+		{"closest to a time", ArchiveRequest{URL: "https://example.com/old", At: time.Now()}, server.URL + "/closest"},
+		{"no captures", ArchiveRequest{URL: "https://example.com/new"}, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := p.Lookup(test.req)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestArchiveTodaySnapshot(t *testing.T) {
+	server := newTestArchiveTodayServer(t)
+	p := newArchiveTodayProvider(server.URL)
+
+	tests := []struct {
+		url     string
+		want    string
+		wantErr bool
+	}{
+		{url: "https://example.com/old", want: server.URL + "/newest"},
+		{url: "https://example.com/new", want: server.URL + "/abcde"},
+		{url: "https://example.com/redirected", want: server.URL + "/redirected"},
+		{url: "https://example.com/broken", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.url, func(t *testing.T) {
+			got, err := p.Snapshot(ArchiveRequest{URL: test.url})
+			if (err != nil) != test.wantErr {
+				t.Fatalf("got error %v, want error %v", err, test.wantErr)
+			}
+			if got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,254 @@
+package bot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	goarchive "github.com/tyzbit/go-archive"
+	"github.com/tyzbit/go-discord-archiver/globals"
+)
+
+const (
+	waybackProviderName string = "wayback"
+	waybackApi          string = "https://wwwb-api.archive.org"
+	archiveOrgService   string = "archive.org"
+)
+
+// waybackSaveResponse is the response from Save Page Now. The one in
+// go-archive has the wrong tag for status_ext
+type waybackSaveResponse struct {
+	goarchive.ArchiveOrgWaybackSaveResponse
+	StatusExt string `json:"status_ext,omitempty"`
+}
+
+// waybackStatusResponse is the status of a Save Page Now job. The one in
+// go-archive leaves out why a job failed
+type waybackStatusResponse struct {
+	goarchive.ArchiveOrgWaybackStatusResponse
+	Message   string `json:"message,omitempty"`
+	StatusExt string `json:"status_ext,omitempty"`
+}
+
+// loginRejected returns why a Save Page Now response means the login
+// wasn't accepted, or an empty string if it was
+func loginRejected(statusCode int, s waybackSaveResponse) string {
+	switch {
+	case statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden:
+		return fmt.Sprintf("http status code %v", statusCode)
+	case s.StatusExt == "error:unauthorized":
+		return s.StatusExt
+	case strings.Contains(strings.ToLower(s.Message), "logged in"),
+		strings.Contains(strings.ToLower(s.Message), "log in"):
+		return s.Message
+	}
+	return ""
+}
+
+// waybackProvider looks up and takes snapshots with the Wayback Machine
+type waybackProvider struct {
+	// accounts are the logins used to take snapshots
+	accounts *accountPool
+}
+
+// Name returns the name of the provider
+func (p waybackProvider) Name() string {
+	return waybackProviderName
+}
+
+// DisplayName returns the name shown to users
+func (p waybackProvider) DisplayName() string {
+	return "Archive.org"
+}
+
+// Capabilities returns what the Wayback Machine supports
+func (p waybackProvider) Capabilities() ProviderCapabilities {
+	return ProviderCapabilities{
+		Lookup:      true,
+		Snapshot:    true,
+		PointInTime: true,
+	}
+}
+
+// Lookup returns the newest existing snapshot for a URL, or the one closest
+// to req.At if it's set
+func (p waybackProvider) Lookup(req ArchiveRequest) (string, error) {
+	var snapshotUrl string
+	err := retryRequest(req.RetryAttempts, func() error {
+		params := url.Values{"url": {req.URL}}
+		// Without a timestamp, the newest snapshot is returned
+		if !req.At.IsZero() {
+			params.Set("timestamp", req.At.UTC().Format(globals.ArchiveOrgTimestampLayout))
+		}
+
+		client := http.Client{Timeout: time.Minute}
+		resp, err := guardedGet(&client, waybackApi+"/wayback/available?"+params.Encode())
+		if err != nil {
+			return fmt.Errorf("error calling archive.org wayback api: %w", err)
+		}
+		defer resp.Body.Close()
+
+		available := goarchive.ArchiveOrgWaybackAvailableResponse{}
+		if err := json.NewDecoder(resp.Body).Decode(&available); err != nil {
+			return fmt.Errorf("error unmarshalling json: %w", err)
+		}
+		snapshotUrl = available.ArchivedSnapshots.Closest.URL
+		return nil
+	})
+	return snapshotUrl, err
+}
+
+// checkSparkline returns how many captures of a URL there are each month
+// and the oldest and newest
+func checkSparkline(originalUrl string) (r goarchive.ArchiveOrgWaybackSparklineResponse, err error) {
+	params := url.Values{"collection": {"web"}, "output": {"json"}, "url": {originalUrl}}
+	client := http.Client{Timeout: time.Minute}
+	resp, err := guardedGet(&client, waybackApi+"/__wb/sparkline/?"+params.Encode())
+	if err != nil {
+		return r, fmt.Errorf("error calling archive.org sparkline api: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return r, fmt.Errorf("error unmarshalling json: %w", err)
+	}
+	return r, nil
+}
+
+// Snapshot asks the Wayback Machine to archive a URL and waits for it to
+// finish. Without a logged-in cookie or API keys, archive.org may turn it
+// down
+func (p waybackProvider) Snapshot(req ArchiveRequest) (string, error) {
+	jobID, err := p.StartSnapshot(req)
+	if err != nil {
+		return "", err
+	}
+
+	status, err := waitForSnapshot(p, jobID, snapshotJobTimeout, nil)
+	if err != nil {
+		return "", err
+	}
+	return status.URL, status.Err
+}
+
+// StartSnapshot submits a URL to Save Page Now and returns the job ID
+// without waiting for the snapshot to finish. Each attempt uses the next
+// available account, if any are configured
+func (p waybackProvider) StartSnapshot(req ArchiveRequest) (jobID string, err error) {
+	err = retryRequest(req.RetryAttempts, func() error {
+		account, err := p.accounts.Acquire()
+		if err != nil {
+			return err
+		}
+
+		form := url.Values{"url": {req.URL}, "capture_all": {"1"}}
+		if req.Options.CaptureOutlinks {
+			form.Set("capture_outlinks", "1")
+		}
+		if req.Options.CaptureScreenshot {
+			form.Set("capture_screenshot", "1")
+		}
+		if req.Options.SkipIfArchivedWithin > 0 {
+			form.Set("if_not_archived_within", fmt.Sprintf("%dh", int(req.Options.SkipIfArchivedWithin.Hours())))
+		}
+		if req.Options.JSDelay > 0 {
+			form.Set("js_behavior_timeout", fmt.Sprint(int(req.Options.JSDelay.Seconds())))
+		}
+		r, err := http.NewRequest(http.MethodPost, waybackApi+"/save", strings.NewReader(form.Encode()))
+		if err != nil {
+			return fmt.Errorf("could not build http request: %w", err)
+		}
+		r.Header.Set("Accept", "application/json")
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.Header.Set("User-Agent", userAgent)
+
+		client := http.Client{Timeout: time.Minute}
+		var resp *http.Response
+		if account == nil {
+			// No accounts are configured, so archive.org is asked without
+			// logging in
+			resp, err = guardedDo(archiveOrgService, &client, r)
+		} else {
+			account.authorize(r)
+			// Each account has its own limits on top of the ones for archive.org
+			resp, err = guardedAccountDo(archiveOrgService, account.Name, &client, r)
+			var limited rateLimitedError
+			if errors.As(err, &limited) && limited.service != archiveOrgService {
+				p.accounts.RateLimited(account, limited.retryAfter)
+				// Another account might not be rate limited, so this is
+				// worth retrying
+				return fmt.Errorf("rate limited by archive.org using %s", account.Name)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("error calling archive.org: %w", err)
+		}
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return fmt.Errorf("unable to read response body, err: %w", err)
+		}
+
+		s := waybackSaveResponse{}
+		_ = json.Unmarshal(body, &s)
+		if reason := loginRejected(resp.StatusCode, s); reason != "" && account != nil {
+			p.accounts.Expire(account, reason)
+			return fmt.Errorf("archive.org login for %s has expired: %s", account.Name, reason)
+		}
+		if s.JobID == "" {
+			message := s.Message
+			if message == "" {
+				message = strings.TrimSpace(string(body))
+			}
+			return fmt.Errorf("archive.org did not respond with a job_id: %v", message)
+		}
+		if account != nil {
+			p.accounts.Accepted(account)
+		}
+		jobID = s.JobID
+		return nil
+	})
+	return jobID, err
+}
+
+// SnapshotStatus checks on a Save Page Now job
+func (p waybackProvider) SnapshotStatus(jobID string) (status SnapshotJobStatus, err error) {
+	client := http.Client{Timeout: time.Minute}
+	resp, err := guardedGet(&client, waybackApi+"/save/status/"+url.PathEscape(jobID))
+	if err != nil {
+		return status, fmt.Errorf("error calling archive.org status api: %w", err)
+	}
+	defer resp.Body.Close()
+
+	r := waybackStatusResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return status, fmt.Errorf("error unmarshalling json: %w", err)
+	}
+
+	switch r.Status {
+	case "pending":
+		status.Pending = true
+	case "success":
+		status.URL = fmt.Sprintf("%s/%s/%s", archiveRoot, r.Timestamp, r.OriginalURL)
+	default:
+		reason := r.Status
+		if r.StatusExt != "" {
+			reason += ", " + r.StatusExt
+		}
+		status.Err = fmt.Errorf("archive.org was unable to take a snapshot (status: %s)", reason)
+		if r.Message != "" {
+			status.Err = fmt.Errorf("archive.org was unable to take a snapshot (status: %s): %s", reason, r.Message)
+		}
+	}
+	status.Outlinks = r.Counters.Outlinks
+	status.Embeds = r.Counters.Embeds
+	status.Duration = time.Duration(float64(r.DurationSec) * float64(time.Second))
+	status.FirstArchive = r.FirstArchive
+	return status, nil
+}
@@ -0,0 +1,211 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ArchiveProvider is a service that can look up or take snapshots of a URL.
+// Every configured provider is asked about every URL, and the first one
+// (in configured order) to return a URL is used as the main link in replies
+type ArchiveProvider interface {
+	// Name is a short identifier for the provider, it is stored with
+	// every ArchiveEvent the provider produces
+	Name() string
+	// DisplayName is the name of the provider shown to users
+	DisplayName() string
+	// Capabilities reports what the provider is able to do
+	Capabilities() ProviderCapabilities
+	// Lookup returns the URL of an existing snapshot, or an empty string
+	// if there isn't one
+	Lookup(req ArchiveRequest) (string, error)
+	// Snapshot requests a new snapshot and returns its URL
+	Snapshot(req ArchiveRequest) (string, error)
+}
+
+// AsyncSnapshotter is implemented by providers that take snapshots in the
+// background. Snapshots are started and then checked on until they finish
+type AsyncSnapshotter interface {
+	// StartSnapshot requests a new snapshot and returns a job ID
+	StartSnapshot(req ArchiveRequest) (jobID string, err error)
+	// SnapshotStatus returns the status of a snapshot job
+	SnapshotStatus(jobID string) (SnapshotJobStatus, error)
+}
+
+// SnapshotJobStatus is the state of a snapshot that was started with
+// StartSnapshot. If the job is done and Err is nil, URL is the snapshot
+type SnapshotJobStatus struct {
+	Pending      bool
+	URL          string
+	Err          error
+	Outlinks     int
+	Embeds       int
+	Duration     time.Duration
+	FirstArchive bool
+}
+
+// ProviderCapabilities describes which ArchiveProvider calls are supported
+type ProviderCapabilities struct {
+	Lookup   bool
+	Snapshot bool
+	// PointInTime is whether Lookup can find snapshots closest to a time
+	PointInTime bool
+}
+
+// ArchiveRequest has everything a provider needs to handle a single URL
+type ArchiveRequest struct {
+	URL           string
+	RetryAttempts uint
+	Options       SnapshotOptions
+	// At asks for the existing snapshot closest to this time instead of
+	// the newest one. New snapshots are never taken when it's set
+	At time.Time
+	// Bulk requests, like ones from /archive-site, wait for their turn
+	// under BULK_RATE_LIMIT
+	Bulk bool
+	// ServerID and UserID are who the request is for, new snapshots count
+	// against their quotas
+	ServerID string
+	UserID   string
+	// chargeSnapshot is called before a lookup that missed takes a new
+	// snapshot instead. If it returns an error, the snapshot isn't taken
+	chargeSnapshot func() error
+}
+
+// SnapshotOptions are optional settings for taking new snapshots.
+// Providers ignore options they don't support
+type SnapshotOptions struct {
+	CaptureOutlinks   bool
+	CaptureScreenshot bool
+	// SkipIfArchivedWithin reuses an existing snapshot instead of taking a
+	// new one if it's newer than this
+	SkipIfArchivedWithin time.Duration
+	// JSDelay is how long to let JavaScript run on the page before
+	// capturing it. Zero uses the provider's default
+	JSDelay time.Duration
+}
+
+// snapshotOptions returns the SnapshotOptions configured for a server
+func snapshotOptions(sc ServerConfig) SnapshotOptions {
+	return SnapshotOptions{
+		CaptureOutlinks:      sc.CaptureOutlinks.Valid && sc.CaptureOutlinks.Bool,
+		CaptureScreenshot:    sc.CaptureScreenshot.Valid && sc.CaptureScreenshot.Bool,
+		SkipIfArchivedWithin: time.Duration(sc.SkipIfArchivedWithin.Int32) * time.Hour,
+		JSDelay:              time.Duration(sc.JSDelay.Int32) * time.Second,
+	}
+}
+
+// newWaybackProvider returns a waybackProvider using the accounts in config
+func newWaybackProvider(config ArchiverBotConfig) waybackProvider {
+	return waybackProvider{
+		accounts: newAccountPool(config.Cookies, config.Cookie, config.ArchiveOrgKeys, config.ArchiveOrgLogins,
+			config.ArchiveOrgLoginURL, config.AccountHourlyBudget),
+	}
+}
+
+// NewArchiveProviders returns the providers named in the comma-separated
+// ArchiveProviders config setting, in order. If none are configured, the
+// Wayback Machine is used
+func NewArchiveProviders(config ArchiverBotConfig) (providers []ArchiveProvider) {
+	// Every request to a provider shares the same limits
+	serviceGuards.SetRate(config.RateLimit)
+	setBulkRate(config.BulkRateLimit, config.RateLimit)
+	for _, name := range strings.Split(config.ArchiveProviders, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		switch name {
+		case "":
+			continue
+		case waybackProviderName:
+			providers = append(providers, newWaybackProvider(config))
+		case archiveTodayProviderName, "archivetoday", "archive.ph", "archive.is":
+			providers = append(providers, newArchiveTodayProvider(archiveTodayRoot))
+		case mementoProviderName:
+			providers = append(providers, mementoProvider{
+				client: newMementoClient(config.MementoTimeGateURL, config.MementoTimeMapURL),
+			})
+		default:
+			log.Warnf("unknown archive provider %s, ignoring", name)
+		}
+	}
+
+	if len(providers) == 0 {
+		providers = append(providers, newWaybackProvider(config))
+	}
+	return providers
+}
+
+// getProvider returns the configured provider with the given name. Archive
+// events from before providers were recorded have no name, those came
+// from the Wayback Machine
+func (bot *ArchiverBot) getProvider(name string) ArchiveProvider {
+	if name == "" {
+		name = waybackProviderName
+	}
+	for _, p := range bot.Providers {
+		if p.Name() == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// providerDisplayName returns the name to show users for a provider name
+func (bot *ArchiverBot) providerDisplayName(name string) string {
+	if p := bot.getProvider(name); p != nil {
+		return p.DisplayName()
+	}
+	return name
+}
+
+// requestArchive asks a provider for a snapshot URL of req.URL. If
+// takeSnapshot is true, existing snapshots are not looked up. If req.At is
+// set, only providers that can look up snapshots from then are asked. Providers
+// that take snapshots in the background return a job ID instead of a URL
+func (bot *ArchiverBot) requestArchive(p ArchiveProvider, req ArchiveRequest, takeSnapshot bool) (url string, jobID string, err error) {
+	capabilities := p.Capabilities()
+	if !req.At.IsZero() {
+		// Snapshots from the past can only be looked up
+		if !capabilities.Lookup || !capabilities.PointInTime {
+			log.Debugf("%s can't look up snapshots from a point in time", p.Name())
+			return "", "", nil
+		}
+		url, err = p.Lookup(req)
+		if err != nil {
+			return "", "", fmt.Errorf("error checking if url is available with %s: %w", p.Name(), err)
+		}
+		return url, "", nil
+	}
+
+	if !takeSnapshot && capabilities.Lookup {
+		url, err = p.Lookup(req)
+		if err != nil {
+			return "", "", fmt.Errorf("error checking if url is available with %s: %w", p.Name(), err)
+		}
+	}
+
+	if url == "" && capabilities.Snapshot {
+		// Snapshots that weren't asked for weren't charged up front
+		if !takeSnapshot && req.chargeSnapshot != nil {
+			if err := req.chargeSnapshot(); err != nil {
+				return "", "", fmt.Errorf("%s has no snapshot yet. %w", p.DisplayName(), err)
+			}
+		}
+		if async, ok := p.(AsyncSnapshotter); ok {
+			jobID, err = async.StartSnapshot(req)
+			if err != nil {
+				return "", "", fmt.Errorf("unable to start snapshot with %s: %w", p.Name(), err)
+			}
+			return "", jobID, nil
+		}
+
+		url, err = p.Snapshot(req)
+		if err != nil {
+			return "", "", fmt.Errorf("unable to archive url with %s: %w", p.Name(), err)
+		}
+	}
+
+	return url, "", nil
+}
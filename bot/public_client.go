@@ -0,0 +1,92 @@
+package bot
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// Address ranges that aren't on the public internet, on top of the ones
+// net.IP already knows about
+var nonPublicNetworks = mustParseCIDRs(
+	"0.0.0.0/8",     // "this network"
+	"100.64.0.0/10", // carrier-grade NAT
+	"192.0.0.0/24",  // IETF protocol assignments
+	"198.18.0.0/15", // benchmarking
+	"240.0.0.0/4",   // reserved
+)
+
+// publicTransport is shared by every public client, so connections are
+// reused. Proxies aren't used, since the address of the site itself has to
+// be checked
+var publicTransport = &http.Transport{
+	DialContext: (&net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   publicOnly,
+	}).DialContext,
+	ForceAttemptHTTP2:     true,
+	MaxIdleConns:          100,
+	IdleConnTimeout:       90 * time.Second,
+	TLSHandshakeTimeout:   10 * time.Second,
+	ExpectContinueTimeout: time.Second,
+}
+
+// privateAddressError is returned instead of connecting to an address
+// that isn't on the public internet
+type privateAddressError struct {
+	address string
+}
+
+func (e privateAddressError) Error() string {
+	return fmt.Sprintf("%s is not a public address", e.address)
+}
+
+// newPublicClient returns an http.Client for fetching URLs users give the
+// bot. It only connects to public addresses, so users can't use the bot to
+// reach the network it runs on. The address is checked when connecting,
+// after DNS lookups, so redirects and DNS tricks are caught too
+func newPublicClient(timeout time.Duration) *http.Client {
+	return &http.Client{Timeout: timeout, Transport: publicTransport}
+}
+
+// publicOnly is a net.Dialer Control function that stops connections to
+// addresses that aren't public
+func publicOnly(network string, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("unable to parse address %s: %w", address, err)
+	}
+	if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+		return privateAddressError{address: host}
+	}
+	return nil
+}
+
+// isPublicIP returns whether ip is on the public internet
+func isPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsMulticast() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return false
+	}
+	for _, network := range nonPublicNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// mustParseCIDRs parses CIDR ranges that are known to be valid
+func mustParseCIDRs(cidrs ...string) (networks []*net.IPNet) {
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
@@ -0,0 +1,259 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	log "github.com/sirupsen/logrus"
+	"github.com/tyzbit/go-discord-archiver/globals"
+	"gorm.io/gorm"
+)
+
+// snapshotQuotaLock makes checking and using up quota one step, so two
+// requests at once can't both take the last snapshot
+var snapshotQuotaLock sync.Mutex
+
+// snapshotQuota is how much of their quotas a user and server have used,
+// including the request that was just allowed. A limit of 0 is no limit
+type snapshotQuota struct {
+	UserLimit   int
+	UserUsed    int
+	ServerLimit int
+	ServerUsed  int
+	// usageID is the SnapshotUsage the request was recorded as, if any
+	usageID uint
+}
+
+// remainingText returns what's left of the quotas, or an empty string if
+// there are no limits
+func (q snapshotQuota) remainingText() string {
+	var parts []string
+	if q.UserLimit > 0 {
+		parts = append(parts, fmt.Sprintf("you can take %d more new snapshots this hour", q.UserLimit-q.UserUsed))
+	}
+	if q.ServerLimit > 0 {
+		parts = append(parts, fmt.Sprintf("this server can take %d more today", q.ServerLimit-q.ServerUsed))
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	text := strings.Join(parts, " and ")
+	return "📸 " + strings.ToUpper(text[:1]) + text[1:] + "."
+}
+
+// quotaExceededError is returned when a request for new snapshots is over
+// a quota or in a cooldown. until is when it can be tried again, or the
+// zero time if it never can
+type quotaExceededError struct {
+	reason string
+	until  time.Time
+}
+
+func (e quotaExceededError) Error() string {
+	if e.until.IsZero() {
+		return e.reason + "."
+	}
+	// Discord shows the timestamp in the user's time zone
+	return fmt.Sprintf("%s, try again <t:%d:R>.", e.reason, e.until.Unix())
+}
+
+// takesNewSnapshots returns whether job takes new snapshots, which is what
+// quotas limit
+func takesNewSnapshots(job *ArchiveJob, sc ServerConfig) bool {
+	if job.RequestedTime.Valid {
+		// Looking up a point in time never takes a snapshot
+		return false
+	}
+	return job.NewSnapshot || (sc.AlwaysArchiveFirst.Valid && sc.AlwaysArchiveFirst.Bool)
+}
+
+// takeJobQuota uses up quota for the new snapshots job takes, if any
+func (bot *ArchiverBot) takeJobQuota(job *ArchiveJob) (quota snapshotQuota, err error) {
+	sc := bot.getServerConfig(job.ServerID)
+	if !takesNewSnapshots(job, sc) {
+		return quota, nil
+	}
+	seen := map[string]bool{}
+	for _, u := range job.urls() {
+		seen[u] = true
+	}
+	if len(seen) == 0 {
+		return quota, nil
+	}
+	quota, err = bot.takeSnapshotQuota(sc, job.ServerID, job.UserID, len(seen), true)
+	var exceeded quotaExceededError
+	if errors.As(err, &exceeded) {
+		log.Infof("new snapshots for user %s in server %s not allowed: %v", job.UserID, job.ServerID, err)
+	} else if err != nil {
+		// The bot still works if quotas can't be checked
+		log.Errorf("unable to check snapshot quota: %v", err)
+		return quota, nil
+	}
+	return quota, err
+}
+
+// snapshotCharger returns a function that charges one new snapshot to
+// whoever req is for, for a lookup that missed and takes a new snapshot
+// instead. Only the first call charges, later ones return the same result.
+// The cooldown isn't checked, since it's for asking for new snapshots
+func (bot *ArchiverBot) snapshotCharger(sc ServerConfig, req ArchiveRequest) func() error {
+	var once sync.Once
+	var err error
+	return func() error {
+		once.Do(func() {
+			_, err = bot.takeSnapshotQuota(sc, req.ServerID, req.UserID, 1, false)
+			var exceeded quotaExceededError
+			if errors.As(err, &exceeded) {
+				log.Infof("new snapshot of %s for user %s in server %s not allowed: %v",
+					req.URL, req.UserID, req.ServerID, err)
+			} else if err != nil {
+				log.Errorf("unable to check snapshot quota: %v", err)
+				err = nil
+			}
+		})
+		return err
+	}
+}
+
+// takeSnapshotQuota checks that userID can take count new snapshots in
+// serverID and records them if so. A quotaExceededError is returned if
+// not. Requests from DMs only have the per-user limits. cooldown is
+// whether the server's cooldown between new snapshots applies
+func (bot *ArchiverBot) takeSnapshotQuota(sc ServerConfig, serverID string, userID string, count int, cooldown bool) (
+	quota snapshotQuota, err error) {
+	snapshotQuotaLock.Lock()
+	defer snapshotQuotaLock.Unlock()
+	now := time.Now()
+
+	if cooldown && sc.SnapshotCooldown.Valid && sc.SnapshotCooldown.Int32 > 0 {
+		var latest SnapshotUsage
+		tx := bot.DB.Where(&SnapshotUsage{ServerID: serverID, UserID: userID}).Order("created_at desc").Limit(1).Find(&latest)
+		if tx.Error != nil {
+			return quota, fmt.Errorf("unable to look up snapshot usage: %w", tx.Error)
+		}
+		until := latest.CreatedAt.Add(time.Duration(sc.SnapshotCooldown.Int32) * time.Second)
+		if tx.RowsAffected > 0 && now.Before(until) {
+			return quota, quotaExceededError{reason: "You took a new snapshot a moment ago", until: until}
+		}
+	}
+
+	if sc.SnapshotsPerUserHour.Valid && sc.SnapshotsPerUserHour.Int32 > 0 {
+		quota.UserLimit = int(sc.SnapshotsPerUserHour.Int32)
+		// Users can't get more snapshots by asking in another server
+		usage := bot.DB.Where("user_id = ?", userID)
+		quota.UserUsed, err = bot.checkSnapshotQuota(usage, time.Hour, quota.UserLimit, count,
+			"you can take in an hour")
+		if err != nil {
+			return quota, err
+		}
+	}
+
+	if serverID != "" && sc.SnapshotsPerServerDay.Valid && sc.SnapshotsPerServerDay.Int32 > 0 {
+		quota.ServerLimit = int(sc.SnapshotsPerServerDay.Int32)
+		usage := bot.DB.Where("server_id = ?", serverID)
+		quota.ServerUsed, err = bot.checkSnapshotQuota(usage, 24*time.Hour, quota.ServerLimit, count,
+			"this server can take in a day")
+		if err != nil {
+			return quota, err
+		}
+	}
+
+	usage := SnapshotUsage{ServerID: serverID, UserID: userID, Snapshots: count}
+	tx := bot.DB.Create(&usage)
+	if tx.Error != nil {
+		return quota, fmt.Errorf("unable to record snapshot usage: %w", tx.Error)
+	}
+	quota.usageID = usage.ID
+	return quota, nil
+}
+
+// refundSnapshotQuota gives back the quota taken for a request that
+// couldn't be queued
+func (bot *ArchiverBot) refundSnapshotQuota(quota snapshotQuota) {
+	if quota.usageID == 0 {
+		return
+	}
+	if tx := bot.DB.Delete(&SnapshotUsage{}, quota.usageID); tx.Error != nil {
+		log.Errorf("unable to refund snapshot usage %d: %v", quota.usageID, tx.Error)
+	}
+}
+
+// checkSnapshotQuota returns how many snapshots usage has taken in the
+// last window, including count more, or a quotaExceededError if that's
+// over limit. what describes the limit
+func (bot *ArchiverBot) checkSnapshotQuota(usage *gorm.DB, window time.Duration, limit int, count int, what string) (
+	used int, err error) {
+	var usages []SnapshotUsage
+	tx := usage.Where("created_at > ?", time.Now().Add(-window)).Order("created_at").Find(&usages)
+	if tx.Error != nil {
+		return 0, fmt.Errorf("unable to look up snapshot usage: %w", tx.Error)
+	}
+	return snapshotQuotaUsed(usages, window, limit, count, what)
+}
+
+// snapshotQuotaUsed is checkSnapshotQuota for usages, the snapshots taken
+// in the last window from oldest to newest
+func snapshotQuotaUsed(usages []SnapshotUsage, window time.Duration, limit int, count int, what string) (
+	used int, err error) {
+	if count > limit {
+		return 0, quotaExceededError{reason: fmt.Sprintf("That's more than the %d new snapshots %s", limit, what)}
+	}
+
+	for _, u := range usages {
+		used += u.Snapshots
+	}
+	if used+count <= limit {
+		return used + count, nil
+	}
+
+	// Wait until enough of the oldest snapshots fall out of the window
+	freed := used
+	for _, u := range usages {
+		freed -= u.Snapshots
+		if freed+count <= limit {
+			return used, quotaExceededError{
+				reason: fmt.Sprintf("You've used up the %d new snapshots %s", limit, what),
+				until:  u.CreatedAt.Add(window),
+			}
+		}
+	}
+	return used, quotaExceededError{reason: fmt.Sprintf("You've used up the %d new snapshots %s", limit, what)}
+}
+
+// respondQuotaExceeded replaces the deferred response to i with why the
+// request was over a quota. Public responses are deleted and the reason is
+// sent so only the user sees it
+func (bot *ArchiverBot) respondQuotaExceeded(i *discordgo.Interaction, ephemeral bool, err error) {
+	reply := quotaExceededReply(err)
+	if ephemeral {
+		if err := bot.sendArchiveCommandResponse(i, reply); err != nil {
+			log.Errorf("problem sending message: %v", err)
+		}
+		return
+	}
+	if err := bot.DG.InteractionResponseDelete(i); err != nil {
+		log.Errorf("unable to delete response: %v", err)
+	}
+	_, err = bot.DG.FollowupMessageCreate(i, true, &discordgo.WebhookParams{
+		Embeds: reply.Embeds,
+		Flags:  discordgo.MessageFlagsEphemeral,
+	})
+	if err != nil {
+		log.Errorf("problem sending message: %v", err)
+	}
+}
+
+// quotaExceededReply returns the reply for a request that was over a quota
+func quotaExceededReply(err error) *discordgo.MessageSend {
+	return &discordgo.MessageSend{
+		Embeds: []*discordgo.MessageEmbed{{
+			Title:       "Too many new snapshots",
+			Description: err.Error(),
+			Color:       globals.BrightRed,
+		}},
+	}
+}
@@ -0,0 +1,165 @@
+package bot
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSnapshotQuotaUsed(t *testing.T) {
+	now := time.Now()
+	usages := []SnapshotUsage{
+		{CreatedAt: now.Add(-50 * time.Minute), Snapshots: 2},
+		{CreatedAt: now.Add(-30 * time.Minute), Snapshots: 3},
+		{CreatedAt: now.Add(-10 * time.Minute), Snapshots: 1},
+	}
+
+	tests := []struct {
+		name      string
+		usages    []SnapshotUsage
+		limit     int
+		count     int
+		wantUsed  int
+		wantErr   bool
+		wantUntil time.Time
+	}{
+		{
+			name:     "nothing used",
+			limit:    5,
+			count:    3,
+			wantUsed: 3,
+		},
+		{
+			name:     "under the limit",
+			usages:   usages,
+			limit:    10,
+			count:    4,
+			wantUsed: 10,
+		},
+		{
+			name:    "more than the limit at once",
+			limit:   5,
+			count:   6,
+			wantErr: true,
+		},
+		{
+			name:      "oldest frees enough",
+			usages:    usages,
+			limit:     7,
+			count:     2,
+			wantUsed:  6,
+			wantErr:   true,
+			wantUntil: now.Add(10 * time.Minute),
+		},
+		{
+			name:      "needs more than the oldest",
+			usages:    usages,
+			limit:     7,
+			count:     4,
+			wantUsed:  6,
+			wantErr:   true,
+			wantUntil: now.Add(30 * time.Minute),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			used, err := snapshotQuotaUsed(test.usages, time.Hour, test.limit, test.count, "you can take in an hour")
+			if used != test.wantUsed {
+				t.Errorf("got %d used, want %d", used, test.wantUsed)
+			}
+			var exceeded quotaExceededError
+			if got := errors.As(err, &exceeded); got != test.wantErr {
+				t.Fatalf("got error %v, want a quotaExceededError %v", err, test.wantErr)
+			}
+			if !exceeded.until.Equal(test.wantUntil) {
+				t.Errorf("got until %s, want %s", exceeded.until, test.wantUntil)
+			}
+		})
+	}
+}
+
+func TestQuotaExceededError(t *testing.T) {
+	err := quotaExceededError{reason: "You've used up the 5 new snapshots you can take in an hour"}
+	if got, want := err.Error(), "You've used up the 5 new snapshots you can take in an hour."; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	err.until = time.Unix(1700000000, 0)
+	if got, want := err.Error(), "You've used up the 5 new snapshots you can take in an hour, try again <t:1700000000:R>."; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestRemainingText(t *testing.T) {
+	tests := []struct {
+		quota snapshotQuota
+		want  string
+	}{
+		{snapshotQuota{}, ""},
+		{snapshotQuota{UserLimit: 5, UserUsed: 2}, "📸 You can take 3 more new snapshots this hour."},
+		{snapshotQuota{ServerLimit: 100, ServerUsed: 40}, "📸 This server can take 60 more today."},
+		{
+			snapshotQuota{UserLimit: 5, UserUsed: 5, ServerLimit: 100, ServerUsed: 99},
+			"📸 You can take 0 more new snapshots this hour and this server can take 1 more today.",
+		},
+	}
+
+	for _, test := range tests {
+		if got := test.quota.remainingText(); got != test.want {
+			t.Errorf("remainingText() for %+v = %q, want %q", test.quota, got, test.want)
+		}
+	}
+}
+
+func TestTakesNewSnapshots(t *testing.T) {
+	always := ServerConfig{AlwaysArchiveFirst: sql.NullBool{Bool: true, Valid: true}}
+	tests := []struct {
+		name string
+		job  ArchiveJob
+		sc   ServerConfig
+		want bool
+	}{
+		{"lookup", ArchiveJob{}, ServerConfig{}, false},
+		{"new snapshot", ArchiveJob{NewSnapshot: true}, ServerConfig{}, true},
+		{"server always archives", ArchiveJob{}, always, true},
+		{"point in time", ArchiveJob{NewSnapshot: true, RequestedTime: sql.NullTime{Time: time.Now(), Valid: true}}, always, false},
+	}
+
+	for _, test := range tests {
+		if got := takesNewSnapshots(&test.job, test.sc); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestQuotaExceededReply(t *testing.T) {
+	reply := quotaExceededReply(quotaExceededError{reason: "You took a new snapshot a moment ago"})
+	if len(reply.Embeds) != 1 || !strings.HasPrefix(reply.Embeds[0].Description, "You took a new snapshot") {
+		t.Errorf("got reply %+v, want the reason in the embed", reply)
+	}
+}
+
+func TestTakeSnapshotQuota(t *testing.T) {
+	bot, _ := newTestBot(t)
+	sc := ServerConfig{SnapshotsPerUserHour: sql.NullInt32{Int32: 3, Valid: true}}
+
+	quota, err := bot.takeSnapshotQuota(sc, "server1", "user", 2, false)
+	if err != nil || quota.UserUsed != 2 {
+		t.Fatalf("got %+v and %v, want 2 snapshots used", quota, err)
+	}
+
+	// Snapshots taken in another server count against the same quota
+	if _, err := bot.takeSnapshotQuota(sc, "server2", "user", 2, false); err == nil {
+		t.Errorf("got no error going over the quota in another server")
+	}
+
+	// A request that couldn't be queued doesn't use up any quota
+	bot.refundSnapshotQuota(quota)
+	quota, err = bot.takeSnapshotQuota(sc, "server2", "user", 3, false)
+	if err != nil || quota.UserUsed != 3 {
+		t.Errorf("got %+v and %v after the refund, want 3 snapshots used", quota, err)
+	}
+}
@@ -0,0 +1,329 @@
+package bot
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+const (
+	// Discord only allows this many attachments on a message
+	maxAttachments int = 10
+)
+
+var (
+	// Wayback Machine snapshot URLs, used to get the page without the banner
+	waybackSnapshotRegex = regexp.MustCompile(`^(https?://web\.archive\.org/web/\d{14})/`)
+	// Characters that aren't allowed in attachment names
+	unsafeFilenameRegex = regexp.MustCompile(`[^a-zA-Z0-9]+`)
+	// Parts of a page that aren't part of an article
+	readerSkippedElements = map[atom.Atom]bool{
+		atom.Nav:    true,
+		atom.Aside:  true,
+		atom.Form:   true,
+		atom.Footer: true,
+		atom.Button: true,
+		atom.Select: true,
+	}
+)
+
+// article is the readable part of a page
+type article struct {
+	Title  string
+	Byline string
+	// Blocks are already formatted as Markdown
+	Blocks []string
+}
+
+// Markdown returns the article as a Markdown document, noting where it
+// came from
+func (a article) Markdown(source string) string {
+	var out strings.Builder
+	if a.Title != "" {
+		fmt.Fprintf(&out, "# %s\n\n", a.Title)
+	}
+	if a.Byline != "" {
+		fmt.Fprintf(&out, "*%s*\n\n", a.Byline)
+	}
+	fmt.Fprintf(&out, "Archived copy: <%s>\n\n---\n\n", source)
+	for i, block := range a.Blocks {
+		// List items stay together
+		if i > 0 && !(strings.HasPrefix(block, "- ") && strings.HasPrefix(a.Blocks[i-1], "- ")) {
+			out.WriteString("\n")
+		}
+		out.WriteString(block + "\n")
+	}
+	return out.String()
+}
+
+// extractArticle returns the title, byline and body of the article on an
+// HTML page. The body is the <article> or <main> element if there is one,
+// otherwise the element with the most paragraph text
+func extractArticle(page io.Reader) (a article, err error) {
+	doc, err := html.Parse(page)
+	if err != nil {
+		return a, fmt.Errorf("unable to parse html: %w", err)
+	}
+
+	var firstHeading, title, ogTitle, authorMeta, authorElement *html.Node
+	var root, mainElement, articleElement *html.Node
+	paragraphText := map[*html.Node]int{}
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch n.DataAtom {
+			case atom.Title:
+				if title == nil {
+					title = n
+				}
+			case atom.H1:
+				if firstHeading == nil {
+					firstHeading = n
+				}
+			case atom.Meta:
+				switch {
+				case attr(n, "property") == "og:title" && ogTitle == nil:
+					ogTitle = n
+				case attr(n, "name") == "author" && authorMeta == nil:
+					authorMeta = n
+				}
+			case atom.Article:
+				if articleElement == nil {
+					articleElement = n
+				}
+			case atom.Main:
+				if mainElement == nil {
+					mainElement = n
+				}
+			case atom.P:
+				if n.Parent != nil {
+					paragraphText[n.Parent] += len(textContent(n))
+				}
+			}
+			if authorElement == nil && (attr(n, "rel") == "author" ||
+				strings.Contains(strings.ToLower(attr(n, "class")), "byline")) {
+				authorElement = n
+			}
+			if attr(n, "role") == "main" && mainElement == nil {
+				mainElement = n
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+
+	switch {
+	case ogTitle != nil:
+		a.Title = strings.TrimSpace(attr(ogTitle, "content"))
+	case title != nil:
+		a.Title = textContent(title)
+	case firstHeading != nil:
+		a.Title = textContent(firstHeading)
+	}
+
+	switch {
+	case authorMeta != nil:
+		a.Byline = strings.TrimSpace(attr(authorMeta, "content"))
+	case authorElement != nil:
+		a.Byline = textContent(authorElement)
+	}
+
+	switch {
+	case articleElement != nil:
+		root = articleElement
+	case mainElement != nil:
+		root = mainElement
+	default:
+		most := 0
+		for n, length := range paragraphText {
+			if length > most {
+				root, most = n, length
+			}
+		}
+	}
+	if root == nil {
+		return a, fmt.Errorf("unable to find an article on the page")
+	}
+
+	a.Blocks = markdownBlocks(root, a.Title)
+	return a, nil
+}
+
+// markdownBlocks returns the blocks of text in n formatted as Markdown.
+// Headings that are the same as the title are left out
+func markdownBlocks(n *html.Node, title string) (blocks []string) {
+	add := func(block string) {
+		if block != "" && block != title && block != "# "+title && block != "## "+title {
+			blocks = append(blocks, block)
+		}
+	}
+
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			add(strings.Join(strings.Fields(n.Data), " "))
+			return
+		}
+		if n.Type != html.ElementNode && n.Type != html.DocumentNode {
+			return
+		}
+		if skippedElements[n.DataAtom] || readerSkippedElements[n.DataAtom] {
+			return
+		}
+
+		switch n.DataAtom {
+		case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+			// The title is the only top level heading
+			level := int(n.Data[1]-'0') + 1
+			if level > 6 {
+				level = 6
+			}
+			if text := textContent(n); text != "" {
+				add(strings.Repeat("#", level) + " " + text)
+			}
+			return
+		case atom.Li:
+			if text := textContent(n); text != "" {
+				add("- " + text)
+			}
+			return
+		case atom.Blockquote:
+			if text := textContent(n); text != "" {
+				add("> " + text)
+			}
+			return
+		case atom.Pre:
+			var raw bytes.Buffer
+			rawText(n, &raw)
+			if text := strings.TrimSpace(raw.String()); text != "" {
+				add("```\n" + text + "\n```")
+			}
+			return
+		case atom.P:
+			add(textContent(n))
+			return
+		}
+
+		// Containers with only inline content are one paragraph
+		if !hasBlockDescendant(n) {
+			add(textContent(n))
+			return
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+	return blocks
+}
+
+// hasBlockDescendant returns whether any element in n starts a new line
+func hasBlockDescendant(n *html.Node) bool {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && (blockElements[c.DataAtom] || hasBlockDescendant(c)) {
+			return true
+		}
+	}
+	return false
+}
+
+// textContent returns the text in n with whitespace collapsed
+func textContent(n *html.Node) string {
+	var raw bytes.Buffer
+	rawText(n, &raw)
+	return strings.Join(strings.Fields(raw.String()), " ")
+}
+
+// rawText writes the text in n to out as-is, skipping scripts and styles
+func rawText(n *html.Node, out *bytes.Buffer) {
+	if n.Type == html.TextNode {
+		out.WriteString(n.Data)
+		return
+	}
+	if n.Type == html.ElementNode && skippedElements[n.DataAtom] {
+		return
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		rawText(c, out)
+	}
+}
+
+// attr returns the value of the attribute of n named key
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// readableSnapshotUrl returns the URL to fetch the content of a snapshot
+// from. Wayback Machine snapshots are fetched without the banner
+func readableSnapshotUrl(snapshotUrl string) string {
+	return waybackSnapshotRegex.ReplaceAllString(snapshotUrl, "${1}id_/")
+}
+
+// articleFilename returns the name of the attachment for an article
+func articleFilename(a article, originalUrl string) string {
+	name := a.Title
+	if name == "" {
+		name = originalUrl
+	}
+	name = strings.Trim(unsafeFilenameRegex.ReplaceAllString(strings.ToLower(name), "-"), "-")
+	if len(name) > 60 {
+		name = strings.TrimRight(name[:60], "-")
+	}
+	if name == "" {
+		name = "article"
+	}
+	return name + ".md"
+}
+
+// readerModeFiles returns a Markdown attachment with the article text of
+// the main snapshot of each URL in messageUrls that has one
+func (bot *ArchiverBot) readerModeFiles(archives []ArchiveEvent, messageUrls []string, sc ServerConfig) (files []*discordgo.File) {
+	for _, originalUrl := range messageUrls {
+		if len(files) >= maxAttachments {
+			break
+		}
+
+		var snapshotUrl string
+		for _, archive := range archives {
+			if archive.RequestURL == originalUrl && archive.ResponseURL != "" {
+				snapshotUrl = archive.ResponseURL
+				break
+			}
+		}
+		if snapshotUrl == "" {
+			continue
+		}
+
+		body, _, err := fetchPage(readableSnapshotUrl(snapshotUrl), uint(sc.RetryAttempts.Int32))
+		if err != nil {
+			log.Errorf("unable to fetch snapshot for reader mode: %v, err: %v", snapshotUrl, err)
+			continue
+		}
+		a, err := extractArticle(bytes.NewReader(body))
+		if err != nil {
+			log.Errorf("unable to extract article from snapshot: %v, err: %v", snapshotUrl, err)
+			continue
+		}
+
+		files = append(files, &discordgo.File{
+			Name:        articleFilename(a, originalUrl),
+			ContentType: "text/markdown",
+			Reader:      strings.NewReader(a.Markdown(snapshotUrl)),
+		})
+	}
+	return files
+}
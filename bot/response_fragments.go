@@ -1,6 +1,7 @@
 package bot
 
 import (
+	"database/sql"
 	"fmt"
 
 	"github.com/bwmarrin/discordgo"
@@ -49,6 +50,85 @@ func retryRemoveOptions(sc ServerConfig) (options []discordgo.SelectMenuOption)
 	return options
 }
 
+// skipIfArchivedWithinOptions returns a []discordgo.SelectMenuOption for
+// how recent a snapshot has to be to be reused
+func skipIfArchivedWithinOptions(sc ServerConfig) (options []discordgo.SelectMenuOption) {
+	for _, value := range globals.AllowedSkipIfArchivedWithinValues {
+		description := ""
+		if sc.SkipIfArchivedWithin.Valid && int32(value) == sc.SkipIfArchivedWithin.Int32 {
+			description = "Current value"
+		}
+
+		menuLabel := fmt.Sprintf("%v hours", value)
+		if value == 0 {
+			menuLabel = "Always take a new snapshot"
+		}
+
+		options = append(options, discordgo.SelectMenuOption{
+			Label:       menuLabel,
+			Value:       fmt.Sprint(value),
+			Description: description,
+		})
+	}
+	return options
+}
+
+// jsDelayOptions returns a []discordgo.SelectMenuOption for JavaScript delays
+func jsDelayOptions(sc ServerConfig) (options []discordgo.SelectMenuOption) {
+	for _, value := range globals.AllowedJSDelayValues {
+		description := ""
+		if sc.JSDelay.Valid && int32(value) == sc.JSDelay.Int32 {
+			description = "Current value"
+		}
+
+		menuLabel := fmt.Sprintf("%v seconds", value)
+		if value == 0 {
+			menuLabel = "Archive.org default"
+		}
+
+		options = append(options, discordgo.SelectMenuOption{
+			Label:       menuLabel,
+			Value:       fmt.Sprint(value),
+			Description: description,
+		})
+	}
+	return options
+}
+
+// limitOptions returns a []discordgo.SelectMenuOption for a quota or
+// cooldown setting. 0 is shown as zeroLabel
+func limitOptions(values []int, current sql.NullInt32, unit string, zeroLabel string) (options []discordgo.SelectMenuOption) {
+	for _, value := range values {
+		description := ""
+		if current.Valid && int32(value) == current.Int32 {
+			description = "Current value"
+		}
+
+		menuLabel := fmt.Sprintf("%v %s", value, unit)
+		if value == 0 {
+			menuLabel = zeroLabel
+		}
+
+		options = append(options, discordgo.SelectMenuOption{
+			Label:       menuLabel,
+			Value:       fmt.Sprint(value),
+			Description: description,
+		})
+	}
+	return options
+}
+
+// settingsPage returns the settings page that has the given setting (column name)
+func (bot *ArchiverBot) settingsPage(setting string, sc ServerConfig) *discordgo.InteractionResponseData {
+	switch setting {
+	case "capture_outlinks", "capture_screenshot", "skip_if_archived_within", "js_delay", "reader_mode":
+		return bot.CaptureSettingsIntegrationResponse(sc)
+	case "snapshots_per_user_hour", "snapshots_per_server_day", "snapshot_cooldown":
+		return bot.LimitsSettingsIntegrationResponse(sc)
+	}
+	return bot.SettingsIntegrationResponse(sc)
+}
+
 // SettingsIntegrationResponse returns server settings in a *discordgo.InteractionResponseData
 func (bot *ArchiverBot) SettingsIntegrationResponse(sc ServerConfig) *discordgo.InteractionResponseData {
 	return &discordgo.InteractionResponseData{
@@ -68,6 +148,14 @@ func (bot *ArchiverBot) SettingsIntegrationResponse(sc ServerConfig) *discordgo.
 						Label:    getTagValue(sc, "AlwaysArchiveFirst", "pretty"),
 						Style:    globals.ButtonStyle[sc.AlwaysArchiveFirst.Valid && sc.AlwaysArchiveFirst.Bool],
 						CustomID: globals.AlwaysArchiveFirst},
+					discordgo.Button{
+						Label:    getTagValue(sc, "ShowMementos", "pretty"),
+						Style:    globals.ButtonStyle[sc.ShowMementos.Valid && sc.ShowMementos.Bool],
+						CustomID: globals.ShowMementos},
+					discordgo.Button{
+						Label:    "Capture settings ▶",
+						Style:    discordgo.SuccessButton,
+						CustomID: globals.SettingsCapture},
 				},
 			},
 			discordgo.ActionsRow{
@@ -110,6 +198,112 @@ func (bot *ArchiverBot) SettingsIntegrationResponse(sc ServerConfig) *discordgo.
 	}
 }
 
+// CaptureSettingsIntegrationResponse returns server settings for taking new
+// snapshots in a *discordgo.InteractionResponseData
+func (bot *ArchiverBot) CaptureSettingsIntegrationResponse(sc ServerConfig) *discordgo.InteractionResponseData {
+	return &discordgo.InteractionResponseData{
+		Flags: discordgo.MessageFlagsEphemeral,
+		Components: []discordgo.MessageComponent{
+			discordgo.ActionsRow{
+				Components: []discordgo.MessageComponent{
+					discordgo.Button{
+						Label:    "◀ General settings",
+						Style:    discordgo.SuccessButton,
+						CustomID: globals.SettingsGeneral},
+					discordgo.Button{
+						Label:    getTagValue(sc, "CaptureOutlinks", "pretty"),
+						Style:    globals.ButtonStyle[sc.CaptureOutlinks.Valid && sc.CaptureOutlinks.Bool],
+						CustomID: globals.CaptureOutlinks},
+					discordgo.Button{
+						Label:    getTagValue(sc, "CaptureScreenshot", "pretty"),
+						Style:    globals.ButtonStyle[sc.CaptureScreenshot.Valid && sc.CaptureScreenshot.Bool],
+						CustomID: globals.CaptureScreenshot},
+					discordgo.Button{
+						Label:    getTagValue(sc, "ReaderMode", "pretty"),
+						Style:    globals.ButtonStyle[sc.ReaderMode.Valid && sc.ReaderMode.Bool],
+						CustomID: globals.ReaderMode},
+					discordgo.Button{
+						Label:    "Limits ▶",
+						Style:    discordgo.SuccessButton,
+						CustomID: globals.SettingsLimits},
+				},
+			},
+			discordgo.ActionsRow{
+				Components: []discordgo.MessageComponent{
+					discordgo.SelectMenu{
+						Placeholder: getTagValue(sc, "SkipIfArchivedWithin", "pretty"),
+						CustomID:    globals.SkipIfArchivedWithin,
+						Options:     skipIfArchivedWithinOptions(sc),
+					},
+				},
+			},
+			discordgo.ActionsRow{
+				Components: []discordgo.MessageComponent{
+					discordgo.SelectMenu{
+						Placeholder: getTagValue(sc, "JSDelay", "pretty"),
+						CustomID:    globals.JSDelay,
+						Options:     jsDelayOptions(sc),
+					},
+				},
+			},
+		},
+	}
+}
+
+// LimitsSettingsIntegrationResponse returns server settings for how many
+// new snapshots can be taken in a *discordgo.InteractionResponseData
+func (bot *ArchiverBot) LimitsSettingsIntegrationResponse(sc ServerConfig) *discordgo.InteractionResponseData {
+	return &discordgo.InteractionResponseData{
+		Flags: discordgo.MessageFlagsEphemeral,
+		// Clears the embed from the channels page
+		Embeds: []*discordgo.MessageEmbed{},
+		Components: []discordgo.MessageComponent{
+			discordgo.ActionsRow{
+				Components: []discordgo.MessageComponent{
+					discordgo.Button{
+						Label:    "◀ Capture settings",
+						Style:    discordgo.SuccessButton,
+						CustomID: globals.SettingsCapture},
+					discordgo.Button{
+						Label:    "Channels ▶",
+						Style:    discordgo.SuccessButton,
+						CustomID: globals.SettingsChannels},
+				},
+			},
+			discordgo.ActionsRow{
+				Components: []discordgo.MessageComponent{
+					discordgo.SelectMenu{
+						Placeholder: getTagValue(sc, "SnapshotsPerUserHour", "pretty"),
+						CustomID:    globals.SnapshotsPerUserHour,
+						Options: limitOptions(globals.AllowedSnapshotsPerUserHourValues, sc.SnapshotsPerUserHour,
+							"per person per hour", "No limit per person"),
+					},
+				},
+			},
+			discordgo.ActionsRow{
+				Components: []discordgo.MessageComponent{
+					discordgo.SelectMenu{
+						Placeholder: getTagValue(sc, "SnapshotsPerServerDay", "pretty"),
+						CustomID:    globals.SnapshotsPerServerDay,
+						Options: limitOptions(globals.AllowedSnapshotsPerServerDayValues, sc.SnapshotsPerServerDay,
+							"per day for the server", "No limit for the server"),
+					},
+				},
+			},
+			discordgo.ActionsRow{
+				Components: []discordgo.MessageComponent{
+					discordgo.SelectMenu{
+						Placeholder: getTagValue(sc, "SnapshotCooldown", "pretty"),
+						CustomID:    globals.SnapshotCooldown,
+						Options: limitOptions(globals.AllowedSnapshotCooldownValues, sc.SnapshotCooldown,
+							"seconds between snapshots", "No cooldown"),
+					},
+				},
+			},
+		},
+	}
+}
+
 // settingsFailureIntegrationResponse returns a *discordgo.InteractionResponseData
 // stating that a failure to update settings has occured
 func (bot *ArchiverBot) settingsFailureIntegrationResponse() *discordgo.InteractionResponseData {
@@ -137,3 +331,77 @@ func (bot *ArchiverBot) settingsDMFailureIntegrationResponse() *discordgo.Intera
 		},
 	}
 }
+
+// invalidDateReply returns a message explaining the date formats that are
+// understood when asking for a snapshot from a point in time
+func (bot *ArchiverBot) invalidDateReply() []*discordgo.MessageSend {
+	return []*discordgo.MessageSend{
+		{
+			Embeds: []*discordgo.MessageEmbed{
+				{
+					Title:       "Unable to understand that date",
+					Description: globals.DateFormatHelpText,
+					Color:       globals.BrightRed,
+				},
+			},
+		},
+	}
+}
+
+// noUrlsReply returns a message saying there were no links to archive
+func (bot *ArchiverBot) noUrlsReply() []*discordgo.MessageSend {
+	return []*discordgo.MessageSend{
+		{
+			Embeds: []*discordgo.MessageEmbed{
+				{
+					Title:       "No URL found",
+					Description: "There weren't any links to archive.",
+					Color:       globals.BrightRed,
+				},
+			},
+		},
+	}
+}
+
+// archiveFailedEmbed returns an embed saying why a URL couldn't be archived
+func archiveFailedEmbed(result archiveResult) *discordgo.MessageEmbed {
+	reason := result.Reason
+	// Embed descriptions can only be 4096 characters long
+	if len(reason) > 4000 {
+		reason = reason[:4000] + "..."
+	}
+	return &discordgo.MessageEmbed{
+		Title:       "❌ Unable to Archive",
+		URL:         result.URL,
+		Description: reason,
+		Color:       globals.BrightRed,
+		Fields: []*discordgo.MessageEmbedField{{
+			Name:  "URL",
+			Value: result.URL,
+		}},
+	}
+}
+
+// archiveDateModal returns a modal asking for the date to look up snapshots
+// from, for the links in the message with messageID
+func archiveDateModal(messageID string) *discordgo.InteractionResponseData {
+	return &discordgo.InteractionResponseData{
+		CustomID: globals.ArchiveDateModal + globals.CustomIDSeparator + messageID,
+		Title:    "Get snapshot from date",
+		Components: []discordgo.MessageComponent{
+			discordgo.ActionsRow{
+				Components: []discordgo.MessageComponent{
+					discordgo.TextInput{
+						CustomID:    globals.DateOption,
+						Label:       "Date",
+						Style:       discordgo.TextInputShort,
+						Placeholder: "2006-01-02 or 2006-01-02 15:04",
+						Required:    true,
+						MinLength:   8,
+						MaxLength:   16,
+					},
+				},
+			},
+		},
+	}
+}
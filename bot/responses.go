@@ -1,7 +1,9 @@
 package bot
 
 import (
+	"database/sql"
 	"fmt"
+	"path/filepath"
 	"regexp"
 	"strings"
 	"time"
@@ -22,12 +24,11 @@ const (
 	waybackPrefix        string = "http(s)?://web.archive.org"
 )
 
-// buildMessageResponse takes a Discord session an original message and
-// calls go-archiver with a []string of URLs parsed from the message.
-// It then returns a slice of *discordgo.MessageSend with the resulting
-// archived URLs.
-func (bot *ArchiverBot) buildMessageResponse(m *discordgo.Message, newSnapshot bool) (
-	messagesToSend []*discordgo.MessageSend, errs []error) {
+// messageArchiveJob takes an original message and returns an ArchiveJob
+// for the URLs in it, which replies in the same channel. If there's
+// nothing to archive, messages to send right away are returned instead
+func (bot *ArchiverBot) messageArchiveJob(m *discordgo.Message, newSnapshot bool) (
+	job *ArchiveJob, messagesToSend []*discordgo.MessageSend, errs []error) {
 
 	// If true, this is a DM
 	if m.GuildID == "" {
@@ -36,19 +37,13 @@ func (bot *ArchiverBot) buildMessageResponse(m *discordgo.Message, newSnapshot b
 				{Description: "Use `/archive` or the `Get snapshot` menu item on the message instead of adding a reaction."},
 			},
 		})
-		return messagesToSend, errs
+		return job, messagesToSend, errs
 	}
 
 	sc := bot.getServerConfig(m.GuildID)
 	if sc.ArchiveEnabled.Valid && !sc.ArchiveEnabled.Bool {
 		log.Info("URLs were not archived because automatic archive is not enabled")
-		return messagesToSend, errs
-	}
-
-	// Do a lookup for the full guild object
-	guild, gErr := bot.DG.Guild(m.GuildID)
-	if gErr != nil {
-		return messagesToSend, []error{fmt.Errorf("unable to look up server by id: %v", m.GuildID)}
+		return job, messagesToSend, errs
 	}
 
 	var messageUrls []string
@@ -60,7 +55,7 @@ func (bot *ArchiverBot) buildMessageResponse(m *discordgo.Message, newSnapshot b
 		message, err := bot.DG.ChannelMessage(m.ChannelID, m.ID)
 		if err != nil {
 
-			return messagesToSend, []error{fmt.Errorf("unable to look up message by id: %v", m.ID)}
+			return job, messagesToSend, []error{fmt.Errorf("unable to look up message by id: %v", m.ID)}
 		}
 		previousMessageUrl = message.Content
 
@@ -76,7 +71,7 @@ func (bot *ArchiverBot) buildMessageResponse(m *discordgo.Message, newSnapshot b
 			if match {
 				log.Error("failed to get original URL from previous archive.org link")
 
-				return messagesToSend, errs
+				return job, messagesToSend, errs
 			}
 			// The suffix turned out to be a real URL
 			messageUrls = []string{originalUrl}
@@ -100,77 +95,50 @@ func (bot *ArchiverBot) buildMessageResponse(m *discordgo.Message, newSnapshot b
 		}
 	}
 
-	archives, errs := bot.populateArchiveEventCache(messageUrls, newSnapshot, *guild)
-	for _, err := range errs {
-		if err != nil {
-			log.Errorf("error populating archive cache: %s", err)
-		}
-	}
-
-	archivedLinks, errs := bot.executeArchiveEventRequest(&archives, sc, newSnapshot)
-	for _, err := range errs {
-		if err != nil {
-			log.Errorf("error populating archive cache: %s", err)
-			archivedLinks = append(archivedLinks, fmt.Sprintf("Error: %+v", err))
-		}
-	}
-
-	if len(archivedLinks) < len(messageUrls) {
-		log.Errorf("did not receive the same number of archived links as submitted URLs")
-		if len(archivedLinks) == 0 {
-			log.Errorf("did not receive any Archive.org links")
-			archivedLinks = []string{"I was unable to get any Wayback Machine URLs. " +
-				"Most of the time, this is " +
-				"due to rate-limiting by Archive.org. " +
-				"Please try again"}
-		}
-	}
-
-	messagesToSend, errs = bot.buildArchiveReply(archivedLinks, messageUrls, sc, false)
-
-	// Interactions don't populate the Author field, so we add that manually
-	if m.Author == nil {
-		if m.Member != nil {
-			m.Author = &discordgo.User{
-				ID:       m.Member.User.ID,
-				Username: m.Member.User.Username,
-			}
-		} else {
-			log.Errorf("missing user information, message: %+v", m)
-			return messagesToSend, errs
-		}
-	}
-
-	for index := range archivedLinks {
-		if len(archives) == index+1 {
-			archives[index].ResponseURL = archivedLinks[index]
-		}
-	}
-
-	// Create a call to Archiver API event
-	tx := bot.DB.Create(&archives)
-
-	if tx.RowsAffected != 1 {
-		errs = append(errs, fmt.Errorf("unexpected number of rows affected inserting archive event: %v", tx.RowsAffected))
+	job = newArchiveJob(messageUrls, newSnapshot, snapshotOptions(sc))
+	job.ServerID = m.GuildID
+	job.ChannelID = m.ChannelID
+	if m.Member != nil && m.Member.User != nil {
+		job.UserID = m.Member.User.ID
+	} else if m.Author != nil {
+		job.UserID = m.Author.ID
 	}
-
-	return messagesToSend, errs
+	return job, messagesToSend, errs
 }
 
-// buildInteractionResponse takes a discordgo.InteractionCreate and
-// calls go-archiver with a []string of URLs parsed from the message.
-// It then returns a slice of *discordgo.MessageSend with the resulting
-// archived URLs.
-func (bot *ArchiverBot) buildInteractionResponse(i *discordgo.InteractionCreate, newSnapshot bool) (
-	messagesToSend []*discordgo.MessageSend, errs []error) {
+// interactionArchiveJob takes a discordgo.InteractionCreate and returns an
+// ArchiveJob for the URLs in it, which replies to the interaction. If
+// there's nothing to archive, messages to send right away are returned
+// instead
+func (bot *ArchiverBot) interactionArchiveJob(i *discordgo.InteractionCreate, newSnapshot bool) (
+	job *ArchiveJob, messagesToSend []*discordgo.MessageSend, errs []error) {
 
-	var archives []ArchiveEvent
-	commandData := i.Interaction.ApplicationCommandData()
+	var commandData discordgo.ApplicationCommandInteractionData
+	if i.Type == discordgo.InteractionApplicationCommand {
+		commandData = i.Interaction.ApplicationCommandData()
+	}
 	var messageUrls []string
+	sc := bot.getServerConfig(i.GuildID)
+	options := snapshotOptions(sc)
+	var at time.Time
 
 	// The message content is in different places depending on
 	// how the bot was called
-	if commandData.Name == globals.Archive {
+	if i.Type == discordgo.InteractionModalSubmit {
+		// The date modal has the message ID in its custom ID
+		modalData := i.ModalSubmitData()
+		_, messageID, _ := strings.Cut(modalData.CustomID, globals.CustomIDSeparator)
+		message, err := bot.DG.ChannelMessage(i.ChannelID, messageID)
+		if err != nil {
+			return job, messagesToSend, []error{fmt.Errorf("unable to look up message by id: %v", messageID)}
+		}
+		messageUrls, errs = bot.extractMessageUrls(message.Content)
+
+		at, err = parseRequestedDate(modalTextValue(modalData, globals.DateOption), sc)
+		if err != nil {
+			return job, bot.invalidDateReply(), []error{err}
+		}
+	} else if commandData.Name == globals.Archive {
 		for _, command := range commandData.Options {
 			if command.Name == globals.UrlOption {
 				messageUrls, errs = bot.extractMessageUrls(command.StringValue())
@@ -178,6 +146,26 @@ func (bot *ArchiverBot) buildInteractionResponse(i *discordgo.InteractionCreate,
 			if command.Name == globals.TakeNewSnapshotOption {
 				newSnapshot = command.BoolValue()
 			}
+			// Snapshot options override the server settings
+			if command.Name == globals.OutlinksOption {
+				options.CaptureOutlinks = command.BoolValue()
+			}
+			if command.Name == globals.ScreenshotOption {
+				options.CaptureScreenshot = command.BoolValue()
+			}
+			if command.Name == globals.SkipIfArchivedWithinOption {
+				options.SkipIfArchivedWithin = time.Duration(command.IntValue()) * time.Hour
+			}
+			if command.Name == globals.JSDelayOption {
+				options.JSDelay = time.Duration(command.IntValue()) * time.Second
+			}
+			if command.Name == globals.DateOption {
+				var err error
+				at, err = parseRequestedDate(command.StringValue(), sc)
+				if err != nil {
+					return job, bot.invalidDateReply(), []error{err}
+				}
+			}
 		}
 	} else if commandData.Name == globals.ArchiveMessage ||
 		commandData.Name == globals.ArchiveMessageNewSnapshot ||
@@ -201,57 +189,154 @@ func (bot *ArchiverBot) buildInteractionResponse(i *discordgo.InteractionCreate,
 		}
 	}
 
-	guild, err := bot.DG.Guild(i.Interaction.GuildID)
-	if err != nil {
-		guild.Name = "GuildLookupError"
+	job = newArchiveJob(messageUrls, newSnapshot, options)
+	if !at.IsZero() {
+		job.RequestedTime = sql.NullTime{Time: at, Valid: true}
 	}
-	archives, errs = bot.populateArchiveEventCache(messageUrls, false, *guild)
-	for _, err := range errs {
+	job.ServerID = i.GuildID
+	job.ChannelID = i.ChannelID
+	job.AppID = i.AppID
+	job.InteractionToken = i.Token
+	if i.Member != nil && i.Member.User != nil {
+		job.UserID = i.Member.User.ID
+	} else if i.User != nil {
+		job.UserID = i.User.ID
+	}
+	return job, messagesToSend, errs
+}
+
+// buildArchiveJobResponse archives the URLs in job and returns a slice of
+// *discordgo.MessageSend with the resulting archived URLs. If snapshots
+// are still being taken, a snapshotTracker is returned that can be run to
+// keep the reply up to date
+func (bot *ArchiverBot) buildArchiveJobResponse(job *ArchiveJob) (
+	messagesToSend []*discordgo.MessageSend, tracker *snapshotTracker, errs []error) {
+	sc := bot.getServerConfig(job.ServerID)
+	messageUrls := job.urls()
+	if len(messageUrls) == 0 {
+		return bot.noUrlsReply(), tracker, errs
+	}
+
+	// Jobs are only tried again when their reply couldn't be sent, so the
+	// URLs archived the first time are reused instead of archived again,
+	// even if some of their events can't be found
+	var archives []ArchiveEvent
+	if job.ArchiveEventUUIDs == "" {
+		var urlErrs []error
+		archives, urlErrs = bot.archiveJobUrls(job, sc)
+		errs = append(errs, urlErrs...)
+	} else {
+		var err error
+		archives, err = bot.archiveJobEvents(job)
 		if err != nil {
-			log.Error("error populating archive cache: ", err)
+			log.Errorf("unable to reuse archive events: %v", err)
+			errs = append(errs, err)
 		}
 	}
 
-	sc := bot.getServerConfig(i.GuildID)
+	// Replies to interactions don't get a retry button
+	tracker = bot.newSnapshotTracker(archives, messageUrls, sc, job.InteractionToken != "")
+	messagesToSend = tracker.reply(tracker.results())
+	if len(tracker.pendingJobs()) == 0 {
+		tracker = nil
+	}
+
+	if sc.ReaderMode.Valid && sc.ReaderMode.Bool && len(messagesToSend) > 0 {
+		messagesToSend[0].Files = bot.readerModeFiles(archives, messageUrls, sc)
+	}
+	return messagesToSend, tracker, errs
+}
 
-	archivedLinks, errs := bot.executeArchiveEventRequest(&archives, sc, newSnapshot)
+// archiveJobUrls archives the URLs in job and saves the archive events
+func (bot *ArchiverBot) archiveJobUrls(job *ArchiveJob, sc ServerConfig) (archives []ArchiveEvent, errs []error) {
+	messageUrls := job.urls()
+	req := job.request()
+
+	guild, err := bot.DG.Guild(job.ServerID)
+	if err != nil {
+		guild = &discordgo.Guild{ID: job.ServerID, Name: "GuildLookupError"}
+	}
+	// Cached snapshots are the latest ones, so they're skipped when asking
+	// for a point in time
+	archives, errs = bot.populateArchiveEventCache(messageUrls, job.NewSnapshot || !req.At.IsZero(), *guild)
 	for _, err := range errs {
 		if err != nil {
 			log.Error("error populating archive cache: ", err)
-			archivedLinks = append(archivedLinks, fmt.Sprintf("Error: %+v", err))
-		}
-	}
-
-	if len(archivedLinks) < len(messageUrls) {
-		log.Errorf("did not receive the same number of archived links as submitted URLs")
-		if len(archivedLinks) == 0 {
-			log.Errorf("did not receive any Archive.org links")
-			archivedLinks = []string{"I was unable to get any Wayback Machine URLs. " +
-				"Most of the time, this is " +
-				"due to rate-limiting by Archive.org. " +
-				"Please try again"}
 		}
 	}
 
-	messagesToSend, errs = bot.buildArchiveReply(archivedLinks, messageUrls, sc, true)
-
+	_, errs = bot.executeArchiveEventRequest(&archives, sc, job.NewSnapshot, req)
 	for _, err := range errs {
 		if err != nil {
-			log.Error("error building archive reply: ", err)
+			log.Error("error archiving url: ", err)
 		}
 	}
 
+	if err := bot.saveArchiveJobEvents(job, archives, true); err != nil {
+		errs = append(errs, err)
+	}
+	return archives, errs
+}
+
+// saveArchiveJobEvents saves the archive events made for job. If record is
+// true, they're recorded on the job so they aren't archived again
+func (bot *ArchiverBot) saveArchiveJobEvents(job *ArchiveJob, archives []ArchiveEvent, record bool) error {
 	// Don't create an event if there were no archives
-	if len(archives) > 0 {
-		// Create a call to Archiver API event
-		tx := bot.DB.Create(&archives)
+	if len(archives) == 0 {
+		return nil
+	}
+	// Create a call to Archiver API event
+	tx := bot.DB.Create(&archives)
+	if tx.RowsAffected != int64(len(archives)) {
+		return fmt.Errorf("unexpected number of rows affected inserting archive event: %v", tx.RowsAffected)
+	}
+	if !record {
+		return nil
+	}
 
-		if tx.RowsAffected != 1 {
-			errs = append(errs, fmt.Errorf("unexpected number of rows affected inserting archive event: %v", tx.RowsAffected))
-		}
+	var uuids []string
+	for _, archive := range archives {
+		uuids = append(uuids, archive.UUID)
+	}
+	job.ArchiveEventUUIDs = strings.Join(uuids, "\n")
+	if job.ID == 0 {
+		return nil
+	}
+	tx = bot.DB.Model(&ArchiveJob{}).Where("id = ?", job.ID).
+		Updates(&ArchiveJob{ArchiveEventUUIDs: job.ArchiveEventUUIDs})
+	if tx.Error != nil {
+		return fmt.Errorf("unable to record archive events for archive job %d: %w", job.ID, tx.Error)
+	}
+	return nil
+}
+
+// archiveJobEvents returns the archive events saved by an earlier attempt
+// at job. If some of them can't be found, the rest are returned with an
+// error
+func (bot *ArchiverBot) archiveJobEvents(job *ArchiveJob) (archives []ArchiveEvent, err error) {
+	uuids := strings.Fields(job.ArchiveEventUUIDs)
+	if len(uuids) == 0 {
+		return nil, nil
+	}
+	var found []ArchiveEvent
+	if tx := bot.DB.Where("uuid IN ?", uuids).Find(&found); tx.Error != nil {
+		return nil, fmt.Errorf("unable to look up archive events for archive job %d: %w", job.ID, tx.Error)
 	}
 
-	return messagesToSend, errs
+	// Replies list archives in the order they were made
+	byUUID := map[string]ArchiveEvent{}
+	for _, archive := range found {
+		byUUID[archive.UUID] = archive
+	}
+	for _, uuid := range uuids {
+		if archive, ok := byUUID[uuid]; ok {
+			archives = append(archives, archive)
+		}
+	}
+	if len(archives) != len(uuids) {
+		return archives, fmt.Errorf("archive job %d has %d archive events, expected %d", job.ID, len(archives), len(uuids))
+	}
+	return archives, nil
 }
 
 // extractMessageUrls takes a string and returns a slice of URLs parsed from the string
@@ -267,7 +352,8 @@ func (bot *ArchiverBot) extractMessageUrls(message string) (messageUrls []string
 	return messageUrls, errs
 }
 
-// populateArchiveCache takes an slice of messageUrls and returns a slice of ArchiveEvents
+// populateArchiveCache takes an slice of messageUrls and returns a slice of
+// ArchiveEvents, one for each configured provider for each URL
 func (bot *ArchiverBot) populateArchiveEventCache(messageUrls []string, newSnapshot bool, guild discordgo.Guild) (archives []ArchiveEvent, errs []error) {
 	// This UUID will be used to tie together the ArchiveEventEvent,
 	// the archiveRequestUrls and the archiveResponseUrls
@@ -279,208 +365,481 @@ func (bot *ArchiverBot) populateArchiveEventCache(messageUrls []string, newSnaps
 			log.Error("unable to get domain name for url: ", url)
 		}
 
-		// See if there is a response URL for a given request URL in the database
-		cachedArchiveEvents := []ArchiveEvent{}
-		bot.DB.Model(&ArchiveEvent{}).Where(&ArchiveEvent{RequestURL: url, Cached: false}).Find(&cachedArchiveEvents)
-		var responseUrl, responseDomainName string
+		for _, p := range bot.Providers {
+			// Archive events from before providers were recorded
+			// came from the Wayback Machine
+			providerNames := []string{p.Name()}
+			if p.Name() == waybackProviderName {
+				providerNames = append(providerNames, "")
+			}
 
-		// If we have a response, create a new ArchiveEvent with it,
-		// marking it as cached
-		for _, cachedArchiveEvent := range cachedArchiveEvents {
-			if cachedArchiveEvent.ResponseURL != "" && cachedArchiveEvent.ResponseDomainName != "" {
-				responseUrl = cachedArchiveEvent.ResponseURL
-				responseDomainName = cachedArchiveEvent.ResponseDomainName
+			// See if there is a response URL for a given request URL in the database
+			cachedArchiveEvents := []ArchiveEvent{}
+			// Snapshots from a requested point in time aren't the latest,
+			// so they're never reused
+			bot.DB.Model(&ArchiveEvent{}).Where(&ArchiveEvent{RequestURL: url, Cached: false}).
+				Where("provider IN ?", providerNames).Where("requested_time IS NULL").Find(&cachedArchiveEvents)
+			var responseUrl, responseDomainName, localCapturePath string
+
+			// If we have a response, create a new ArchiveEvent with it,
+			// marking it as cached
+			for _, cachedArchiveEvent := range cachedArchiveEvents {
+				if cachedArchiveEvent.ResponseURL != "" && cachedArchiveEvent.ResponseDomainName != "" {
+					responseUrl = cachedArchiveEvent.ResponseURL
+					responseDomainName = cachedArchiveEvent.ResponseDomainName
+					localCapturePath = cachedArchiveEvent.LocalCapturePath
+				}
 			}
-		}
 
-		if responseUrl != "" && responseDomainName != "" && !newSnapshot {
-			log.Debug("url was already cached: ", url)
-			// We have already archived this URL, so save the response
-			archives = append(archives, ArchiveEvent{
-				UUID:                  uuid.New().String(),
-				ArchiveEventEventUUID: archiveEventUUID,
-				ServerID:              guild.ID,
-				ServerName:            guild.Name,
-				RequestURL:            url,
-				RequestDomainName:     domainName,
-				ResponseURL:           responseUrl,
-				ResponseDomainName:    responseDomainName,
-				Cached:                true,
-			})
-		} else {
-			// We have not already archived this URL, so build an object
-			// for doing so
-			log.Debug("url was not cached: ", url)
-			archives = append(archives, ArchiveEvent{
-				UUID:                  uuid.New().String(),
-				ArchiveEventEventUUID: archiveEventUUID,
-				ServerID:              guild.ID,
-				ServerName:            guild.Name,
-				RequestURL:            url,
-				RequestDomainName:     domainName,
-				Cached:                false,
-			})
+			if responseUrl != "" && responseDomainName != "" && !newSnapshot {
+				log.Debugf("url was already cached by %s: %s", p.Name(), url)
+				// We have already archived this URL, so save the response
+				archives = append(archives, ArchiveEvent{
+					UUID:                  uuid.New().String(),
+					ArchiveEventEventUUID: archiveEventUUID,
+					ServerID:              guild.ID,
+					ServerName:            guild.Name,
+					RequestURL:            url,
+					RequestDomainName:     domainName,
+					ResponseURL:           responseUrl,
+					ResponseDomainName:    responseDomainName,
+					LocalCapturePath:      localCapturePath,
+					Provider:              p.Name(),
+					Cached:                true,
+				})
+			} else {
+				// We have not already archived this URL, so build an object
+				// for doing so
+				log.Debugf("url was not cached by %s: %s", p.Name(), url)
+				archives = append(archives, ArchiveEvent{
+					UUID:                  uuid.New().String(),
+					ArchiveEventEventUUID: archiveEventUUID,
+					ServerID:              guild.ID,
+					ServerName:            guild.Name,
+					RequestURL:            url,
+					RequestDomainName:     domainName,
+					Provider:              p.Name(),
+					Cached:                false,
+				})
+			}
 		}
 	}
 
 	return archives, errs
 }
 
-// executeArchiveRequest takes a slice of ArchiveEvents and returns a slice of strings of successfully archived links
-func (bot *ArchiverBot) executeArchiveEventRequest(archiveEvents *[]ArchiveEvent, sc ServerConfig, newSnapshot bool) (archivedLinks []string, errs []error) {
+// executeArchiveRequest takes a slice of ArchiveEvents and returns the
+// result for each requested URL, along with the errors from providers. The
+// link for each URL comes from the first provider (in configured order)
+// that returned one. Providers that take snapshots in the background leave
+// a job ID on the ArchiveEvent instead, and ones that fail leave the
+// error. If local capture is enabled, pages are also saved to disk
+// base has the settings used for every URL, URL and RetryAttempts are
+// filled in for each one
+func (bot *ArchiverBot) executeArchiveEventRequest(archiveEvents *[]ArchiveEvent, sc ServerConfig, newSnapshot bool,
+	base ArchiveRequest) (results []archiveResult, errs []error) {
+	var requestUrls []string
+	firstEvents := map[string]int{}
+	found := map[string]bool{}
+	requested := map[string]bool{}
+
+	var toRequest []int
 	for i, archive := range *archiveEvents {
-		if archive.ResponseURL == "" || newSnapshot {
-			var url string
-			log.Debug("need to call archive.org api for ", archive.RequestURL)
+		if _, seen := firstEvents[archive.RequestURL]; !seen {
+			requestUrls = append(requestUrls, archive.RequestURL)
+			firstEvents[archive.RequestURL] = i
+		}
 
-			// This will always try to archive the page if not found
-			url, err := goarchive.GetLatestURL(archive.RequestURL, uint(sc.RetryAttempts.Int32),
-				sc.AlwaysArchiveFirst.Bool || newSnapshot, bot.Config.Cookie)
+		if !base.At.IsZero() {
+			(*archiveEvents)[i].RequestedTime = sql.NullTime{Time: base.At, Valid: true}
+		}
+
+		if archive.ResponseURL != "" && !newSnapshot && base.At.IsZero() {
+			found[archive.RequestURL] = true
+			continue
+		}
+
+		requested[archive.RequestURL] = true
+		toRequest = append(toRequest, i)
+	}
+
+	// Lookups that miss take a new snapshot instead, which is charged once
+	// for each URL however many providers take one
+	charges := map[string]func() error{}
+	for _, requestUrl := range requestUrls {
+		charges[requestUrl] = bot.snapshotCharger(sc, base)
+	}
+
+	// Every provider is asked about every URL at the same time, up to the
+	// concurrency limit. Each call only touches its own ArchiveEvent
+	requestErrs := make([]error, len(toRequest))
+	forEachConcurrently(len(toRequest), bot.archiveConcurrency(), func(n int) {
+		i := toRequest[n]
+		archive := &(*archiveEvents)[i]
+		p := bot.getProvider(archive.Provider)
+		if p == nil {
+			log.Errorf("archive provider %s is not configured", archive.Provider)
+			return
+		}
+		log.Debugf("need to call %s for %s", p.Name(), archive.RequestURL)
+
+		req := base
+		req.URL = archive.RequestURL
+		req.RetryAttempts = uint(sc.RetryAttempts.Int32)
+		req.chargeSnapshot = charges[archive.RequestURL]
+		// This will always try to archive the page if not found. If the
+		// same request is already being made, for this server or another
+		// one, its results are used instead
+		takeSnapshot := sc.AlwaysArchiveFirst.Bool || newSnapshot
+		// Bulk work waits its turn before joining a shared request, so
+		// interactive requests sharing it never wait on bulk pacing
+		if req.Bulk {
+			waitForBulkTurn()
+		}
+		url, jobID, shared, err := archiveRequests.Do(archiveRequestKey(p, req, takeSnapshot), func() (string, string, error) {
+			return bot.requestArchive(p, req, takeSnapshot)
+		})
+		if shared {
+			log.Debugf("used in-progress %s request for %s", p.Name(), archive.RequestURL)
+		}
+		if err != nil {
+			archive.Error = userErrorText(err)
+			requestErrs[n] = err
+			return
+		}
+
+		if jobID != "" {
+			archive.SnapshotJobID = jobID
+		} else if url != "" {
+			domainName, err := getDomainName(url)
 			if err != nil {
-				return archivedLinks, []error{err}
+				log.Errorf("unable to get domain name for url: %v", url)
 			}
+			archive.ResponseDomainName = domainName
+			archive.ResponseURL = url
+		} else {
+			log.Infof("could not get a %s url for url: %s", p.Name(), archive.RequestURL)
+		}
+	})
+	for n, i := range toRequest {
+		archive := (*archiveEvents)[i]
+		if requestErrs[n] != nil {
+			errs = append(errs, requestErrs[n])
+		}
+		if archive.SnapshotJobID != "" || archive.ResponseURL != "" {
+			found[archive.RequestURL] = true
+		}
+	}
 
-			if url != "" {
-				domainName, err := getDomainName(url)
-				if err != nil {
-					log.Errorf("unable to get domain name for url: %v", archive.ResponseURL)
-				}
-				(*archiveEvents)[i].ResponseDomainName = domainName
-				(*archiveEvents)[i].ResponseURL = url
-			} else {
-				log.Info("could not get latest archive.org url for url: ", url)
+	// Local captures are of the live page, so they don't make sense when
+	// asking for a point in time
+	if mode := bot.localCaptureMode(); mode != "" && base.At.IsZero() {
+		capturer := newLocalCapturer(bot.Config.WARCDirectory)
+		var toCapture []string
+		for _, requestUrl := range requestUrls {
+			// Cached results were already captured the first time around
+			if !requested[requestUrl] || (mode == localCaptureFallback && found[requestUrl]) {
 				continue
 			}
-			if strings.HasPrefix(url, "http://") {
-				newUrl := strings.SplitN(url, "http://", 2)[1]
-				url = "https://" + newUrl
-			}
-			archivedLinks = append(archivedLinks, url)
-		} else {
-			// We have a response URL, so add that to the links to be used
-			// in the message
-			archivedLinks = append(archivedLinks, archive.ResponseURL)
+			toCapture = append(toCapture, requestUrl)
 		}
+		forEachConcurrently(len(toCapture), bot.archiveConcurrency(), func(n int) {
+			requestUrl := toCapture[n]
+			log.Debug("capturing url locally: ", requestUrl)
+			path, err := capturer.Capture(requestUrl)
+			if err != nil {
+				log.Errorf("unable to capture url %s locally: %v", requestUrl, err)
+				return
+			}
+			(*archiveEvents)[firstEvents[requestUrl]].LocalCapturePath = path
+		})
 	}
-	return archivedLinks, errs
+
+	return archiveResults(*archiveEvents, requestUrls, nil), errs
 }
 
-// executeArchiveRequest takes a slice of archive links and returns a slice of
-// messages to send
-func (bot *ArchiverBot) buildArchiveReply(archivedLinks []string, messageUrls []string, sc ServerConfig, ephemeral bool) (messagesToSend []*discordgo.MessageSend, errs []error) {
+// buildArchiveReply takes the embed built by archiveEmbed for each
+// requested URL and its sparkline and returns a slice of messages to send.
+// Embeds that couldn't be built are nil and left out
+func buildArchiveReply(built []*discordgo.MessageEmbed, sparklines []goarchive.ArchiveOrgWaybackSparklineResponse,
+	ephemeral bool) (messagesToSend []*discordgo.MessageSend) {
 	var embeds []*discordgo.MessageEmbed
-	var components []discordgo.MessageComponent
+	var buttons []discordgo.MessageComponent
 
 	if !ephemeral {
-		components = []discordgo.MessageComponent{
-			discordgo.ActionsRow{
-				Components: []discordgo.MessageComponent{
-					discordgo.Button{
-						Label:    "Request new snapshot",
-						Style:    discordgo.PrimaryButton,
-						CustomID: globals.Retry},
-				},
-			},
+		buttons = append(buttons, discordgo.Button{
+			Label:    "Request new snapshot",
+			Style:    discordgo.PrimaryButton,
+			CustomID: globals.Retry})
+	}
+
+	for i, embed := range built {
+		if embed == nil {
+			continue
+		}
+		sparkline := sparklines[i]
+		if sparkline.FirstTs != "" && sparkline.LastTs != "" && sparkline.FirstTs != sparkline.LastTs {
+			label := "Compare oldest/newest"
+			if len(built) > 1 {
+				label = fmt.Sprintf("%s #%d", label, len(embeds)+1)
+			}
+			buttons = append(buttons, discordgo.Button{
+				Label:    label,
+				Style:    discordgo.SecondaryButton,
+				CustomID: compareSnapshotsID(len(embeds), sparkline.FirstTs, sparkline.LastTs)})
 		}
+
+		embeds = append(embeds, embed)
 	}
 
-	for i := 0; i < len(archivedLinks); i++ {
-		originalUrl := messageUrls[i]
-		link := archivedLinks[i]
+	// Messages can have 5 rows of 5 buttons
+	var components []discordgo.MessageComponent
+	for len(buttons) > 0 && len(components) < 5 {
+		row := buttons
+		if len(row) > 5 {
+			row = row[:5]
+		}
+		components = append(components, discordgo.ActionsRow{Components: row})
+		buttons = buttons[len(row):]
+	}
+
+	reply := &discordgo.MessageSend{
+		Embeds:     embeds,
+		Components: components,
+	}
+
+	messagesToSend = append(messagesToSend, reply)
+	return messagesToSend
+}
+
+// archiveEmbed returns the embed for one result in an archive reply and the
+// sparkline for the URL, or nil if the embed can't be built
+func (bot *ArchiverBot) archiveEmbed(result archiveResult, archives []ArchiveEvent, sc ServerConfig) (
+	*discordgo.MessageEmbed, goarchive.ArchiveOrgWaybackSparklineResponse) {
+	var sparkline goarchive.ArchiveOrgWaybackSparklineResponse
+	originalUrl := result.URL
+	link := result.Link
 
-		embed := discordgo.MessageEmbed{
-			Title:       "🏛️ Archive.org Snapshot",
-			Description: link,
-			Color:       globals.FrenchGray,
+	if result.Failed() {
+		return archiveFailedEmbed(result), sparkline
+	}
+
+	// The first provider with a snapshot is where the link came from,
+	// the rest are shown as alternates
+	provider := result.Provider
+	var alternates []ArchiveEvent
+	for _, archive := range archives {
+		if archive.RequestURL != originalUrl || archive.ResponseURL == "" || archive.Provider == provider {
+			continue
 		}
+		alternates = append(alternates, archive)
+	}
 
-		sparkline, err := goarchive.CheckArchiveSparkline(originalUrl)
-		if err != nil {
-			log.Errorf("unable to get sparkline for url: %v", originalUrl)
-			embed.Fields = []*discordgo.MessageEmbedField{{
-				Name:  "Details",
-				Value: "Snapshot details are not currently available, most of the time this is because the link was just archived.",
-			}}
-		} else {
-			// If there was an error, the extra fields won't be useful anyway
-			if sparkline.FirstTs != "" && sparkline.LastTs != "" && sparkline.Years != nil {
-				oldest, err := time.ParseInLocation(globals.ArchiveOrgTimestampLayout, sparkline.FirstTs, time.UTC)
-				if err != nil {
-					log.Errorf("unable to parse oldest timestamp for url: %v, timestamp: %v", originalUrl, sparkline.FirstTs)
-				}
-				newest, err := time.ParseInLocation(globals.ArchiveOrgTimestampLayout, sparkline.LastTs, time.UTC)
-				if err != nil {
-					log.Errorf("unable to parse newest timestamp for url: %v, timestamp: %v", originalUrl, sparkline.LastTs)
-				}
+	embed := discordgo.MessageEmbed{
+		Title:       "🏛️ " + bot.providerDisplayName(provider) + " Snapshot",
+		Description: link,
+		Color:       globals.FrenchGray,
+	}
 
-				snapshotCount := 0
-				// Each year (_) has an array of 12 integers (correspondes to months)
-				// of how many snapshots there are for that month
-				for _, v := range sparkline.Years {
-					for _, monthCount := range v {
-						snapshotCount = snapshotCount + monthCount
-					}
+	sparkline, err := checkSparkline(originalUrl)
+	if err != nil {
+		log.Errorf("unable to get sparkline for url: %v", originalUrl)
+		embed.Fields = []*discordgo.MessageEmbedField{{
+			Name:  "Details",
+			Value: "Snapshot details are not currently available, most of the time this is because the link was just archived.",
+		}}
+	} else {
+		// If there was an error, the extra fields won't be useful anyway
+		if sparkline.FirstTs != "" && sparkline.LastTs != "" && sparkline.Years != nil {
+			oldest, err := time.ParseInLocation(globals.ArchiveOrgTimestampLayout, sparkline.FirstTs, time.UTC)
+			if err != nil {
+				log.Errorf("unable to parse oldest timestamp for url: %v, timestamp: %v", originalUrl, sparkline.FirstTs)
+			}
+			newest, err := time.ParseInLocation(globals.ArchiveOrgTimestampLayout, sparkline.LastTs, time.UTC)
+			if err != nil {
+				log.Errorf("unable to parse newest timestamp for url: %v, timestamp: %v", originalUrl, sparkline.LastTs)
+			}
+
+			snapshotCount := 0
+			// Each year (_) has an array of 12 integers (correspondes to months)
+			// of how many snapshots there are for that month
+			for _, v := range sparkline.Years {
+				for _, monthCount := range v {
+					snapshotCount = snapshotCount + monthCount
 				}
+			}
 
-				if link != "" {
-					if sc.ShowDetails.Valid && sc.ShowDetails.Bool {
-						if !sc.UTCSign.Valid {
-							log.Errorf("Invalid UTC setting for %s(%s)", sc.DiscordId, sc.Name)
-							continue
-						}
-						if err != nil {
-							log.Errorf("Unable to load timezone UTC%s%v", sc.UTCSign.String, sc.UTCOffset)
-							continue
-						}
-						sign := map[string]int{
-							"-": -1,
-							"+": 1,
-						}
-						location := time.FixedZone("UTC", sign[sc.UTCSign.String]*int(sc.UTCOffset.Int32)*60*60)
-						embed.Fields = []*discordgo.MessageEmbedField{
-							{
-								Name: "Oldest Archived Copy",
-								Value: fmt.Sprintf("[%s](%s/%s/%s)",
-									// oldest.In(location).Format(time.RFC1123), archiveRoot, sparkline.FirstTs, originalUrl),
-									oldest.In(location).Format(time.RFC1123Z), archiveRoot, sparkline.FirstTs, originalUrl),
-								Inline: true,
-							},
-							{
-								Name: "Newest Archived Copy",
-								Value: fmt.Sprintf("[%s](%s/%s/%s)",
-									newest.In(location).Format(time.RFC1123Z), archiveRoot, sparkline.LastTs, originalUrl),
-								Inline: true,
-							},
-							{
-								Name: "Total Number of Snapshots",
-								Value: fmt.Sprintf("[%s](%s/%s0000000000*/%s)",
-									fmt.Sprint(snapshotCount), archiveRoot, fmt.Sprint(time.Now().Year()), originalUrl),
-								Inline: true,
-							},
-							{
-								Name:   "Alternate links",
-								Value:  fmt.Sprintf("[%s](%s/%s)", "archive.is", archivePhTimeGateAPI, originalUrl),
-								Inline: true,
-							},
-						}
+			if link != "" {
+				if sc.ShowDetails.Valid && sc.ShowDetails.Bool {
+					if !sc.UTCSign.Valid {
+						log.Errorf("Invalid UTC setting for %s(%s)", sc.DiscordId, sc.Name)
+						return nil, sparkline
+					}
+					if err != nil {
+						log.Errorf("Unable to load timezone UTC%s%v", sc.UTCSign.String, sc.UTCOffset)
+						return nil, sparkline
+					}
+					sign := map[string]int{
+						"-": -1,
+						"+": 1,
+					}
+					location := time.FixedZone("UTC", sign[sc.UTCSign.String]*int(sc.UTCOffset.Int32)*60*60)
+					embed.Fields = []*discordgo.MessageEmbedField{
+						{
+							Name: "Oldest Archived Copy",
+							Value: fmt.Sprintf("[%s](%s/%s/%s)",
+								// oldest.In(location).Format(time.RFC1123), archiveRoot, sparkline.FirstTs, originalUrl),
+								oldest.In(location).Format(time.RFC1123Z), archiveRoot, sparkline.FirstTs, originalUrl),
+							Inline: true,
+						},
+						{
+							Name: "Newest Archived Copy",
+							Value: fmt.Sprintf("[%s](%s/%s/%s)",
+								newest.In(location).Format(time.RFC1123Z), archiveRoot, sparkline.LastTs, originalUrl),
+							Inline: true,
+						},
+						{
+							Name: "Total Number of Snapshots",
+							Value: fmt.Sprintf("[%s](%s/%s0000000000*/%s)",
+								fmt.Sprint(snapshotCount), archiveRoot, fmt.Sprint(time.Now().Year()), originalUrl),
+							Inline: true,
+						},
+					}
+					if !hasProvider(archives, originalUrl, archiveTodayProviderName) {
+						embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+							Name:   "Alternate links",
+							Value:  fmt.Sprintf("[%s](%s/%s)", "archive.is", archivePhTimeGateAPI, originalUrl),
+							Inline: true,
+						})
 					}
 				}
 			}
 		}
-		embed.Footer = &discordgo.MessageEmbedFooter{
-			Text: "⚙️ Customize this message with /settings",
+	}
+
+	for _, alternate := range alternates {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:   bot.providerDisplayName(alternate.Provider) + " Snapshot",
+			Value:  alternate.ResponseURL,
+			Inline: false,
+		})
+	}
+
+	for _, archive := range archives {
+		if archive.RequestURL == originalUrl && archive.RequestedTime.Valid {
+			embed.Fields = append(embed.Fields, bot.requestedTimeFields(archive, provider, archives, sc)...)
+			break
 		}
+	}
 
-		embed.URL = originalUrl
+	for _, archive := range archives {
+		if archive.RequestURL == originalUrl && archive.LocalCapturePath != "" {
+			embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+				Name:  "Local Copy",
+				Value: "`" + filepath.Base(archive.LocalCapturePath) + "`",
+			})
+			break
+		}
+	}
 
-		embeds = append(embeds, &embed)
+	if sc.ShowMementos.Valid && sc.ShowMementos.Bool {
+		if field := bot.mementoField(originalUrl, sc, requestedTime(archives, originalUrl)); field != nil {
+			embed.Fields = append(embed.Fields, field)
+		}
+	}
+	embed.Footer = &discordgo.MessageEmbedFooter{
+		Text: "⚙️ Customize this message with /settings",
 	}
 
-	reply := &discordgo.MessageSend{
-		Embeds:     embeds,
-		Components: components,
+	embed.URL = originalUrl
+
+	return &embed, sparkline
+}
+
+// hasProvider returns whether any of the archives for requestUrl are from
+// the named provider and have a snapshot
+func hasProvider(archives []ArchiveEvent, requestUrl string, provider string) bool {
+	for _, archive := range archives {
+		if archive.RequestURL == requestUrl && archive.Provider == provider && archive.ResponseURL != "" {
+			return true
+		}
 	}
+	return false
+}
 
-	messagesToSend = append(messagesToSend, reply)
-	return messagesToSend, errs
+// requestedTime returns the point in time snapshots of requestUrl were
+// asked for, or now if they weren't
+func requestedTime(archives []ArchiveEvent, requestUrl string) time.Time {
+	for _, archive := range archives {
+		if archive.RequestURL == requestUrl && archive.RequestedTime.Valid {
+			return archive.RequestedTime.Time
+		}
+	}
+	return time.Now()
+}
+
+// requestedTimeFields returns embed fields with the date that was asked for
+// and when the snapshot from provider was actually taken
+func (bot *ArchiverBot) requestedTimeFields(archive ArchiveEvent, provider string, archives []ArchiveEvent,
+	sc ServerConfig) []*discordgo.MessageEmbedField {
+	captured := "Unknown"
+	for _, a := range archives {
+		if a.RequestURL != archive.RequestURL || a.Provider != provider || a.ResponseURL == "" {
+			continue
+		}
+		if at, ok := snapshotTime(a.ResponseURL); ok {
+			captured = at.In(serverLocation(sc)).Format(time.RFC1123Z)
+		}
+	}
+	if provider == "" {
+		captured = "No snapshot was found near this date"
+	}
+
+	return []*discordgo.MessageEmbedField{
+		{
+			Name:   "Requested Date",
+			Value:  archive.RequestedTime.Time.In(serverLocation(sc)).Format(time.RFC1123Z),
+			Inline: true,
+		},
+		{
+			Name:   "Captured",
+			Value:  captured,
+			Inline: true,
+		},
+	}
+}
+
+// mementoField returns an embed field listing the memento of originalUrl
+// closest to at from each web archive the Memento TimeMap knows about, or
+// nil if there aren't any
+func (bot *ArchiverBot) mementoField(originalUrl string, sc ServerConfig, at time.Time) *discordgo.MessageEmbedField {
+	client := newMementoClient(bot.Config.MementoTimeGateURL, bot.Config.MementoTimeMapURL)
+	mementos, err := client.BestPerArchive(originalUrl, at)
+	if err != nil {
+		log.Errorf("unable to get mementos for url: %v, err: %v", originalUrl, err)
+		return nil
+	}
+	if len(mementos) == 0 {
+		return nil
+	}
+
+	var lines []string
+	length := 0
+	for _, m := range mementos {
+		line := fmt.Sprintf("[%s](%s)", m.Archive, m.URL)
+		if !m.Datetime.IsZero() {
+			line += " " + m.Datetime.In(serverLocation(sc)).Format(time.RFC1123Z)
+		}
+		// Embed field values can only be 1024 characters long
+		if length+len(line)+1 > 1024 {
+			break
+		}
+		length += len(line) + 1
+		lines = append(lines, line)
+	}
+
+	return &discordgo.MessageEmbedField{
+		Name:  "Other Web Archives",
+		Value: strings.Join(lines, "\n"),
+	}
 }
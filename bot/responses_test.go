@@ -0,0 +1,70 @@
+package bot
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBuildArchiveJobResponseReusesEvents(t *testing.T) {
+	bot, discord := newTestBot(t)
+
+	// An earlier attempt archived both URLs but couldn't send the reply
+	archives := []ArchiveEvent{
+		{UUID: "event-1", RequestURL: "https://example.com/a", Provider: "wayback", Error: "first failure"},
+		{UUID: "event-2", RequestURL: "https://example.com/b", Provider: "wayback", Error: "second failure"},
+	}
+	if tx := bot.DB.Create(&archives); tx.Error != nil {
+		t.Fatalf("unable to save archive events: %v", tx.Error)
+	}
+
+	tests := []struct {
+		name    string
+		uuids   string
+		wantErr bool
+	}{
+		{
+			name:  "every event saved",
+			uuids: "event-1\nevent-2",
+		},
+		{
+			name:    "missing event",
+			uuids:   "event-1\nevent-2\nevent-3",
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			job := newArchiveJob([]string{"https://example.com/a", "https://example.com/b"}, false, SnapshotOptions{})
+			job.ID = 1
+			job.Attempts = 2
+			job.ArchiveEventUUIDs = test.uuids
+
+			messages, tracker, errs := bot.buildArchiveJobResponse(job)
+			if gotErr := len(errs) > 0; gotErr != test.wantErr {
+				t.Errorf("got errors %v, want errors %v", errs, test.wantErr)
+			}
+			if tracker != nil {
+				t.Errorf("got a snapshot tracker for events with no snapshot jobs")
+			}
+			if len(messages) != 1 || len(messages[0].Embeds) != 2 {
+				t.Fatalf("got messages %+v, want one message with an embed for each URL", messages)
+			}
+			for i, want := range []string{"first failure", "second failure"} {
+				if !strings.Contains(messages[0].Embeds[i].Description, want) {
+					t.Errorf("embed %d is %q, want the saved error %q", i, messages[0].Embeds[i].Description, want)
+				}
+			}
+
+			// Nothing was archived again
+			var count int64
+			bot.DB.Model(&ArchiveEvent{}).Count(&count)
+			if count != int64(len(archives)) {
+				t.Errorf("got %d archive events, want the %d saved before", count, len(archives))
+			}
+			if requests := discord.Requests(); len(requests) != 0 {
+				t.Errorf("got requests to Discord %v, want none", requests)
+			}
+		})
+	}
+}
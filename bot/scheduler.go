@@ -0,0 +1,199 @@
+package bot
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// Task types
+const (
+	taskRemoveRetryButton string = "remove_retry_button"
+)
+
+const (
+	scheduledTaskPending string = "pending"
+	scheduledTaskRunning string = "running"
+	scheduledTaskFailed  string = "failed"
+
+	// Tasks that fail are tried this many times
+	scheduledTaskMaxAttempts int = 5
+	// The scheduler checks for tasks that are due this often
+	schedulerPollInterval time.Duration = 5 * time.Second
+	// Failed tasks wait this long times the number of attempts before
+	// they're tried again
+	scheduledTaskBackoff time.Duration = time.Minute
+)
+
+// scheduledTaskHandler does the work for one type of task. payload is
+// what was passed to scheduleTask
+type scheduledTaskHandler func(payload []byte) error
+
+// scheduledTaskHandlers returns the handler for each type of task
+func (bot *ArchiverBot) scheduledTaskHandlers() map[string]scheduledTaskHandler {
+	return map[string]scheduledTaskHandler{
+		taskRemoveRetryButton: bot.removeRetryButton,
+	}
+}
+
+// scheduleTask saves a task of taskType to run at runAt. payload is saved
+// as JSON and given to the handler when it runs
+func (bot *ArchiverBot) scheduleTask(taskType string, runAt time.Time, payload interface{}) error {
+	return bot.saveScheduledTask(taskType, runAt, 0, payload)
+}
+
+// scheduleRecurringTask saves a task of taskType that runs every interval,
+// starting one interval from now. There's only ever one recurring task of
+// each type, so this can be called every time the bot starts
+func (bot *ArchiverBot) scheduleRecurringTask(taskType string, interval time.Duration, payload interface{}) error {
+	var existing int64
+	tx := bot.DB.Model(&ScheduledTask{}).Where("type = ? AND interval_seconds > 0", taskType).Count(&existing)
+	if tx.Error != nil {
+		return fmt.Errorf("unable to look up scheduled tasks: %w", tx.Error)
+	}
+	if existing > 0 {
+		return nil
+	}
+	return bot.saveScheduledTask(taskType, time.Now().Add(interval), interval, payload)
+}
+
+// saveScheduledTask saves a pending task
+func (bot *ArchiverBot) saveScheduledTask(taskType string, runAt time.Time, interval time.Duration, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("unable to encode %s task: %w", taskType, err)
+	}
+	tx := bot.DB.Create(&ScheduledTask{
+		RunAt:           runAt,
+		Type:            taskType,
+		Payload:         string(data),
+		State:           scheduledTaskPending,
+		IntervalSeconds: int32(interval.Seconds()),
+	})
+	if tx.Error != nil {
+		return fmt.Errorf("unable to save %s task: %w", taskType, tx.Error)
+	}
+	return nil
+}
+
+// StartScheduler runs scheduled tasks as they come due. Tasks that were
+// running when the bot stopped are run again
+func (bot *ArchiverBot) StartScheduler() {
+	tx := bot.DB.Model(&ScheduledTask{}).Where(&ScheduledTask{State: scheduledTaskRunning}).
+		Update("state", scheduledTaskPending)
+	if tx.Error != nil {
+		log.Errorf("unable to reset unfinished scheduled tasks: %v", tx.Error)
+	}
+	var pending int64
+	bot.DB.Model(&ScheduledTask{}).Where(&ScheduledTask{State: scheduledTaskPending}).Count(&pending)
+	log.Infof("starting scheduler, %d tasks waiting", pending)
+
+	go bot.runScheduler()
+}
+
+// runScheduler runs tasks that are due until the bot shuts down
+func (bot *ArchiverBot) runScheduler() {
+	life := bot.lifecycle()
+	handlers := bot.scheduledTaskHandlers()
+	for {
+		if !life.begin(nil) {
+			return
+		}
+		for {
+			task, ok := bot.claimScheduledTask()
+			if !ok {
+				break
+			}
+			bot.runScheduledTask(task, handlers[task.Type])
+		}
+		life.end(nil)
+
+		select {
+		case <-time.After(schedulerPollInterval):
+		case <-life.stopped:
+			return
+		}
+	}
+}
+
+// claimScheduledTask marks the task that's been due the longest as running
+// and returns it. ok is false if no tasks are due
+func (bot *ArchiverBot) claimScheduledTask() (task ScheduledTask, ok bool) {
+	for {
+		task = ScheduledTask{}
+		tx := bot.DB.Where("state = ? AND run_at <= ?", scheduledTaskPending, time.Now()).
+			Order("run_at").Limit(1).Find(&task)
+		if tx.Error != nil {
+			log.Errorf("unable to look up scheduled tasks: %v", tx.Error)
+			return task, false
+		}
+		if tx.RowsAffected == 0 {
+			return task, false
+		}
+
+		tx = bot.DB.Model(&ScheduledTask{}).Where("id = ? AND state = ?", task.ID, scheduledTaskPending).
+			Updates(map[string]interface{}{"state": scheduledTaskRunning, "attempts": gorm.Expr("attempts + 1")})
+		if tx.Error != nil {
+			log.Errorf("unable to claim scheduled task %d: %v", task.ID, tx.Error)
+			return task, false
+		}
+		if tx.RowsAffected == 1 {
+			task.State = scheduledTaskRunning
+			task.Attempts++
+			return task, true
+		}
+	}
+}
+
+// runScheduledTask runs task and records how it went. Finished tasks are
+// deleted, or scheduled again if they recur. Failed tasks are tried again
+// later until they run out of attempts
+func (bot *ArchiverBot) runScheduledTask(task ScheduledTask, handler scheduledTaskHandler) {
+	log.Debugf("running %s task %d (attempt %d)", task.Type, task.ID, task.Attempts)
+	var err error
+	if handler == nil {
+		err = fmt.Errorf("no handler for task type %s", task.Type)
+	} else {
+		err = handler([]byte(task.Payload))
+	}
+
+	var tx *gorm.DB
+	switch {
+	case err == nil && task.IntervalSeconds > 0:
+		tx = bot.DB.Model(&ScheduledTask{}).Where("id = ?", task.ID).Updates(map[string]interface{}{
+			"state":      scheduledTaskPending,
+			"attempts":   0,
+			"last_error": "",
+			"run_at":     time.Now().Add(time.Duration(task.IntervalSeconds) * time.Second),
+		})
+	case err == nil:
+		tx = bot.DB.Delete(&ScheduledTask{}, task.ID)
+	case task.Attempts < scheduledTaskMaxAttempts:
+		log.Warnf("%s task %d failed, trying again later: %v", task.Type, task.ID, err)
+		tx = bot.DB.Model(&ScheduledTask{}).Where("id = ?", task.ID).Updates(map[string]interface{}{
+			"state":      scheduledTaskPending,
+			"last_error": err.Error(),
+			"run_at":     time.Now().Add(time.Duration(task.Attempts) * scheduledTaskBackoff),
+		})
+	case task.IntervalSeconds > 0:
+		log.Errorf("%s task %d failed %d times, skipping to the next run: %v", task.Type, task.ID, task.Attempts, err)
+		tx = bot.DB.Model(&ScheduledTask{}).Where("id = ?", task.ID).Updates(map[string]interface{}{
+			"state":      scheduledTaskPending,
+			"attempts":   0,
+			"last_error": err.Error(),
+			"run_at":     time.Now().Add(time.Duration(task.IntervalSeconds) * time.Second),
+		})
+	default:
+		log.Errorf("%s task %d failed %d times, giving up: %v", task.Type, task.ID, task.Attempts, err)
+		tx = bot.DB.Model(&ScheduledTask{}).Where("id = ?", task.ID).Updates(map[string]interface{}{
+			"state":      scheduledTaskFailed,
+			"last_error": err.Error(),
+		})
+	}
+	if tx.Error != nil {
+		log.Errorf("unable to update scheduled task %d: %v", task.ID, tx.Error)
+	}
+}
@@ -0,0 +1,135 @@
+package bot
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestClaimScheduledTask(t *testing.T) {
+	bot, _ := newTestBot(t)
+	now := time.Now()
+	for _, runAt := range []time.Time{now.Add(time.Hour), now.Add(-time.Minute), now.Add(-time.Hour)} {
+		if err := bot.scheduleTask("test", runAt, nil); err != nil {
+			t.Fatalf("unable to schedule task: %v", err)
+		}
+	}
+
+	// The task that's been due the longest goes first, and tasks that
+	// aren't due yet wait
+	for _, wantID := range []uint{3, 2} {
+		task, ok := bot.claimScheduledTask()
+		if !ok || task.ID != wantID {
+			t.Fatalf("claimed task %d (%v), want task %d", task.ID, ok, wantID)
+		}
+		if task.State != scheduledTaskRunning || task.Attempts != 1 {
+			t.Errorf("claimed task is %s after %d attempts, want running after 1", task.State, task.Attempts)
+		}
+		var saved ScheduledTask
+		bot.DB.First(&saved, task.ID)
+		if saved.State != scheduledTaskRunning || saved.Attempts != 1 {
+			t.Errorf("saved task is %s after %d attempts, want running after 1", saved.State, saved.Attempts)
+		}
+	}
+	if task, ok := bot.claimScheduledTask(); ok {
+		t.Errorf("claimed task %d, want none due", task.ID)
+	}
+}
+
+func TestRunScheduledTask(t *testing.T) {
+	failing := func(payload []byte) error { return errors.New("task failed") }
+	working := func(payload []byte) error { return nil }
+
+	tests := []struct {
+		name     string
+		handler  scheduledTaskHandler
+		attempts int
+		interval int32
+		// deleted tasks are gone, the rest have the rest of the fields
+		deleted      bool
+		wantState    string
+		wantAttempts int
+		wantRunIn    time.Duration
+		wantError    string
+	}{
+		{
+			name:     "finished",
+			handler:  working,
+			attempts: 1,
+			deleted:  true,
+		},
+		{
+			name:         "recurring finished",
+			handler:      working,
+			attempts:     2,
+			interval:     3600,
+			wantState:    scheduledTaskPending,
+			wantAttempts: 0,
+			wantRunIn:    time.Hour,
+		},
+		{
+			name:         "failed",
+			handler:      failing,
+			attempts:     2,
+			wantState:    scheduledTaskPending,
+			wantAttempts: 2,
+			wantRunIn:    2 * scheduledTaskBackoff,
+			wantError:    "task failed",
+		},
+		{
+			name:         "no handler",
+			attempts:     1,
+			wantState:    scheduledTaskPending,
+			wantAttempts: 1,
+			wantRunIn:    scheduledTaskBackoff,
+			wantError:    "no handler for task type test",
+		},
+		{
+			name:         "out of attempts",
+			handler:      failing,
+			attempts:     scheduledTaskMaxAttempts,
+			wantState:    scheduledTaskFailed,
+			wantAttempts: scheduledTaskMaxAttempts,
+			wantError:    "task failed",
+		},
+		{
+			name:         "recurring out of attempts",
+			handler:      failing,
+			attempts:     scheduledTaskMaxAttempts,
+			interval:     3600,
+			wantState:    scheduledTaskPending,
+			wantAttempts: 0,
+			wantRunIn:    time.Hour,
+			wantError:    "task failed",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bot, _ := newTestBot(t)
+			task := ScheduledTask{Type: "test", State: scheduledTaskRunning, Attempts: test.attempts,
+				IntervalSeconds: test.interval, RunAt: time.Now()}
+			bot.DB.Create(&task)
+
+			bot.runScheduledTask(task, test.handler)
+
+			var saved ScheduledTask
+			tx := bot.DB.Limit(1).Find(&saved, task.ID)
+			if test.deleted {
+				if tx.RowsAffected != 0 {
+					t.Errorf("got task %+v, want it deleted", saved)
+				}
+				return
+			}
+			if saved.State != test.wantState || saved.Attempts != test.wantAttempts || saved.LastError != test.wantError {
+				t.Errorf("got %s after %d attempts with error %q, want %s after %d with %q", saved.State,
+					saved.Attempts, saved.LastError, test.wantState, test.wantAttempts, test.wantError)
+			}
+			if test.wantRunIn > 0 {
+				if runIn := time.Until(saved.RunAt); runIn < test.wantRunIn-time.Minute || runIn > test.wantRunIn {
+					t.Errorf("runs again in %s, want %s", runIn, test.wantRunIn)
+				}
+			}
+		})
+	}
+}
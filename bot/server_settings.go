@@ -9,7 +9,10 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
-const archiverRepoUrl string = "https://github.com/tyzbit/go-discord-archiver"
+const (
+	archiverRepoUrl string = "https://github.com/tyzbit/go-discord-archiver"
+	userAgent       string = "go-discord-archiver (+" + archiverRepoUrl + ")"
+)
 
 // registerOrUpdateServer checks if a guild is already registered in the database. If not,
 // it creates it with sensibile defaults
@@ -101,16 +104,25 @@ func (bot *ArchiverBot) updateInactiveRegistrations(activeGuilds []*discordgo.Gu
 func (bot *ArchiverBot) getServerConfig(guildId string) ServerConfig {
 	// Default server config in case guild lookup fails, these are used for DMs
 	sc := ServerConfig{
-		DiscordId:          "",
-		Name:               "",
-		ArchiveEnabled:     sql.NullBool{Bool: true, Valid: true},
-		AlwaysArchiveFirst: sql.NullBool{Bool: false, Valid: true},
-		ShowDetails:        sql.NullBool{Bool: true, Valid: true},
-		RetryAttempts:      sql.NullInt32{Int32: 1, Valid: true},
-		RemoveRetriesDelay: sql.NullInt32{Int32: 30, Valid: true},
-		UTCOffset:          sql.NullInt32{Int32: 4, Valid: true},
-		UTCSign:            sql.NullString{String: "-", Valid: true},
-		UpdatedAt:          time.Now(),
+		DiscordId:             "",
+		Name:                  "",
+		ArchiveEnabled:        sql.NullBool{Bool: true, Valid: true},
+		AlwaysArchiveFirst:    sql.NullBool{Bool: false, Valid: true},
+		ShowDetails:           sql.NullBool{Bool: true, Valid: true},
+		ShowMementos:          sql.NullBool{Bool: false, Valid: true},
+		RetryAttempts:         sql.NullInt32{Int32: 1, Valid: true},
+		RemoveRetriesDelay:    sql.NullInt32{Int32: 30, Valid: true},
+		CaptureOutlinks:       sql.NullBool{Bool: false, Valid: true},
+		CaptureScreenshot:     sql.NullBool{Bool: false, Valid: true},
+		ReaderMode:            sql.NullBool{Bool: false, Valid: true},
+		SkipIfArchivedWithin:  sql.NullInt32{Int32: 0, Valid: true},
+		JSDelay:               sql.NullInt32{Int32: 0, Valid: true},
+		UTCOffset:             sql.NullInt32{Int32: 4, Valid: true},
+		UTCSign:               sql.NullString{String: "-", Valid: true},
+		SnapshotsPerUserHour:  sql.NullInt32{Int32: 10, Valid: true},
+		SnapshotsPerServerDay: sql.NullInt32{Int32: 100, Valid: true},
+		SnapshotCooldown:      sql.NullInt32{Int32: 30, Valid: true},
+		UpdatedAt:             time.Now(),
 	}
 	// If this fails, we'll return a default server
 	// config, which is expected
@@ -156,7 +168,7 @@ func (bot *ArchiverBot) respondToSettingsChoice(i *discordgo.InteractionCreate,
 	} else {
 		interactionErr = bot.DG.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
 			Type: discordgo.InteractionResponseUpdateMessage,
-			Data: bot.SettingsIntegrationResponse(sc),
+			Data: bot.settingsPage(setting, sc),
 		})
 	}
 
@@ -165,6 +177,19 @@ func (bot *ArchiverBot) respondToSettingsChoice(i *discordgo.InteractionCreate,
 	}
 }
 
+// respondWithSettingsPage switches a settings message to a different page
+func (bot *ArchiverBot) respondWithSettingsPage(i *discordgo.InteractionCreate,
+	page func(sc ServerConfig) *discordgo.InteractionResponseData) {
+	sc := bot.getServerConfig(i.GuildID)
+	err := bot.DG.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseUpdateMessage,
+		Data: page(sc),
+	})
+	if err != nil {
+		log.Errorf("error responding to settings page interaction, err: %v", err)
+	}
+}
+
 // updateServersWatched updates the servers watched value
 // in both the local bot stats and in the database. It is allowed to fail
 func (bot *ArchiverBot) updateServersWatched() error {
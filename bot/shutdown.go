@@ -0,0 +1,243 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	log "github.com/sirupsen/logrus"
+	"github.com/tyzbit/go-discord-archiver/globals"
+)
+
+// Seconds to wait for running work to finish if SHUTDOWN_TIMEOUT isn't set
+const defaultShutdownTimeout int = 25
+
+// lifecycleInit guards setting ArchiverBot.life
+var lifecycleInit sync.Mutex
+
+// lifecycle keeps track of running work so the bot can stop cleanly
+type lifecycle struct {
+	mu       sync.Mutex
+	stopping bool
+	// stopped is closed when the bot starts shutting down
+	stopped chan bool
+	// work is interactions being handled, archive jobs being run and
+	// snapshot jobs being checked on
+	work sync.WaitGroup
+	// pending has the interactions being handled whose response was
+	// deferred, by ID
+	pending map[string]*discordgo.Interaction
+	// jobs has the archive jobs being run, by ID. A job is true once its
+	// reply is being sent
+	jobs map[uint]bool
+	// abandoned has the jobs that were queued again because they didn't
+	// finish in time, which mustn't send their reply anymore
+	abandoned map[uint]bool
+	health    *http.Server
+}
+
+// errArchiveJobAbandoned is returned instead of sending the reply for a job
+// that was queued again because the bot shut down before it finished
+var errArchiveJobAbandoned = errors.New("archive job was queued again when the bot shut down")
+
+// lifecycle returns the bot's lifecycle, setting it up the first time
+func (bot *ArchiverBot) lifecycle() *lifecycle {
+	lifecycleInit.Lock()
+	defer lifecycleInit.Unlock()
+	if bot.life == nil {
+		bot.life = &lifecycle{
+			stopped:   make(chan bool),
+			pending:   map[string]*discordgo.Interaction{},
+			jobs:      map[uint]bool{},
+			abandoned: map[uint]bool{},
+		}
+	}
+	return bot.life
+}
+
+// begin records that work started. It returns false if the bot is
+// shutting down, in which case the work shouldn't be started
+func (l *lifecycle) begin(i *discordgo.Interaction) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.stopping {
+		return false
+	}
+	l.work.Add(1)
+	return true
+}
+
+// deferred records that the response to i was deferred, so it's told the
+// bot is restarting if it's still being handled when the bot shuts down
+func (l *lifecycle) deferred(i *discordgo.Interaction) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.pending[i.ID] = i
+}
+
+// end records that work started with begin finished. i is the interaction
+// it was for, if any
+func (l *lifecycle) end(i *discordgo.Interaction) {
+	l.mu.Lock()
+	if i != nil {
+		delete(l.pending, i.ID)
+	}
+	l.mu.Unlock()
+	l.work.Done()
+}
+
+// jobStarted records that a worker started running the archive job id
+func (l *lifecycle) jobStarted(id uint) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.jobs[id] = false
+}
+
+// jobFinished records that a worker is done with the archive job id
+func (l *lifecycle) jobFinished(id uint) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.jobs, id)
+	delete(l.abandoned, id)
+}
+
+// replying records that the reply for the archive job id is being sent.
+// It returns false if the job was abandoned, in which case it's sent after
+// the restart instead
+func (l *lifecycle) replying(id uint) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.abandoned[id] {
+		return false
+	}
+	if _, ok := l.jobs[id]; ok {
+		l.jobs[id] = true
+	}
+	return true
+}
+
+// abandonJobs stops every running archive job that hasn't started sending
+// its reply from sending it, and returns their IDs
+func (l *lifecycle) abandonJobs() (ids []uint) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for id, replying := range l.jobs {
+		if !replying {
+			l.abandoned[id] = true
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// deferResponse responds to i with response, which should be deferred, and
+// records it so it isn't left thinking forever if the bot shuts down
+func (bot *ArchiverBot) deferResponse(i *discordgo.Interaction, response *discordgo.InteractionResponse) error {
+	if err := bot.DG.InteractionRespond(i, response); err != nil {
+		return err
+	}
+	bot.lifecycle().deferred(i)
+	return nil
+}
+
+// Shutdown stops accepting interactions and archive jobs, then waits up
+// to SHUTDOWN_TIMEOUT seconds for running ones to finish. Jobs that haven't
+// started sending their reply by then are queued again for the next start,
+// and anyone still waiting on a deferred response is told the bot is
+// restarting. Replies waiting on snapshot jobs stop waiting and say they
+// won't be updated
+func (bot *ArchiverBot) Shutdown() {
+	timeout := defaultShutdownTimeout
+	if bot.Config.ShutdownTimeout > 0 {
+		timeout = bot.Config.ShutdownTimeout
+	}
+	life := bot.lifecycle()
+
+	life.mu.Lock()
+	life.stopping = true
+	close(life.stopped)
+	life.mu.Unlock()
+	log.Infof("shutting down, waiting up to %d seconds for running work to finish", timeout)
+
+	finished := make(chan bool)
+	go func() {
+		life.work.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		log.Info("running work finished")
+	case <-time.After(time.Duration(timeout) * time.Second):
+		log.Warn("running work didn't finish in time")
+		// Jobs that haven't sent their reply are started over on the next
+		// start. Ones already sending it are left to finish
+		if abandoned := life.abandonJobs(); len(abandoned) > 0 {
+			tx := bot.DB.Model(&ArchiveJob{}).Where("id IN ? AND state = ?", abandoned, archiveJobRunning).
+				Update("state", archiveJobQueued)
+			if tx.Error != nil {
+				log.Errorf("unable to requeue unfinished archive jobs: %v", tx.Error)
+			} else {
+				log.Infof("requeued %d unfinished archive jobs", tx.RowsAffected)
+			}
+		}
+	}
+
+	life.mu.Lock()
+	var pending []*discordgo.Interaction
+	for _, i := range life.pending {
+		pending = append(pending, i)
+	}
+	life.mu.Unlock()
+	for _, i := range pending {
+		bot.editRestartingResponse(i, globals.RestartingText)
+	}
+
+	// Queued jobs are still handled after the restart, and their reply
+	// edits the response again if the token is still good by then
+	var queued []ArchiveJob
+	tx := bot.DB.Where("state = ? AND interaction_token <> '' AND created_at > ?",
+		archiveJobQueued, time.Now().Add(-interactionTokenLifetime)).Find(&queued)
+	if tx.Error != nil {
+		log.Errorf("unable to look up queued archive jobs: %v", tx.Error)
+	}
+	for _, job := range queued {
+		bot.editRestartingResponse(job.interaction(), globals.RestartingQueuedText)
+	}
+
+	life.mu.Lock()
+	health := life.health
+	life.mu.Unlock()
+	if health != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := health.Shutdown(ctx); err != nil {
+			log.Errorf("unable to stop health api: %v", err)
+		}
+	}
+}
+
+// editRestartingResponse replaces the content of the response to i with
+// content, so it isn't left thinking forever
+func (bot *ArchiverBot) editRestartingResponse(i *discordgo.Interaction, content string) {
+	if _, err := bot.DG.InteractionResponseEdit(i, &discordgo.WebhookEdit{Content: &content}); err != nil {
+		log.Errorf("unable to tell user the bot is restarting: %v", err)
+	}
+}
+
+// respondRestarting responds to an interaction that came in while the bot
+// is shutting down
+func (bot *ArchiverBot) respondRestarting(i *discordgo.Interaction) {
+	err := bot.DG.InteractionRespond(i, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Flags:   discordgo.MessageFlagsEphemeral,
+			Content: globals.RestartingText,
+		},
+	})
+	if err != nil {
+		log.Errorf("unable to tell user the bot is restarting: %v", err)
+	}
+}
@@ -0,0 +1,76 @@
+package bot
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/tyzbit/go-discord-archiver/globals"
+)
+
+func TestShutdownAbandonsUnfinishedJobs(t *testing.T) {
+	bot, discord := newTestBot(t)
+	bot.Config.ShutdownTimeout = 1
+	life := bot.lifecycle()
+
+	// One job is still archiving and the other is sending its reply
+	archiving := &ArchiveJob{State: archiveJobRunning, AppID: "app", InteractionToken: "archiving-token"}
+	replying := &ArchiveJob{State: archiveJobRunning, AppID: "app", InteractionToken: "replying-token"}
+	for _, job := range []*ArchiveJob{archiving, replying} {
+		if tx := bot.DB.Create(job); tx.Error != nil {
+			t.Fatalf("unable to save archive job: %v", tx.Error)
+		}
+		life.begin(nil)
+		life.jobStarted(job.ID)
+	}
+	if !life.replying(replying.ID) {
+		t.Fatalf("a running job wasn't allowed to reply")
+	}
+
+	// One interaction is waiting on a deferred response and the other was
+	// answered straight away
+	waiting := &discordgo.Interaction{ID: "1", AppID: "app", Token: "waiting-token"}
+	answered := &discordgo.Interaction{ID: "2", AppID: "app", Token: "answered-token"}
+	for _, i := range []*discordgo.Interaction{waiting, answered} {
+		life.begin(i)
+	}
+	life.deferred(waiting)
+
+	bot.Shutdown()
+
+	states := map[uint]string{}
+	var jobs []ArchiveJob
+	bot.DB.Find(&jobs)
+	for _, job := range jobs {
+		states[job.ID] = job.State
+	}
+	if states[archiving.ID] != archiveJobQueued || states[replying.ID] != archiveJobRunning {
+		t.Errorf("got job states %v, want the archiving job queued and the replying one left running", states)
+	}
+
+	err := bot.sendArchiveJobReply(*archiving, []*discordgo.MessageSend{{Content: "done"}}, nil)
+	if !errors.Is(err, errArchiveJobAbandoned) {
+		t.Errorf("got %v sending the reply for an abandoned job, want errArchiveJobAbandoned", err)
+	}
+
+	edits := map[string]string{}
+	for _, request := range discord.Requests() {
+		for _, token := range []string{"archiving-token", "replying-token", "waiting-token", "answered-token"} {
+			if strings.Contains(request, "/"+token+"/") {
+				edits[token] = request
+			}
+		}
+	}
+	if !strings.Contains(edits["waiting-token"], "restarting, please try again") {
+		t.Errorf("the deferred interaction wasn't told the bot is restarting: %q", edits["waiting-token"])
+	}
+	if !strings.Contains(edits["archiving-token"], strings.Split(globals.RestartingQueuedText, ",")[0]) {
+		t.Errorf("the abandoned job wasn't told it's queued: %q", edits["archiving-token"])
+	}
+	for _, token := range []string{"replying-token", "answered-token"} {
+		if edits[token] != "" {
+			t.Errorf("an interaction that was answered was edited: %q", edits[token])
+		}
+	}
+}
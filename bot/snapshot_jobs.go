@@ -0,0 +1,294 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	log "github.com/sirupsen/logrus"
+	goarchive "github.com/tyzbit/go-archive"
+)
+
+const (
+	snapshotJobPollInterval time.Duration = 5 * time.Second
+	// Interaction tokens are only good for 15 minutes, so we stop before then
+	snapshotJobTimeout  time.Duration = 10 * time.Minute
+	snapshotPendingText string        = "⏳ Taking a new snapshot, this message will be updated when it's done."
+	// Shown instead of snapshotPendingText once the bot stops checking
+	snapshotInterruptedText string = "⚠️ The bot restarted before the new snapshot finished, so this message " +
+		"won't be updated. The snapshot may still show up later."
+)
+
+// errSnapshotWaitStopped is returned when the bot stops waiting for a
+// snapshot job because it's shutting down
+var errSnapshotWaitStopped = errors.New("stopped waiting for snapshot job")
+
+// waitForSnapshot checks on a snapshot job until it's no longer pending or
+// timeout passes. Errors checking the status are retried until the timeout.
+// If stop is closed first, errSnapshotWaitStopped is returned
+func waitForSnapshot(a AsyncSnapshotter, jobID string, timeout time.Duration, stop <-chan bool) (
+	status SnapshotJobStatus, err error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		status, err = a.SnapshotStatus(jobID)
+		if err == nil && !status.Pending {
+			return status, nil
+		}
+		if err != nil {
+			log.Debugf("unable to check status of snapshot job %s: %v", jobID, err)
+		}
+		if time.Now().After(deadline) {
+			return status, fmt.Errorf("timed out waiting for snapshot job %s", jobID)
+		}
+		select {
+		case <-time.After(snapshotJobPollInterval):
+		case <-stop:
+			return status, errSnapshotWaitStopped
+		}
+	}
+}
+
+// snapshotTracker follows the snapshot jobs started for a reply and keeps
+// the reply up to date as they finish
+type snapshotTracker struct {
+	bot         *ArchiverBot
+	archives    []ArchiveEvent
+	messageUrls []string
+	sc          ServerConfig
+	ephemeral   bool
+	statuses    map[string]SnapshotJobStatus
+	// stop is closed when the bot shuts down, after which the tracker
+	// stops waiting and interrupted is set
+	stop        <-chan bool
+	interrupted bool
+	// embeds are the embeds built for each result so far, which only need
+	// building again when their result changes
+	embeds []trackedEmbed
+}
+
+// trackedEmbed is the embed built for a result in a snapshotTracker's
+// reply, with its sparkline and what it was built from
+type trackedEmbed struct {
+	key       string
+	embed     *discordgo.MessageEmbed
+	sparkline goarchive.ArchiveOrgWaybackSparklineResponse
+}
+
+// embedKey returns what the embed for result is built from, which is the
+// result and the snapshots for its URL
+func embedKey(result archiveResult, archives []ArchiveEvent) string {
+	key := fmt.Sprintf("%+v", result)
+	for _, archive := range archives {
+		if archive.RequestURL == result.URL {
+			key += "\n" + archive.Provider + " " + archive.ResponseURL
+		}
+	}
+	return key
+}
+
+// newSnapshotTracker returns a snapshotTracker for archives, which should
+// already be saved to the database
+func (bot *ArchiverBot) newSnapshotTracker(archives []ArchiveEvent, messageUrls []string,
+	sc ServerConfig, ephemeral bool) *snapshotTracker {
+	return &snapshotTracker{
+		bot:         bot,
+		archives:    archives,
+		messageUrls: messageUrls,
+		sc:          sc,
+		ephemeral:   ephemeral,
+		statuses:    map[string]SnapshotJobStatus{},
+	}
+}
+
+// pendingJobs returns the indexes of archives with unfinished snapshot jobs
+func (t *snapshotTracker) pendingJobs() (indexes []int) {
+	for i, archive := range t.archives {
+		if archive.SnapshotJobID == "" || archive.ResponseURL != "" {
+			continue
+		}
+		if _, done := t.statuses[archive.SnapshotJobID]; !done {
+			indexes = append(indexes, i)
+		}
+	}
+	return indexes
+}
+
+// results returns the result for each requested URL
+func (t *snapshotTracker) results() []archiveResult {
+	results := archiveResults(t.archives, t.messageUrls, t.statuses)
+	if t.interrupted {
+		for index := range results {
+			if results[index].Outcome == archivePending {
+				results[index].Link = snapshotInterruptedText
+			}
+		}
+	}
+	return results
+}
+
+// reply builds the messages for results and adds the state of snapshot
+// jobs to the embeds. Embeds for results that haven't changed since the
+// last reply are reused, since building them needs a few lookups
+func (t *snapshotTracker) reply(results []archiveResult) (messagesToSend []*discordgo.MessageSend) {
+	if len(t.embeds) != len(results) {
+		t.embeds = make([]trackedEmbed, len(results))
+	}
+	// Each embed needs a few lookups, so they're built at the same time
+	forEachConcurrently(len(results), t.bot.archiveConcurrency(), func(i int) {
+		key := embedKey(results[i], t.archives)
+		if t.embeds[i].key == key {
+			return
+		}
+		embed, sparkline := t.bot.archiveEmbed(results[i], t.archives, t.sc)
+		t.embeds[i] = trackedEmbed{key: key, embed: embed, sparkline: sparkline}
+	})
+
+	built := make([]*discordgo.MessageEmbed, len(results))
+	sparklines := make([]goarchive.ArchiveOrgWaybackSparklineResponse, len(results))
+	for i, tracked := range t.embeds {
+		// The job fields are added to a copy, so the cached embed stays
+		// as it was built
+		if tracked.embed != nil {
+			embed := *tracked.embed
+			embed.Fields = append([]*discordgo.MessageEmbedField(nil), embed.Fields...)
+			built[i] = &embed
+		}
+		sparklines[i] = tracked.sparkline
+	}
+
+	messagesToSend = buildArchiveReply(built, sparklines, t.ephemeral)
+	for _, message := range messagesToSend {
+		for _, embed := range message.Embeds {
+			for _, archive := range t.archives {
+				if archive.RequestURL != embed.URL || archive.SnapshotJobID == "" {
+					continue
+				}
+				embed.Fields = append(embed.Fields, t.jobField(archive))
+			}
+		}
+	}
+	return messagesToSend
+}
+
+// jobField returns an embed field describing the snapshot job for archive
+func (t *snapshotTracker) jobField(archive ArchiveEvent) *discordgo.MessageEmbedField {
+	name := t.bot.providerDisplayName(archive.Provider) + " Capture"
+	status, done := t.statuses[archive.SnapshotJobID]
+	if !done && t.interrupted {
+		return &discordgo.MessageEmbedField{
+			Name:  "⚠️ " + name,
+			Value: fmt.Sprintf("Stopped checking when the bot restarted, job ID `%s`", archive.SnapshotJobID),
+		}
+	}
+	if !done {
+		return &discordgo.MessageEmbedField{
+			Name:  "⏳ " + name,
+			Value: fmt.Sprintf("Pending, job ID `%s`", archive.SnapshotJobID),
+		}
+	}
+	if status.Err != nil {
+		return &discordgo.MessageEmbedField{
+			Name:  "❌ " + name,
+			Value: fmt.Sprintf("Failed: %v", status.Err),
+		}
+	}
+
+	details := []string{
+		fmt.Sprintf("Took %.1fs", status.Duration.Seconds()),
+		fmt.Sprintf("%d outlinks", status.Outlinks),
+		fmt.Sprintf("%d embeds", status.Embeds),
+	}
+	if status.FirstArchive {
+		details = append(details, "first archive of this page")
+	}
+	return &discordgo.MessageEmbedField{
+		Name:  "✅ " + name,
+		Value: strings.Join(details, " · "),
+	}
+}
+
+// wait waits for every pending snapshot job until deadline, saving the
+// results and calling done with each archive as its job finishes. If the
+// bot shuts down first, interrupted is set and the rest aren't waited for
+func (t *snapshotTracker) wait(deadline time.Time, done func(archive *ArchiveEvent)) {
+	for _, index := range t.pendingJobs() {
+		archive := &t.archives[index]
+		p, ok := t.bot.getProvider(archive.Provider).(AsyncSnapshotter)
+		if !ok {
+			log.Errorf("archive provider %s can't check on snapshot jobs", archive.Provider)
+			continue
+		}
+
+		status, err := waitForSnapshot(p, archive.SnapshotJobID, time.Until(deadline), t.stop)
+		if errors.Is(err, errSnapshotWaitStopped) {
+			t.interrupted = true
+			return
+		}
+		if err != nil {
+			status.Err = err
+		}
+		t.statuses[archive.SnapshotJobID] = status
+
+		if status.Err == nil && status.URL != "" {
+			domainName, err := getDomainName(status.URL)
+			if err != nil {
+				log.Errorf("unable to get domain name for url: %v", status.URL)
+			}
+			archive.ResponseURL = status.URL
+			archive.ResponseDomainName = domainName
+			tx := t.bot.DB.Model(&ArchiveEvent{}).Where(&ArchiveEvent{UUID: archive.UUID}).
+				Updates(&ArchiveEvent{ResponseURL: archive.ResponseURL, ResponseDomainName: archive.ResponseDomainName})
+			if tx.RowsAffected != 1 {
+				log.Errorf("unexpected number of rows affected updating archive event: %v", tx.RowsAffected)
+			}
+		} else {
+			log.Errorf("snapshot job %s for %s failed: %v", archive.SnapshotJobID, archive.RequestURL, status.Err)
+			archive.Error = fmt.Sprintf("Unable to take a snapshot: %v", status.Err)
+			tx := t.bot.DB.Model(&ArchiveEvent{}).Where(&ArchiveEvent{UUID: archive.UUID}).
+				Updates(&ArchiveEvent{Error: archive.Error})
+			if tx.RowsAffected != 1 {
+				log.Errorf("unexpected number of rows affected updating archive event: %v", tx.RowsAffected)
+			}
+		}
+		done(archive)
+	}
+}
+
+// run waits for every pending snapshot job, calling update with each new
+// message of the reply each time one finishes. If the bot shuts down
+// first, the reply is updated once more to say it won't be updated again
+func (t *snapshotTracker) run(update func(index int, message *discordgo.MessageSend) error) {
+	send := func() {
+		messagesToSend := t.reply(t.results())
+		for index, message := range messagesToSend {
+			if err := update(index, message); err != nil {
+				log.Errorf("unable to update reply for snapshot jobs: %v", err)
+			}
+		}
+	}
+	t.wait(time.Now().Add(snapshotJobTimeout), func(archive *ArchiveEvent) {
+		send()
+	})
+	if t.interrupted {
+		send()
+	}
+}
+
+// startSnapshotTracker runs t in the background. The bot waits for it when
+// shutting down, and it stops waiting on snapshot jobs then
+func (bot *ArchiverBot) startSnapshotTracker(t *snapshotTracker, update func(index int, message *discordgo.MessageSend) error) {
+	life := bot.lifecycle()
+	t.stop = life.stopped
+	if !life.begin(nil) {
+		// The bot is already shutting down
+		t.interrupted = true
+		t.run(update)
+		return
+	}
+	go func() {
+		defer life.end(nil)
+		t.run(update)
+	}()
+}
@@ -0,0 +1,56 @@
+package bot
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestSnapshotTrackerReusesEmbeds(t *testing.T) {
+	bot, _ := newTestBot(t)
+	archives := []ArchiveEvent{
+		{RequestURL: "https://example.com/a", Provider: "wayback", Error: "site is down"},
+		{RequestURL: "https://example.com/b", Provider: "wayback", SnapshotJobID: "job"},
+	}
+	tracker := bot.newSnapshotTracker(archives, []string{"https://example.com/a", "https://example.com/b"},
+		ServerConfig{}, true)
+	tracker.statuses["job"] = SnapshotJobStatus{Err: errors.New("first failure")}
+
+	first := tracker.reply(tracker.results())
+	cached := []*discordgo.MessageEmbed{tracker.embeds[0].embed, tracker.embeds[1].embed}
+
+	// Only the embed for the result that changed is built again
+	tracker.statuses["job"] = SnapshotJobStatus{Err: errors.New("second failure")}
+	second := tracker.reply(tracker.results())
+	if tracker.embeds[0].embed != cached[0] {
+		t.Errorf("the embed for a result that didn't change was built again")
+	}
+	if tracker.embeds[1].embed == cached[1] {
+		t.Errorf("the embed for a result that changed wasn't built again")
+	}
+
+	for i, test := range []struct {
+		messages []*discordgo.MessageSend
+		want     string
+	}{
+		{first, "first failure"},
+		{second, "second failure"},
+	} {
+		if len(test.messages) != 1 || len(test.messages[0].Embeds) != 2 {
+			t.Fatalf("reply %d is %+v, want one message with 2 embeds", i+1, test.messages)
+		}
+		embeds := test.messages[0].Embeds
+		if !strings.Contains(embeds[1].Description, test.want) {
+			t.Errorf("reply %d has %q, want %q", i+1, embeds[1].Description, test.want)
+		}
+		// Only the embed with a snapshot job gets its state, and the
+		// cached embeds are left as they were built
+		if len(embeds[0].Fields) != len(tracker.embeds[0].embed.Fields) ||
+			len(embeds[1].Fields) != len(tracker.embeds[1].embed.Fields)+1 {
+			t.Errorf("reply %d has %d and %d fields, want the built embeds plus the job state on the second",
+				i+1, len(embeds[0].Fields), len(embeds[1].Fields))
+		}
+	}
+}
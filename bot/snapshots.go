@@ -0,0 +1,198 @@
+package bot
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	log "github.com/sirupsen/logrus"
+	globals "github.com/tyzbit/go-discord-archiver/globals"
+)
+
+// snapshotsPageID returns the custom ID for a button that shows a page of
+// captures. The URL is too long to fit, so it comes from the embed instead
+func snapshotsPageID(q cdxQuery) string {
+	return strings.Join([]string{
+		globals.SnapshotsPage,
+		fmt.Sprint(q.Page),
+		fmt.Sprint(q.StatusCode),
+		fmt.Sprint(q.Year),
+	}, globals.CustomIDSeparator)
+}
+
+// parseSnapshotsPageID is the reverse of snapshotsPageID
+func parseSnapshotsPageID(customID string, originalUrl string) (q cdxQuery, err error) {
+	parts := strings.Split(customID, globals.CustomIDSeparator)
+	if len(parts) != 4 || parts[0] != globals.SnapshotsPage {
+		return q, fmt.Errorf("unexpected snapshots page custom id: %v", customID)
+	}
+
+	q.URL = originalUrl
+	for i, value := range []*int{&q.Page, &q.StatusCode, &q.Year} {
+		if *value, err = strconv.Atoi(parts[i+1]); err != nil {
+			return q, fmt.Errorf("unable to parse snapshots page custom id: %v, err: %w", customID, err)
+		}
+	}
+	return q, nil
+}
+
+// snapshotsReply returns a message listing a page of captures of q.URL
+// with buttons to go to the next and previous pages
+func (bot *ArchiverBot) snapshotsReply(q cdxQuery, sc ServerConfig) *discordgo.MessageSend {
+	embed := &discordgo.MessageEmbed{
+		Title: "🗂️ Archive.org Captures",
+		URL:   q.URL,
+		Color: globals.FrenchGray,
+	}
+
+	captures, more, err := queryCDX(q, uint(sc.RetryAttempts.Int32))
+	if err != nil {
+		log.Errorf("unable to look up captures for url: %v, err: %v", q.URL, err)
+		embed.Description = "Unable to look up captures, most of the time this is " +
+			"due to rate-limiting by Archive.org. Please try again"
+		embed.Color = globals.BrightRed
+		return &discordgo.MessageSend{Embeds: []*discordgo.MessageEmbed{embed}}
+	}
+
+	var lines []string
+	for _, capture := range captures {
+		captured := capture.Timestamp
+		if at, err := time.ParseInLocation(globals.ArchiveOrgTimestampLayout, capture.Timestamp, time.UTC); err == nil {
+			captured = at.In(serverLocation(sc)).Format("2006-01-02 15:04")
+		}
+		lines = append(lines, fmt.Sprintf("[%s](%s/%s/%s) · %s · %s · `%s`",
+			captured, archiveRoot, capture.Timestamp, capture.Original,
+			capture.StatusCode, capture.MimeType, capture.Digest))
+	}
+	if len(lines) == 0 {
+		lines = []string{"No captures found."}
+	}
+	embed.Description = strings.Join(lines, "\n")
+
+	filters := []string{"All"}
+	if q.StatusCode != 0 || q.Year != 0 {
+		filters = []string{}
+		if q.StatusCode != 0 {
+			filters = append(filters, fmt.Sprintf("Status %d", q.StatusCode))
+		}
+		if q.Year != 0 {
+			filters = append(filters, fmt.Sprint(q.Year))
+		}
+	}
+	embed.Fields = []*discordgo.MessageEmbedField{
+		{
+			Name:   "URL",
+			Value:  q.URL,
+			Inline: false,
+		},
+		{
+			Name:   "Filters",
+			Value:  strings.Join(filters, ", "),
+			Inline: true,
+		},
+	}
+	embed.Footer = &discordgo.MessageEmbedFooter{
+		Text: fmt.Sprintf("Page %d · Timestamp · HTTP status · Type · Digest", q.Page+1),
+	}
+
+	previous, next := q, q
+	previous.Page--
+	next.Page++
+	return &discordgo.MessageSend{
+		Embeds: []*discordgo.MessageEmbed{embed},
+		Components: []discordgo.MessageComponent{
+			discordgo.ActionsRow{
+				Components: []discordgo.MessageComponent{
+					discordgo.Button{
+						Label:    "◀ Previous",
+						Style:    discordgo.SecondaryButton,
+						Disabled: q.Page == 0,
+						CustomID: snapshotsPageID(previous)},
+					discordgo.Button{
+						Label:    "Next ▶",
+						Style:    discordgo.SecondaryButton,
+						Disabled: !more,
+						CustomID: snapshotsPageID(next)},
+				},
+			},
+		},
+	}
+}
+
+// snapshotsInteraction is called by using /snapshots
+func (bot *ArchiverBot) snapshotsInteraction(i *discordgo.InteractionCreate) {
+	log.Debug("handling snapshots command request")
+	// Send a response immediately that says the bot is thinking
+	_ = bot.deferResponse(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Flags: discordgo.MessageFlagsEphemeral,
+		},
+	})
+
+	var q cdxQuery
+	for _, option := range i.ApplicationCommandData().Options {
+		if option.Name == globals.UrlOption {
+			messageUrls, _ := bot.extractMessageUrls(option.StringValue())
+			if len(messageUrls) > 0 {
+				q.URL = messageUrls[0]
+			}
+		}
+		if option.Name == globals.StatusOption {
+			q.StatusCode = int(option.IntValue())
+		}
+		if option.Name == globals.YearOption {
+			q.Year = int(option.IntValue())
+		}
+	}
+
+	message := &discordgo.MessageSend{
+		Embeds: []*discordgo.MessageEmbed{
+			{
+				Title: "No URL found",
+				Color: globals.BrightRed,
+			},
+		},
+	}
+	if q.URL != "" {
+		message = bot.snapshotsReply(q, bot.getServerConfig(i.GuildID))
+	}
+
+	_, err := bot.DG.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
+		Embeds:     &message.Embeds,
+		Components: &message.Components,
+	})
+	if err != nil {
+		log.Errorf("problem sending snapshots message: %v", err)
+	}
+}
+
+// snapshotsPageInteraction is called by the previous and next buttons on a
+// list of captures
+func (bot *ArchiverBot) snapshotsPageInteraction(i *discordgo.InteractionCreate) {
+	if i.Message == nil || len(i.Message.Embeds) == 0 {
+		log.Error("snapshots page button used on a message without an embed")
+		return
+	}
+
+	q, err := parseSnapshotsPageID(i.MessageComponentData().CustomID, i.Message.Embeds[0].URL)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	_ = bot.deferResponse(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredMessageUpdate,
+	})
+
+	message := bot.snapshotsReply(q, bot.getServerConfig(i.GuildID))
+	_, err = bot.DG.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
+		Embeds:     &message.Embeds,
+		Components: &message.Components,
+	})
+	if err != nil {
+		log.Errorf("problem updating snapshots message: %v", err)
+	}
+}
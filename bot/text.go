@@ -0,0 +1,144 @@
+package bot
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+const (
+	// Pages bigger than this are cut off when fetched
+	maxPageSize int64 = 10 << 20
+)
+
+var (
+	// Elements that never have readable text
+	skippedElements = map[atom.Atom]bool{
+		atom.Script:   true,
+		atom.Style:    true,
+		atom.Noscript: true,
+		atom.Template: true,
+		atom.Svg:      true,
+		atom.Iframe:   true,
+		atom.Object:   true,
+	}
+	// Elements that start a new line of text
+	blockElements = map[atom.Atom]bool{
+		atom.Address: true, atom.Article: true, atom.Aside: true, atom.Blockquote: true,
+		atom.Br: true, atom.Dd: true, atom.Div: true, atom.Dl: true, atom.Dt: true,
+		atom.Figcaption: true, atom.Figure: true, atom.Footer: true, atom.Form: true,
+		atom.H1: true, atom.H2: true, atom.H3: true, atom.H4: true, atom.H5: true, atom.H6: true,
+		atom.Header: true, atom.Hr: true, atom.Li: true, atom.Main: true, atom.Nav: true,
+		atom.Ol: true, atom.P: true, atom.Pre: true, atom.Section: true, atom.Table: true,
+		atom.Td: true, atom.Th: true, atom.Title: true, atom.Tr: true, atom.Ul: true,
+	}
+)
+
+// snapshotRawUrl returns the URL for the original content of a Wayback
+// Machine capture, without the banner and rewritten links
+func snapshotRawUrl(timestamp string, originalUrl string) string {
+	return fmt.Sprintf("%s/%sid_/%s", archiveRoot, timestamp, originalUrl)
+}
+
+// fetchSnapshot returns the original content of the capture of originalUrl
+// closest to timestamp and the URL of the capture it came from
+func fetchSnapshot(timestamp string, originalUrl string, attempts uint) (body []byte, snapshotUrl string, err error) {
+	body, pageUrl, err := fetchPage(snapshotRawUrl(timestamp, originalUrl), attempts)
+	if err != nil {
+		return body, snapshotUrl, err
+	}
+	// Archive.org redirects to the closest capture
+	return body, strings.Replace(pageUrl, "id_/", "/", 1), nil
+}
+
+// fetchPage returns the body of the page at pageUrl and the URL it ended up
+// at after redirects
+func fetchPage(pageUrl string, attempts uint) (body []byte, finalUrl string, err error) {
+	err = retryRequest(attempts, func() error {
+		r, err := http.NewRequest(http.MethodGet, pageUrl, nil)
+		if err != nil {
+			return fmt.Errorf("could not build http request: %w", err)
+		}
+		r.Header.Set("User-Agent", userAgent)
+
+		// Every site is guarded, which keeps crawls polite too. Pages
+		// can come from users, so only public addresses are fetched
+		resp, err := guardedDo(serviceName(r.URL.Hostname()), newPublicClient(time.Minute), r)
+		if err != nil {
+			return fmt.Errorf("error calling %s: %w", pageUrl, err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound {
+			return fmt.Errorf("no page found at %s", pageUrl)
+		}
+		// Error pages aren't the page that was asked for
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("unable to fetch %s (http status code %v)", pageUrl, resp.StatusCode)
+		}
+
+		body, err = io.ReadAll(io.LimitReader(resp.Body, maxPageSize))
+		if err != nil {
+			return fmt.Errorf("unable to read response body, err: %w", err)
+		}
+		finalUrl = resp.Request.URL.String()
+		return nil
+	})
+	return body, finalUrl, err
+}
+
+// extractText returns the readable text of an HTML page, one block of text
+// per line
+func extractText(page io.Reader) (string, error) {
+	doc, err := html.Parse(page)
+	if err != nil {
+		return "", fmt.Errorf("unable to parse html: %w", err)
+	}
+
+	var lines []string
+	var current strings.Builder
+	endLine := func() {
+		if line := strings.Join(strings.Fields(current.String()), " "); line != "" {
+			lines = append(lines, line)
+		}
+		current.Reset()
+	}
+
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			// The title is the only thing in the head worth keeping
+			if n.DataAtom == atom.Head {
+				for c := n.FirstChild; c != nil; c = c.NextSibling {
+					if c.DataAtom == atom.Title {
+						walk(c)
+					}
+				}
+				return
+			}
+			if skippedElements[n.DataAtom] {
+				return
+			}
+			if blockElements[n.DataAtom] {
+				endLine()
+			}
+		}
+		if n.Type == html.TextNode {
+			current.WriteString(n.Data)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+		if n.Type == html.ElementNode && blockElements[n.DataAtom] {
+			endLine()
+		}
+	}
+	walk(doc)
+	endLine()
+
+	return strings.Join(lines, "\n"), nil
+}
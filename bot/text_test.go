@@ -0,0 +1,62 @@
+package bot
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFetchPage(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/page":
+			_, _ = w.Write([]byte("<p>hello</p>"))
+		case "/moved":
+			http.Redirect(w, r, "/page", http.StatusMovedPermanently)
+		case "/gone":
+			http.Error(w, "<p>this page is gone</p>", http.StatusGone)
+		case "/broken":
+			http.Error(w, "<p>something went wrong</p>", http.StatusInternalServerError)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	// The test server isn't on a public address
+	public := publicTransport
+	publicTransport = &http.Transport{}
+	t.Cleanup(func() { publicTransport = public })
+
+	tests := []struct {
+		path      string
+		wantBody  string
+		wantFinal string
+		wantErr   bool
+	}{
+		{path: "/page", wantBody: "<p>hello</p>", wantFinal: "/page"},
+		{path: "/moved", wantBody: "<p>hello</p>", wantFinal: "/page"},
+		{path: "/missing", wantErr: true},
+		{path: "/gone", wantErr: true},
+		{path: "/broken", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			body, finalUrl, err := fetchPage(server.URL+test.path, 1)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("got error %v, want error %v", err, test.wantErr)
+			}
+			if test.wantErr {
+				// Error pages aren't returned as the page
+				if len(body) != 0 {
+					t.Errorf("got body %q with the error", body)
+				}
+				return
+			}
+			if string(body) != test.wantBody || finalUrl != server.URL+test.wantFinal {
+				t.Errorf("got %q at %s, want %q at %s", body, finalUrl, test.wantBody, server.URL+test.wantFinal)
+			}
+		})
+	}
+}
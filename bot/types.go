@@ -34,16 +34,96 @@ type ArchiveEvent struct {
 	RequestDomainName     string `gorm:"index"`
 	ResponseURL           string
 	ResponseDomainName    string `gorm:"index"`
+	Provider              string `gorm:"index"`
+	LocalCapturePath      string
+	SnapshotJobID         string `gorm:"index"`
+	RequestedTime         sql.NullTime
 	Cached                bool
+	// Error is why the provider couldn't find or take a snapshot
+	Error string
+}
+
+// ArchiveJob is a request to archive the URLs in a message or command.
+// Jobs are saved before they're worked on, so ones that didn't finish are
+// picked up again after a restart
+type ArchiveJob struct {
+	ID        uint `gorm:"primaryKey"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	State     string `gorm:"index"`
+	// Priority is archivePriorityInteractive or archivePriorityBulk, lower
+	// priorities are started first
+	Priority             int `gorm:"index"`
+	Attempts             int
+	LastError            string
+	ServerID             string `gorm:"index"`
+	ChannelID            string
+	UserID               string
+	URLs                 string
+	NewSnapshot          bool
+	RequestedTime        sql.NullTime
+	CaptureOutlinks      bool
+	CaptureScreenshot    bool
+	SkipIfArchivedWithin int32
+	JSDelay              int32
+	// CrawlDepth and CrawlPages are set for jobs from /archive-site, which
+	// archive the pages linked from the URL as well
+	CrawlDepth int32
+	CrawlPages int32
+	// MessageID is the message the reply goes under, for jobs from
+	// auto-archive
+	MessageID string
+	// Jobs from interactions reply with the interaction token while it's
+	// still good
+	AppID            string
+	InteractionToken string
+	Ephemeral        bool
+	// ArchiveEventUUIDs are the archive events made by the first attempt,
+	// so trying the job again only sends the reply
+	ArchiveEventUUIDs string
+	// Note is shown above the reply to the interaction, like how much
+	// quota is left
+	Note string
+}
+
+// ScheduledTask is work the bot does later, like removing a retry button.
+// Tasks are saved so they still happen after a restart
+type ScheduledTask struct {
+	ID        uint `gorm:"primaryKey"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	RunAt     time.Time `gorm:"index"`
+	Type      string    `gorm:"index"`
+	// Payload is JSON that's given to the task's handler
+	Payload   string
+	State     string `gorm:"index"`
+	Attempts  int
+	LastError string
+	// Recurring tasks run again this many seconds after they finish
+	IntervalSeconds int32
+}
+
+// SnapshotUsage is a request that took new snapshots, which quotas count
+type SnapshotUsage struct {
+	ID        uint      `gorm:"primaryKey"`
+	CreatedAt time.Time `gorm:"index"`
+	ServerID  string    `gorm:"index"`
+	UserID    string    `gorm:"index"`
+	Snapshots int
 }
 
 // Handlers
 // ArchiverBot is the main type passed around throughout the code
 // It has many functions for overall bot management
 type ArchiverBot struct {
-	DB     *gorm.DB
-	DG     *discordgo.Session
-	Config ArchiverBotConfig
+	DB        *gorm.DB
+	DG        *discordgo.Session
+	Config    ArchiverBotConfig
+	Providers []ArchiveProvider
+	// jobs is set by StartArchiveWorkers
+	jobs *archiveQueue
+	// life is set the first time it's needed by lifecycle()
+	life *lifecycle
 }
 
 // ArchiverBotConfig is attached to ArchiverBot so config settings can be
@@ -57,6 +137,38 @@ type ArchiverBotConfig struct {
 	LogLevel              string `env:"LOG_LEVEL"`
 	Token                 string `env:"TOKEN"`
 	Cookie                string `env:"COOKIE"`
+	Cookies               string `env:"COOKIES"`
+	ArchiveOrgKeys        string `env:"ARCHIVE_ORG_KEYS"`
+	AccountHourlyBudget   int    `env:"ACCOUNT_HOURLY_BUDGET"`
+	ArchiveOrgLogins      string `env:"ARCHIVE_ORG_LOGINS"`
+	ArchiveOrgLoginURL    string `env:"ARCHIVE_ORG_LOGIN_URL"`
+	AdminIds              string `env:"ADMINISTRATOR_IDS"`
+	ArchiveProviders      string `env:"ARCHIVE_PROVIDERS"`
+	MementoTimeGateURL    string `env:"MEMENTO_TIMEGATE_URL"`
+	MementoTimeMapURL     string `env:"MEMENTO_TIMEMAP_URL"`
+	WARCDirectory         string `env:"WARC_DIRECTORY"`
+	LocalCaptureMode      string `env:"LOCAL_CAPTURE_MODE"`
+	CrawlMaxDepth         int    `env:"CRAWL_MAX_DEPTH"`
+	CrawlMaxPages         int    `env:"CRAWL_MAX_PAGES"`
+	ArchiveWorkers        int    `env:"ARCHIVE_WORKERS"`
+	ArchiveConcurrency    int    `env:"ARCHIVE_CONCURRENCY"`
+	RateLimit             int    `env:"RATE_LIMIT"`
+	BulkRateLimit         int    `env:"BULK_RATE_LIMIT"`
+	BulkArchiveWorkers    int    `env:"BULK_ARCHIVE_WORKERS"`
+	AutoArchive           bool   `env:"AUTO_ARCHIVE"`
+	ShutdownTimeout       int    `env:"SHUTDOWN_TIMEOUT"`
+}
+
+// ChannelConfig has settings for one channel in a server. Channels without
+// one use the defaults
+type ChannelConfig struct {
+	ServerID     string       `gorm:"primaryKey" pretty:"Server ID"`
+	ChannelID    string       `gorm:"primaryKey" pretty:"Channel ID"`
+	Respond      sql.NullBool `pretty:"Bot responds here" gorm:"default:true"`
+	AutoArchive  sql.NullBool `pretty:"Auto-archive links" gorm:"default:false"`
+	IncludeBots  sql.NullBool `pretty:"Auto-archive bots and webhooks" gorm:"default:false"`
+	ForcePrivate sql.NullBool `pretty:"Replies are private" gorm:"default:false"`
+	UpdatedAt    time.Time
 }
 
 // Servers
@@ -70,14 +182,23 @@ type ServerRegistration struct {
 }
 
 type ServerConfig struct {
-	DiscordId          string         `gorm:"primaryKey;uniqueIndex" pretty:"Server ID"`
-	Name               string         `pretty:"Server Name" gorm:"default:default"`
-	ArchiveEnabled     sql.NullBool   `pretty:"Bot enabled" gorm:"default:true"`
-	AlwaysArchiveFirst sql.NullBool   `pretty:"Archive the page first (slower)" gorm:"default:false"`
-	ShowDetails        sql.NullBool   `pretty:"Show extra details" gorm:"default:true"`
-	RetryAttempts      sql.NullInt32  `pretty:"Number of times to retry calling archive.org" gorm:"default:1"`
-	RemoveRetriesDelay sql.NullInt32  `pretty:"Seconds to wait to remove retry button" gorm:"default:30"`
-	UTCOffset          sql.NullInt32  `pretty:"UTC Offset" gorm:"default:4"`
-	UTCSign            sql.NullString `pretty:"UTC Sign (Negative if west of Greenwich)" gorm:"default:-"`
-	UpdatedAt          time.Time
+	DiscordId             string         `gorm:"primaryKey;uniqueIndex" pretty:"Server ID"`
+	Name                  string         `pretty:"Server Name" gorm:"default:default"`
+	ArchiveEnabled        sql.NullBool   `pretty:"Bot enabled" gorm:"default:true"`
+	AlwaysArchiveFirst    sql.NullBool   `pretty:"Archive the page first (slower)" gorm:"default:false"`
+	ShowDetails           sql.NullBool   `pretty:"Show extra details" gorm:"default:true"`
+	ShowMementos          sql.NullBool   `pretty:"Show other web archives (slower)" gorm:"default:false"`
+	RetryAttempts         sql.NullInt32  `pretty:"Number of times to retry calling archive.org" gorm:"default:1"`
+	RemoveRetriesDelay    sql.NullInt32  `pretty:"Seconds to wait to remove retry button" gorm:"default:30"`
+	CaptureOutlinks       sql.NullBool   `pretty:"Archive outlinks too (slower)" gorm:"default:false"`
+	CaptureScreenshot     sql.NullBool   `pretty:"Capture a screenshot" gorm:"default:false"`
+	SkipIfArchivedWithin  sql.NullInt32  `pretty:"Hours to reuse a recent snapshot instead of taking a new one" gorm:"default:0"`
+	JSDelay               sql.NullInt32  `pretty:"Seconds to let JavaScript run before capturing" gorm:"default:0"`
+	ReaderMode            sql.NullBool   `pretty:"Attach article text" gorm:"default:false"`
+	UTCOffset             sql.NullInt32  `pretty:"UTC Offset" gorm:"default:4"`
+	UTCSign               sql.NullString `pretty:"UTC Sign (Negative if west of Greenwich)" gorm:"default:-"`
+	SnapshotsPerUserHour  sql.NullInt32  `pretty:"New snapshots each person can take per hour" gorm:"default:10"`
+	SnapshotsPerServerDay sql.NullInt32  `pretty:"New snapshots the server can take per day" gorm:"default:100"`
+	SnapshotCooldown      sql.NullInt32  `pretty:"Seconds between new snapshots from one person" gorm:"default:30"`
+	UpdatedAt             time.Time
 }
@@ -4,13 +4,57 @@ import (
 	"fmt"
 	"net/url"
 	"reflect"
+	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
 	log "github.com/sirupsen/logrus"
+	globals "github.com/tyzbit/go-discord-archiver/globals"
 )
 
+var (
+	// Layouts accepted for dates users ask for snapshots from
+	requestedDateLayouts = []string{"2006-01-02 15:04", "2006-01-02", "20060102"}
+	// Web archive URLs usually have a timestamp like /20060102150405/
+	snapshotTimestampRegex = regexp.MustCompile(`/(\d{14})[a-z_]*/`)
+)
+
+const (
+	// How many URLs are worked on at once if ARCHIVE_CONCURRENCY isn't set
+	defaultArchiveConcurrency int = 4
+)
+
+// archiveConcurrency returns how many URLs can be worked on at once
+func (bot *ArchiverBot) archiveConcurrency() int {
+	if bot.Config.ArchiveConcurrency > 0 {
+		return bot.Config.ArchiveConcurrency
+	}
+	return defaultArchiveConcurrency
+}
+
+// forEachConcurrently calls fn with every number from 0 to n-1, at most
+// limit at a time, and returns when they've all finished. fn should save
+// its results by index so they stay in order
+func forEachConcurrently(n int, limit int, fn func(i int)) {
+	if limit < 1 {
+		limit = 1
+	}
+	var wg sync.WaitGroup
+	slots := make(chan bool, limit)
+	for i := 0; i < n; i++ {
+		slots <- true
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			fn(i)
+		}(i)
+	}
+	wg.Wait()
+}
+
 // getTagValue looks up the tag for a given field of the specified type
 // Be advised, if the tag can't be found, it returns an empty string
 func getTagValue(i interface{}, field string, tag string) string {
@@ -55,6 +99,15 @@ func timeZoneSign(sc ServerConfig) (options []discordgo.SelectMenuOption) {
 	return options
 }
 
+// serverLocation returns the time zone configured for a server
+func serverLocation(sc ServerConfig) *time.Location {
+	sign := map[string]int{
+		"-": -1,
+		"+": 1,
+	}
+	return time.FixedZone("UTC", sign[sc.UTCSign.String]*int(sc.UTCOffset.Int32)*60*60)
+}
+
 // typeInChannel sets the typing indicator for a channel. The indicator is cleared
 // when a message is sent
 func (bot *ArchiverBot) typeInChannel(channel chan bool, channelID string) {
@@ -95,3 +148,78 @@ func getDomainName(s string) (string, error) {
 	hostname := strings.TrimPrefix(url.Hostname(), "www.")
 	return hostname, nil
 }
+
+// isClosed returns whether ch has been closed
+func isClosed(ch <-chan bool) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+// retryRequest calls fn until it succeeds, up to attempts times (at least
+// once), waiting a second between attempts. The last error is returned.
+// Rate limits and paused services aren't retried, trying again right away
+// wouldn't help
+func retryRequest(attempts uint, fn func() error) (err error) {
+	if attempts == 0 {
+		attempts = 1
+	}
+	for attempt := uint(1); attempt <= attempts; attempt++ {
+		if err = fn(); err == nil {
+			return nil
+		}
+		if !retriable(err) {
+			return err
+		}
+		if attempt < attempts {
+			time.Sleep(time.Second)
+		}
+	}
+	return err
+}
+
+// parseRequestedDate parses a date a user asked for snapshots from in the
+// server's time zone. See requestedDateLayouts for what's accepted
+func parseRequestedDate(value string, sc ServerConfig) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	for _, layout := range requestedDateLayouts {
+		if at, err := time.ParseInLocation(layout, value, serverLocation(sc)); err == nil {
+			return at, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unable to parse requested date: %v", value)
+}
+
+// modalTextValue returns the value of the text input with customID from a
+// submitted modal
+func modalTextValue(data discordgo.ModalSubmitInteractionData, customID string) string {
+	for _, row := range data.Components {
+		actionsRow, ok := row.(*discordgo.ActionsRow)
+		if !ok {
+			continue
+		}
+		for _, component := range actionsRow.Components {
+			if input, ok := component.(*discordgo.TextInput); ok && input.CustomID == customID {
+				return input.Value
+			}
+		}
+	}
+	return ""
+}
+
+// snapshotTime returns the time a snapshot was taken from the 14 digit
+// timestamp in its URL, which most web archives use
+func snapshotTime(snapshotUrl string) (time.Time, bool) {
+	timestamp := snapshotTimestampRegex.FindStringSubmatch(snapshotUrl)
+	if timestamp == nil {
+		return time.Time{}, false
+	}
+	at, err := time.ParseInLocation(globals.ArchiveOrgTimestampLayout, timestamp[1], time.UTC)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return at, true
+}
@@ -0,0 +1,243 @@
+package bot
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha1"
+	"encoding/base32"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"net/http/httputil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	localCaptureAlways   string = "always"
+	localCaptureFallback string = "fallback"
+	warcMaxResponseSize  int64  = 50 << 20
+	warcDateLayout       string = "2006-01-02T15:04:05Z"
+)
+
+var unsafeFileNameCharacters = regexp.MustCompile(`[^A-Za-z0-9.-]+`)
+
+// localCapturer fetches pages itself and stores them as WARC files so
+// there's a copy that doesn't depend on a third party
+type localCapturer struct {
+	dir    string
+	client *http.Client
+}
+
+// newLocalCapturer returns a localCapturer that writes WARC files to dir
+func newLocalCapturer(dir string) localCapturer {
+	return localCapturer{
+		dir:    dir,
+		client: newPublicClient(time.Minute),
+	}
+}
+
+// localCaptureMode returns when pages should be captured locally,
+// or an empty string if local capture is disabled
+func (bot *ArchiverBot) localCaptureMode() string {
+	if bot.Config.WARCDirectory == "" {
+		return ""
+	}
+	if strings.EqualFold(bot.Config.LocalCaptureMode, localCaptureAlways) {
+		return localCaptureAlways
+	}
+	return localCaptureFallback
+}
+
+// Capture fetches u and writes the request and response to a new gzipped
+// WARC file. It returns the path to the file. Pages bigger than
+// warcMaxResponseSize aren't captured, since a cut off copy isn't one
+func (c localCapturer) Capture(u string) (path string, err error) {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return "", fmt.Errorf("could not build http request: %w", err)
+	}
+	req.Header.Set("User-Agent", userAgent)
+	// Otherwise the transport asks for gzip and unzips the body itself, so
+	// the response recorded wouldn't be the one that was sent
+	req.Header.Set("Accept-Encoding", "identity")
+
+	// The transport adds headers of its own, like Host, so the request is
+	// recorded as it's written instead of from req
+	sent := &sentRequest{}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), sent.trace()))
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("error fetching %s: %w", u, err)
+	}
+	defer resp.Body.Close()
+	rawRequest := sent.dump(resp.Request)
+	// The records are for the page redirects ended up at
+	target := resp.Request.URL.String()
+
+	payload, err := io.ReadAll(io.LimitReader(resp.Body, warcMaxResponseSize+1))
+	if err != nil {
+		return "", fmt.Errorf("error reading body from %s: %w", u, err)
+	}
+	if int64(len(payload)) > warcMaxResponseSize {
+		return "", fmt.Errorf("%s is bigger than %d MB, so it wasn't captured", u, warcMaxResponseSize>>20)
+	}
+	// The body has been read, so put it back to record it with the headers
+	resp.Body = io.NopCloser(bytes.NewReader(payload))
+	resp.ContentLength = int64(len(payload))
+	resp.TransferEncoding = nil
+	rawResponse, err := httputil.DumpResponse(resp, true)
+	if err != nil {
+		return "", fmt.Errorf("unable to record response: %w", err)
+	}
+
+	if err := os.MkdirAll(c.dir, 0750); err != nil {
+		return "", fmt.Errorf("unable to make directory path %s: %w", c.dir, err)
+	}
+
+	now := time.Now().UTC()
+	domainName, _ := getDomainName(u)
+	fileName := fmt.Sprintf("%s-%s-%s.warc.gz", now.Format("20060102150405"),
+		unsafeFileNameCharacters.ReplaceAllString(domainName, "_"), uuid.New().String()[:8])
+	path = filepath.Join(c.dir, fileName)
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0640)
+	if err != nil {
+		return "", fmt.Errorf("unable to create warc file: %w", err)
+	}
+	defer file.Close()
+
+	responseID := warcRecordID()
+	records := []struct {
+		headers [][2]string
+		block   []byte
+	}{
+		{
+			headers: [][2]string{
+				{"WARC-Type", "warcinfo"},
+				{"WARC-Record-ID", warcRecordID()},
+				{"WARC-Date", now.Format(warcDateLayout)},
+				{"WARC-Filename", fileName},
+				{"Content-Type", "application/warc-fields"},
+			},
+			block: []byte("software: go-discord-archiver\r\nformat: WARC File Format 1.1\r\n" +
+				"isPartOf: " + archiverRepoUrl + "\r\n"),
+		},
+		{
+			headers: [][2]string{
+				{"WARC-Type", "response"},
+				{"WARC-Record-ID", responseID},
+				{"WARC-Date", now.Format(warcDateLayout)},
+				{"WARC-Target-URI", target},
+				{"WARC-Payload-Digest", warcDigest(payload)},
+				{"Content-Type", "application/http;msgtype=response"},
+			},
+			block: rawResponse,
+		},
+		{
+			headers: [][2]string{
+				{"WARC-Type", "request"},
+				{"WARC-Record-ID", warcRecordID()},
+				{"WARC-Date", now.Format(warcDateLayout)},
+				{"WARC-Target-URI", target},
+				{"WARC-Concurrent-To", responseID},
+				{"Content-Type", "application/http;msgtype=request"},
+			},
+			block: rawRequest,
+		},
+	}
+
+	for _, record := range records {
+		if err := writeWARCRecord(file, record.headers, record.block); err != nil {
+			_ = os.Remove(path)
+			return "", fmt.Errorf("unable to write warc record: %w", err)
+		}
+	}
+
+	return path, nil
+}
+
+// sentRequest has the header fields of a request as the transport wrote
+// them. Only the last request is kept if there were redirects
+type sentRequest struct {
+	mu     sync.Mutex
+	fields [][2]string
+}
+
+// trace returns a ClientTrace that records the header fields of each
+// request as it's written
+func (s *sentRequest) trace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		GetConn: func(hostPort string) {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			s.fields = nil
+		},
+		WroteHeaderField: func(key string, values []string) {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			for _, value := range values {
+				s.fields = append(s.fields, [2]string{key, value})
+			}
+		},
+	}
+}
+
+// dump returns r as it was sent. HTTP/2 requests are written the HTTP/1.1
+// way, since that's what WARC files have
+func (s *sentRequest) dump(r *http.Request) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s %s HTTP/1.1\r\n", r.Method, r.URL.RequestURI())
+	for _, field := range s.fields {
+		key := field[0]
+		if key == ":authority" {
+			key = "Host"
+		} else if strings.HasPrefix(key, ":") {
+			continue
+		}
+		fmt.Fprintf(&b, "%s: %s\r\n", key, field[1])
+	}
+	b.WriteString("\r\n")
+	return b.Bytes()
+}
+
+// writeWARCRecord writes a single record to w as its own gzip member,
+// which is how compressed WARC files are expected to be laid out
+func writeWARCRecord(w io.Writer, headers [][2]string, block []byte) error {
+	gz := gzip.NewWriter(w)
+	var record bytes.Buffer
+	record.WriteString("WARC/1.1\r\n")
+	for _, header := range headers {
+		record.WriteString(header[0] + ": " + header[1] + "\r\n")
+	}
+	record.WriteString("WARC-Block-Digest: " + warcDigest(block) + "\r\n")
+	record.WriteString(fmt.Sprintf("Content-Length: %d\r\n\r\n", len(block)))
+	record.Write(block)
+	record.WriteString("\r\n\r\n")
+
+	if _, err := gz.Write(record.Bytes()); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// warcRecordID returns a new unique WARC-Record-ID
+func warcRecordID() string {
+	return "<urn:uuid:" + uuid.New().String() + ">"
+}
+
+// warcDigest returns the digest of b in the format WARC files use
+func warcDigest(b []byte) string {
+	sum := sha1.Sum(b)
+	return "sha1:" + base32.StdEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,156 @@
+package bot
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// readWARCTypes returns the WARC-Type of every record in the gzipped WARC
+// file at path, and the whole file uncompressed
+func readWARCTypes(t *testing.T, path string) (types []string, content string) {
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("unable to open warc file: %v", err)
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatalf("warc file isn't gzipped: %v", err)
+	}
+	b, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatalf("unable to read warc file: %v", err)
+	}
+	content = string(b)
+
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		if line := scanner.Text(); strings.HasPrefix(line, "WARC-Type: ") {
+			types = append(types, strings.TrimPrefix(line, "WARC-Type: "))
+		}
+	}
+	return types, content
+}
+
+func TestLocalCapture(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/moved":
+			http.Redirect(w, r, "/page", http.StatusFound)
+		case "/page":
+			w.Header().Set("Content-Type", "text/html")
+			_, _ = w.Write([]byte("<html><body>archived page</body></html>"))
+		case "/huge":
+			_, _ = io.CopyN(w, zeroReader{}, warcMaxResponseSize+1)
+		}
+	}))
+	defer server.Close()
+
+	c := localCapturer{dir: t.TempDir(), client: &http.Client{}}
+
+	path, err := c.Capture(server.URL + "/page")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.HasSuffix(path, ".warc.gz") {
+		t.Errorf("got path %s, want a .warc.gz file", path)
+	}
+	types, content := readWARCTypes(t, path)
+	if strings.Join(types, ",") != "warcinfo,response,request" {
+		t.Errorf("got records %v, want warcinfo, response and request", types)
+	}
+	for _, want := range []string{
+		"WARC-Target-URI: " + server.URL + "/page",
+		"HTTP/1.1 200 OK",
+		"archived page",
+		"GET /page HTTP/1.1\r\nHost: " + strings.TrimPrefix(server.URL, "http://"),
+		// Headers the transport adds are recorded too
+		"Accept-Encoding: identity",
+		"User-Agent: " + userAgent,
+	} {
+		if !strings.Contains(content, want) {
+			t.Errorf("warc file is missing %q", want)
+		}
+	}
+
+	// Only the request that got the page is recorded after a redirect
+	path, err = c.Capture(server.URL + "/moved")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, content := readWARCTypes(t, path); strings.Count(content, "Host: ") != 1 ||
+		!strings.Contains(content, "GET /page HTTP/1.1") || strings.Contains(content, "WARC-Target-URI: "+server.URL+"/moved") {
+		t.Errorf("got records %q, want only the redirected request", content)
+	}
+	_ = os.Remove(path)
+
+	if _, err := c.Capture(server.URL + "/huge"); err == nil {
+		t.Errorf("got no error capturing a page over the size limit")
+	}
+	entries, _ := os.ReadDir(c.dir)
+	if len(entries) != 1 {
+		t.Errorf("got %d files, want only the first capture", len(entries))
+	}
+}
+
+func TestLocalCaptureOnlyPublic(t *testing.T) {
+	fetched := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetched = true
+	}))
+	defer server.Close()
+
+	_, err := newLocalCapturer(t.TempDir()).Capture(server.URL)
+	var private privateAddressError
+	if !errors.As(err, &private) {
+		t.Errorf("got %v, want a privateAddressError", err)
+	}
+	if fetched {
+		t.Errorf("a local address was fetched")
+	}
+}
+
+func TestIsPublicIP(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"fd00::1", false},
+		{"0.0.0.0", false},
+		{"100.64.0.1", false},
+		{"224.0.0.1", false},
+		{"255.255.255.255", false},
+	}
+
+	for _, test := range tests {
+		if got := isPublicIP(net.ParseIP(test.ip)); got != test.want {
+			t.Errorf("isPublicIP(%s) = %v, want %v", test.ip, got, test.want)
+		}
+	}
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
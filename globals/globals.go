@@ -11,25 +11,77 @@ const (
 	// Commands
 	Settings                  = "settings"
 	Archive                   = "archive"
+	Snapshots                 = "snapshots"
+	Diff                      = "diff"
+	ArchiveSite               = "archive-site"
+	AutoArchive               = "autoarchive"
 	ArchiveMessage            = "Get saved snapshots"
 	ArchiveMessagePrivate     = "Get saved snapshots (private)"
 	ArchiveMessageNewSnapshot = "Take new snapshot"
+	ArchiveMessageAtDate      = "Get snapshot from date"
 	Help                      = "help"
 
 	// Command options
-	UrlOption             = "url"
-	TakeNewSnapshotOption = "new"
+	UrlOption                  = "url"
+	TakeNewSnapshotOption      = "new"
+	OutlinksOption             = "outlinks"
+	ScreenshotOption           = "screenshot"
+	SkipIfArchivedWithinOption = "skip_if_archived_within"
+	JSDelayOption              = "js_delay"
+	DateOption                 = "date"
+	StatusOption               = "status"
+	YearOption                 = "year"
+	FromOption                 = "from"
+	ToOption                   = "to"
+	DepthOption                = "depth"
+	PagesOption                = "pages"
+	ChannelOption              = "channel"
+	EnabledOption              = "enabled"
+	IncludeBotsOption          = "include_bots"
+
+	// Pages of captures from /snapshots
+	SnapshotsPage = "snapshotspage"
+	// Compares the oldest and newest captures of a URL in an archive reply
+	CompareSnapshots = "comparesnapshots"
+
+	// Modals
+	ArchiveDateModal = "archivedate"
+
+	// Separates the name of a component or modal from the data in its custom ID
+	CustomIDSeparator = ":"
+
+	// Bot settings pages
+	SettingsGeneral = "settingsgeneral"
+	SettingsCapture = "settingscapture"
+	SettingsLimits  = "settingslimits"
+	// The channels page and its channel menu
+	SettingsChannels = "settingschannels"
+	SettingsChannel  = "settingschannel"
 
 	// Bot settings unique handler names
 	// Booleans
 	BotEnabled         = "enabled"
 	AlwaysArchiveFirst = "alwayssnapshotfirst"
 	Details            = "showdetails"
+	ShowMementos       = "showmementos"
 	RemoveRetry        = "removeretry"
+	CaptureOutlinks    = "captureoutlinks"
+	CaptureScreenshot  = "capturescreenshot"
+	ReaderMode         = "readermode"
+	// Channel booleans have the channel ID after the separator
+	ChannelRespond      = "channelrespond"
+	ChannelAutoArchive  = "channelautoarchive"
+	ChannelIncludeBots  = "channelincludebots"
+	ChannelForcePrivate = "channelforceprivate"
 	// Integers
-	RetryAttempts    = "retries"
-	RemoveRetryAfter = "removeretryafter"
-	UTCOffset        = "utcoffset"
+	RetryAttempts         = "retries"
+	RemoveRetryAfter      = "removeretryafter"
+	SkipIfArchivedWithin  = "skipifarchivedwithin"
+	JSDelay               = "jsdelay"
+	UTCOffset             = "utcoffset"
+	SnapshotsPerUserHour  = "snapshotsperuserhour"
+	SnapshotsPerServerDay = "snapshotsperserverday"
+	SnapshotCooldown      = "snapshotcooldown"
 	// Strings
 	UTCSign = "utcsign"
 
@@ -45,6 +97,7 @@ const (
 - Right-click (or long press) a message and use "Get snapshot" to post a message with snapshots for the links in the message. 
   - Use the private option for a message only you can see.
 - Select "Take snapshot" to take a fresh snapshot of the live page.
+- Select "Get snapshot from date" to find the snapshots closest to a date.
 
 **This is a pretty good way to get around paywalls to read articles for free.**
 
@@ -56,10 +109,34 @@ Get a snapshot for one URL in a message visible only to you (It will ask if you
 
 ` + "`/archive`" + `
 
+Add the ` + "`date`" + ` option to get the snapshot closest to a date instead of the newest one. ` + DateFormatHelpText + `
+
+List every capture of a URL, optionally only ones with an HTTP status or from a year:
+
+` + "`/snapshots`" + `
+
+Archive a page and the pages on the same site it links to:
+
+` + "`/archive-site`" + `
+
+Archive every link posted in a channel (if the bot owner has turned this on):
+
+` + "`/autoarchive`" + `
+
+Compare the text of two captures of a URL, from and to can be timestamps like ` + "`20200102150405`" + ` (or the start of one, like ` + "`2020`" + `) or dates:
+
+` + "`/diff`" + `
+
 Get this help message:
 
 ` + "`/help`"
-	BotHelpFooterText = "It can take up to a few minutes for archive.org to save a page, so if you don't get a link immediately, please be patient."
+	DateFormatHelpText = "Dates look like `2006-01-02` or `2006-01-02 15:04` and are in the time zone from `/settings`."
+	BotHelpFooterText  = "It can take up to a few minutes for archive.org to save a page, the reply will be updated when the snapshot is done."
+	// Sent with replies that took too long to go in the original response
+	LateReplyText = "Sorry for the wait, here's what you asked for:"
+	// Sent to users waiting on a reply when the bot stops
+	RestartingText       = "🔄 The bot is restarting, please try again in a minute."
+	RestartingQueuedText = "🔄 The bot is restarting. Your request is saved and you'll get a reply when it's back."
 )
 
 var (
@@ -70,6 +147,35 @@ var (
 	MaxAllowedRetryAttemptsFloat = float64(MaxAllowedRetryAttempts)
 
 	AllowedRetryAttemptRemovalDelayValues = []int{0, 10, 30, 90, 120, 300}
+
+	AllowedSkipIfArchivedWithinValues = []int{0, 1, 6, 12, 24, 48, 168}
+	MinAllowedSkipIfArchivedWithin    = float64(0)
+	MaxAllowedSkipIfArchivedWithin    = float64(8760)
+
+	MinAllowedStatusCode = float64(100)
+	MaxAllowedStatusCode = float64(599)
+
+	MinAllowedYear = float64(1996)
+	MaxAllowedYear = float64(9999)
+
+	MinAllowedCrawlDepth = float64(0)
+	MaxAllowedCrawlDepth = float64(5)
+
+	MinAllowedCrawlPages = float64(1)
+	MaxAllowedCrawlPages = float64(500)
+
+	AllowedSnapshotsPerUserHourValues  = []int{0, 3, 5, 10, 20, 50}
+	AllowedSnapshotsPerServerDayValues = []int{0, 25, 50, 100, 250, 500, 1000}
+	AllowedSnapshotCooldownValues      = []int{0, 10, 30, 60, 300}
+
+	// Only people who can manage channels can turn on auto-archive
+	ManageChannelsPermission = int64(discordgo.PermissionManageChannels)
+	AllowedInDMs             = false
+
+	AllowedJSDelayValues = []int{0, 5, 10, 20, 30}
+	MinAllowedJSDelay    = float64(0)
+	MaxAllowedJSDelay    = float64(30)
+
 	// Enabled takes a boolean and returns "enabled" or "disabled"
 	Enabled = map[bool]string{
 		true:  "enabled",
@@ -103,6 +209,119 @@ var (
 					Type:        discordgo.ApplicationCommandOptionBoolean,
 					Required:    true,
 				},
+				{
+					Name:        OutlinksOption,
+					Description: "Also archive pages the URL links to (slower)",
+					Type:        discordgo.ApplicationCommandOptionBoolean,
+				},
+				{
+					Name:        ScreenshotOption,
+					Description: "Capture a screenshot of the page",
+					Type:        discordgo.ApplicationCommandOptionBoolean,
+				},
+				{
+					Name:        SkipIfArchivedWithinOption,
+					Description: "Use an existing snapshot instead if there's one newer than this many hours",
+					Type:        discordgo.ApplicationCommandOptionInteger,
+					MinValue:    &MinAllowedSkipIfArchivedWithin,
+					MaxValue:    MaxAllowedSkipIfArchivedWithin,
+				},
+				{
+					Name:        JSDelayOption,
+					Description: "Seconds to let JavaScript run on the page before capturing it",
+					Type:        discordgo.ApplicationCommandOptionInteger,
+					MinValue:    &MinAllowedJSDelay,
+					MaxValue:    MaxAllowedJSDelay,
+				},
+				{
+					Name:        DateOption,
+					Description: "Find the snapshot closest to this date (2006-01-02 or 2006-01-02 15:04)",
+					Type:        discordgo.ApplicationCommandOptionString,
+				},
+			},
+		},
+		{
+			Name:        Snapshots,
+			Description: "List the Wayback Machine captures of a URL",
+			Type:        discordgo.ChatApplicationCommand,
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Name:        UrlOption,
+					Description: "URL to list captures for",
+					Type:        discordgo.ApplicationCommandOptionString,
+					Required:    true,
+				},
+				{
+					Name:        StatusOption,
+					Description: "Only list captures with this HTTP status code",
+					Type:        discordgo.ApplicationCommandOptionInteger,
+					MinValue:    &MinAllowedStatusCode,
+					MaxValue:    MaxAllowedStatusCode,
+				},
+				{
+					Name:        YearOption,
+					Description: "Only list captures from this year",
+					Type:        discordgo.ApplicationCommandOptionInteger,
+					MinValue:    &MinAllowedYear,
+					MaxValue:    MaxAllowedYear,
+				},
+			},
+		},
+		{
+			Name:        ArchiveSite,
+			Description: "Archive a page and the pages on the same site it links to",
+			Type:        discordgo.ChatApplicationCommand,
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Name:        UrlOption,
+					Description: "URL of the page to start from",
+					Type:        discordgo.ApplicationCommandOptionString,
+					Required:    true,
+				},
+				{
+					Name:        DepthOption,
+					Description: "How many links away from the page to go (default 1, limited by the bot owner)",
+					Type:        discordgo.ApplicationCommandOptionInteger,
+					MinValue:    &MinAllowedCrawlDepth,
+					MaxValue:    MaxAllowedCrawlDepth,
+				},
+				{
+					Name:        PagesOption,
+					Description: "Most pages to archive (limited by the bot owner)",
+					Type:        discordgo.ApplicationCommandOptionInteger,
+					MinValue:    &MinAllowedCrawlPages,
+					MaxValue:    MaxAllowedCrawlPages,
+				},
+				{
+					Name:        TakeNewSnapshotOption,
+					Description: "Whether to take new snapshots even if pages were archived before",
+					Type:        discordgo.ApplicationCommandOptionBoolean,
+				},
+			},
+		},
+		{
+			Name:        Diff,
+			Description: "Compare the text of two Wayback Machine captures of a URL",
+			Type:        discordgo.ChatApplicationCommand,
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Name:        UrlOption,
+					Description: "URL to compare captures of",
+					Type:        discordgo.ApplicationCommandOptionString,
+					Required:    true,
+				},
+				{
+					Name:        FromOption,
+					Description: "Timestamp (20060102150405 or the start of one) or date of the older capture",
+					Type:        discordgo.ApplicationCommandOptionString,
+					Required:    true,
+				},
+				{
+					Name:        ToOption,
+					Description: "Timestamp (20060102150405 or the start of one) or date of the newer capture",
+					Type:        discordgo.ApplicationCommandOptionString,
+					Required:    true,
+				},
 			},
 		},
 		{
@@ -117,6 +336,36 @@ var (
 			Name: ArchiveMessageNewSnapshot,
 			Type: discordgo.MessageApplicationCommand,
 		},
+		{
+			Name: ArchiveMessageAtDate,
+			Type: discordgo.MessageApplicationCommand,
+		},
+		{
+			Name:                     AutoArchive,
+			Description:              "Archive every link posted in a channel",
+			Type:                     discordgo.ChatApplicationCommand,
+			DefaultMemberPermissions: &ManageChannelsPermission,
+			DMPermission:             &AllowedInDMs,
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Name:        EnabledOption,
+					Description: "Whether links posted in the channel are archived",
+					Type:        discordgo.ApplicationCommandOptionBoolean,
+					Required:    true,
+				},
+				{
+					Name:         ChannelOption,
+					Description:  "Channel to change (default this one)",
+					Type:         discordgo.ApplicationCommandOptionChannel,
+					ChannelTypes: []discordgo.ChannelType{discordgo.ChannelTypeGuildText, discordgo.ChannelTypeGuildNews},
+				},
+				{
+					Name:        IncludeBotsOption,
+					Description: "Also archive links posted by bots and webhooks, like RSS feeds",
+					Type:        discordgo.ApplicationCommandOptionBoolean,
+				},
+			},
+		},
 		{
 			Name:        Settings,
 			Description: "Change settings",
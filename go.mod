@@ -10,6 +10,7 @@ require (
 	github.com/mvdan/xurls v1.1.0
 	github.com/sirupsen/logrus v1.9.3
 	github.com/tyzbit/go-archive v0.0.0-20230720150823-69f1618c0490
+	golang.org/x/net v0.25.0
 	gorm.io/driver/mysql v1.6.0
 	gorm.io/driver/sqlite v1.6.0
 	gorm.io/gorm v1.30.2
@@ -50,7 +51,6 @@ require (
 	github.com/ugorji/go/codec v1.2.12 // indirect
 	golang.org/x/arch v0.8.0 // indirect
 	golang.org/x/crypto v0.23.0 // indirect
-	golang.org/x/net v0.25.0 // indirect
 	golang.org/x/sys v0.20.0 // indirect
 	golang.org/x/text v0.20.0 // indirect
 	google.golang.org/protobuf v1.34.1 // indirect
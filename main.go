@@ -29,6 +29,10 @@ var (
 		&bot.ServerRegistration{},
 		&bot.ServerConfig{},
 		&bot.ArchiveEvent{},
+		&bot.ArchiveJob{},
+		&bot.ScheduledTask{},
+		&bot.SnapshotUsage{},
+		&bot.ChannelConfig{},
 	}
 
 	sqlitePath      string        = "/var/go-discord-archiver/local.sqlite"
@@ -114,12 +118,16 @@ func main() {
 	// it for controlling the bot. db is the database object, dg is the
 	// discordgo object
 	archiveBot := bot.ArchiverBot{
-		DB:     db,
-		DG:     dg,
-		Config: config,
+		DB:        db,
+		DG:        dg,
+		Config:    config,
+		Providers: bot.NewArchiveProviders(config),
 	}
 
 	// Set up DB if necessary
+	if err := bot.MigrateChannelConfigs(db); err != nil {
+		log.Fatal("unable to migrate channel configs, err: ", err)
+	}
 	for _, schemaType := range allSchemaTypes {
 		err := db.AutoMigrate(schemaType)
 		if err != nil {
@@ -141,8 +149,23 @@ func main() {
 	// some intents require additional permissions, which must be granted
 	// to the bot when it's added or after the fact by a guild admin
 	discordIntents := discordgo.IntentsGuilds
+	// Auto-archive reads every message in the channels it's turned on in,
+	// which needs the message content intent turned on for the bot
+	if config.AutoArchive {
+		dg.AddHandler(archiveBot.MessageCreateHandler)
+		discordIntents |= discordgo.IntentsGuildMessages | discordgo.IntentsMessageContent
+	}
 	dg.Identify.Intents = discordIntents
 
+	// Archive requests are handled in the background, starting with any
+	// that didn't finish before the last restart. The workers are started
+	// before any interactions come in, since those queue jobs for them
+	archiveBot.StartArchiveWorkers()
+
+	// Delayed work like removing retry buttons, including any that was
+	// waiting when the bot stopped
+	archiveBot.StartScheduler()
+
 	// Open a websocket connection to Discord and begin listening
 	if err := dg.Open(); err != nil {
 		log.Fatal("error opening connection to discord: ", err)
@@ -158,4 +181,8 @@ func main() {
 	sc := make(chan os.Signal, 1)
 	signal.Notify(sc, syscall.SIGINT, syscall.SIGTERM, os.Interrupt, os.Kill)
 	<-sc
+
+	// Let running work finish and tell anyone still waiting that the bot
+	// is restarting before the session is closed
+	archiveBot.Shutdown()
 }